@@ -0,0 +1,167 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+)
+
+const serverShutdownTimeout = 5 * time.Second
+
+// Cache is the app cache administered by the server
+type Cache interface {
+	GetAllApps() (map[string]*cache.App, error)
+	GetCachedApp(appGuid string) (*cache.App, bool)
+	RefreshApp(appGuid string) (*cache.App, error)
+	ManuallyInvalidateCaches() error
+	MissingApps() []string
+	ClearMissingApps()
+}
+
+// Server exposes the app cache to operators. Every request must carry the
+// token as a bearer token:
+//
+//	GET    /cache/apps                 cached apps by GUID
+//	GET    /cache/apps/{guid}          one cached app
+//	POST   /cache/apps/{guid}/refresh  fetch one app, its space and org again
+//	POST   /cache/refresh              fetch all apps, spaces and orgs again
+//	GET    /cache/missing              GUIDs of the apps recorded as missing
+//	DELETE /cache/missing              forget the missing apps
+type Server struct {
+	cache    Cache
+	token    string
+	logger   lager.Logger
+	server   *http.Server
+	listener net.Listener
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func NewServer(addr, token string, appCache Cache, logger lager.Logger) *Server {
+	s := &Server{cache: appCache, token: token, logger: logger}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /cache/apps", s.listApps)
+	mux.HandleFunc("GET /cache/apps/{guid}", s.getApp)
+	mux.HandleFunc("POST /cache/apps/{guid}/refresh", s.refreshApp)
+	mux.HandleFunc("POST /cache/refresh", s.refreshAll)
+	mux.HandleFunc("GET /cache/missing", s.listMissing)
+	mux.HandleFunc("DELETE /cache/missing", s.clearMissing)
+
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           s.authenticate(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Start listens on the configured address and serves in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("Admin server exited", err)
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return s.server.Addr
+	}
+	return s.listener.Addr().String()
+}
+
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			s.write(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) listApps(w http.ResponseWriter, r *http.Request) {
+	apps, err := s.cache.GetAllApps()
+	if err != nil {
+		s.write(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	s.write(w, http.StatusOK, apps)
+}
+
+func (s *Server) getApp(w http.ResponseWriter, r *http.Request) {
+	app, ok := s.cache.GetCachedApp(r.PathValue("guid"))
+	if !ok {
+		s.write(w, http.StatusNotFound, errorResponse{Error: "app not cached"})
+		return
+	}
+	s.write(w, http.StatusOK, app)
+}
+
+func (s *Server) refreshApp(w http.ResponseWriter, r *http.Request) {
+	guid := r.PathValue("guid")
+	app, err := s.cache.RefreshApp(guid)
+	if err != nil {
+		status := http.StatusBadGateway
+		if cache.IsResourceNotFound(err) {
+			status = http.StatusNotFound
+		}
+		s.write(w, status, errorResponse{Error: err.Error()})
+		return
+	}
+	s.logger.Info("Refreshed app via admin API", lager.Data{"cf_app_id": guid})
+	s.write(w, http.StatusOK, app)
+}
+
+func (s *Server) refreshAll(w http.ResponseWriter, r *http.Request) {
+	if err := s.cache.ManuallyInvalidateCaches(); err != nil {
+		s.write(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+		return
+	}
+	s.logger.Info("Refreshed app cache via admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listMissing(w http.ResponseWriter, r *http.Request) {
+	s.write(w, http.StatusOK, s.cache.MissingApps())
+}
+
+func (s *Server) clearMissing(w http.ResponseWriter, r *http.Request) {
+	s.cache.ClearMissingApps()
+	s.logger.Info("Cleared missing apps via admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) write(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.logger.Error("Failed to write admin response", err)
+	}
+}
@@ -0,0 +1,118 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/admin"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		client   *testing.AppClientMock
+		appCache *cache.Boltdb
+		server   *Server
+		request  func(method, path, token string) (int, string)
+	)
+
+	BeforeEach(func() {
+		client = testing.NewAppClientMock(5)
+
+		var err error
+		appCache, err = cache.NewBoltdb(client, &cache.BoltdbConfig{
+			IgnoreMissingApps: true,
+			OrgSpaceCacheTTL:  time.Hour,
+			Store:             cache.NewNopStore(),
+			Logger:            lager.NewLogger("test"),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(appCache.Open()).To(Succeed())
+
+		server = NewServer("127.0.0.1:0", "secret", appCache, lager.NewLogger("test"))
+		Expect(server.Start()).To(Succeed())
+
+		request = func(method, path, token string) (int, string) {
+			req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", server.Addr(), path), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return resp.StatusCode, string(body)
+		}
+	})
+
+	AfterEach(func() {
+		server.Stop()
+		appCache.Close()
+	})
+
+	It("requires the token", func() {
+		code, _ := request("GET", "/cache/apps", "")
+		Expect(code).To(Equal(http.StatusUnauthorized))
+
+		code, _ = request("GET", "/cache/apps", "wrong")
+		Expect(code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("lists and looks up cached apps", func() {
+		code, body := request("GET", "/cache/apps", "secret")
+		Expect(code).To(Equal(http.StatusOK))
+		var apps map[string]map[string]interface{}
+		Expect(json.Unmarshal([]byte(body), &apps)).To(Succeed())
+		Expect(apps).To(HaveLen(5))
+
+		code, body = request("GET", "/cache/apps/cf_app_id_2", "secret")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring(`"Name":"cf_app_name_2"`))
+
+		code, _ = request("GET", "/cache/apps/unknown", "secret")
+		Expect(code).To(Equal(http.StatusNotFound))
+	})
+
+	It("refreshes one app or all of them", func() {
+		client.RenameApp("cf_app_id_2", "renamed")
+		client.Rename("cf_space_id_2", "renamed_space")
+
+		code, body := request("POST", "/cache/apps/cf_app_id_2/refresh", "secret")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring(`"Name":"renamed"`))
+		Expect(body).To(ContainSubstring(`"SpaceName":"renamed_space"`))
+
+		client.RenameApp("cf_app_id_3", "renamed_too")
+		code, _ = request("POST", "/cache/refresh", "secret")
+		Expect(code).To(Equal(http.StatusNoContent))
+		app, ok := appCache.GetCachedApp("cf_app_id_3")
+		Expect(ok).To(BeTrue())
+		Expect(app.Name).To(Equal("renamed_too"))
+	})
+
+	It("shows and clears the missing apps", func() {
+		_, err := appCache.GetApp("unknown")
+		Expect(err).To(HaveOccurred())
+
+		code, body := request("GET", "/cache/missing", "secret")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(MatchJSON(`["unknown"]`))
+
+		code, _ = request("DELETE", "/cache/missing", "secret")
+		Expect(code).To(Equal(http.StatusNoContent))
+		Expect(appCache.MissingApps()).To(BeEmpty())
+
+		client.CreateApp("unknown", "cf_space_id_1")
+		code, body = request("POST", "/cache/apps/unknown/refresh", "secret")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring(`"SpaceName":"cf_space_name_1"`))
+	})
+})
@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/monitoring"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+)
+
+var ErrAppPending = errors.New("App metadata is being fetched in the background")
+
+// MemoryCache is implemented by caches which can tell whether an app is in
+// memory, so looking it up does not block on remote
+type MemoryCache interface {
+	GetCachedApp(appGuid string) (*App, bool)
+}
+
+// GetCachedApp returns the app when the cache has it in memory. Caches which
+// can not tell have nothing in memory
+func GetCachedApp(c Cache, appGuid string) (*App, bool) {
+	if memory, ok := c.(MemoryCache); ok {
+		return memory.GetCachedApp(appGuid)
+	}
+	return nil, false
+}
+
+// MissingCache is implemented by caches which remember apps not found on
+// remote, so they are not looked up again on every event
+type MissingCache interface {
+	MissingApp(appGuid string) error
+}
+
+// MissingApp returns the error of the app while the cache does not look it
+// up again. Caches which can not tell never skip a lookup
+func MissingApp(c Cache, appGuid string) error {
+	if missing, ok := c.(MissingCache); ok {
+		return missing.MissingApp(appGuid)
+	}
+	return nil
+}
+
+// lookup is an app lookup in flight, shared by all callers of its GUID
+type lookup struct {
+	done chan struct{}
+	app  *App
+	err  error
+}
+
+// Async fetches apps missing from memory in the background, one lookup per
+// GUID however many events wait for it and at most maxLookups at once.
+// Callers wait up to the bound for the lookup, then get ErrAppPending while
+// it goes on. While all lookups are busy ErrAppPending is returned right away
+type Async struct {
+	cache      Cache
+	wait       time.Duration
+	maxLookups int
+
+	lock    sync.Mutex
+	lookups map[string]*lookup
+	closed  bool
+	wg      sync.WaitGroup
+
+	pending utils.Counter
+}
+
+func NewAsync(cache Cache, wait time.Duration, maxLookups int) *Async {
+	return &Async{
+		cache:      cache,
+		wait:       wait,
+		maxLookups: maxLookups,
+		lookups:    make(map[string]*lookup),
+		pending:    monitoring.RegisterCounter("nozzle.cache.lookup.pending.count", utils.UintType),
+	}
+}
+
+func (c *Async) Open() error {
+	return c.cache.Open()
+}
+
+// Close waits for the lookups in flight, so they do not use the wrapped
+// cache once it is closed
+func (c *Async) Close() error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.lock.Unlock()
+
+	c.wg.Wait()
+	return c.cache.Close()
+}
+
+func (c *Async) GetCachedApp(appGuid string) (*App, bool) {
+	return GetCachedApp(c.cache, appGuid)
+}
+
+func (c *Async) MissingApp(appGuid string) error {
+	return MissingApp(c.cache, appGuid)
+}
+
+func (c *Async) GetAllApps() (map[string]*App, error) {
+	return c.cache.GetAllApps()
+}
+
+func (c *Async) GetApp(appGuid string) (*App, error) {
+	if _, cached := GetCachedApp(c.cache, appGuid); cached {
+		return c.cache.GetApp(appGuid)
+	}
+	if err := MissingApp(c.cache, appGuid); err != nil {
+		return nil, err
+	}
+
+	l := c.lookup(appGuid)
+	if l == nil {
+		c.pending.Add(uint64(1))
+		return nil, ErrAppPending
+	}
+	if c.wait > 0 {
+		timer := time.NewTimer(c.wait)
+		defer timer.Stop()
+		select {
+		case <-l.done:
+			return l.app, l.err
+		case <-timer.C:
+		}
+	} else {
+		select {
+		case <-l.done:
+			return l.app, l.err
+		default:
+		}
+	}
+
+	c.pending.Add(uint64(1))
+	return nil, ErrAppPending
+}
+
+// lookup joins the lookup of the app in flight, or starts it. It returns nil
+// when maxLookups are already in flight or the cache is closed
+func (c *Async) lookup(appGuid string) *lookup {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if l, ok := c.lookups[appGuid]; ok {
+		return l
+	}
+	if c.closed || (c.maxLookups > 0 && len(c.lookups) >= c.maxLookups) {
+		return nil
+	}
+
+	l := &lookup{done: make(chan struct{})}
+	c.lookups[appGuid] = l
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		l.app, l.err = c.cache.GetApp(appGuid)
+
+		c.lock.Lock()
+		delete(c.lookups, appGuid)
+		c.lock.Unlock()
+		close(l.done)
+	}()
+	return l
+}
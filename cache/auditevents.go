@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+// auditEventTypes are the CAPI audit events changing cached app, space or
+// org info
+var auditEventTypes = []string{
+	"audit.app.create",
+	"audit.app.update",
+	"audit.app.delete-request",
+	"audit.app.start",
+	"audit.app.stop",
+	"audit.app.droplet.mapped",
+	"audit.app.process.scale",
+	"audit.space.update",
+	"audit.space.delete-request",
+	"audit.organization.update",
+	"audit.organization.delete-request",
+}
+
+// watchAuditEvents perodically polls the audit events created since the
+// last poll and invalidates the affected apps, spaces and orgs, which are
+// fetched again on their next event
+func (c *Boltdb) watchAuditEvents() { // nosemgrep false-positive : Execution of ticker `ticker` more times than desired will not be causing any issues for function "watchAuditEvents".
+	ticker := time.NewTicker(c.config.AuditEventInterval)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.pollAuditEvents(); err != nil {
+					c.config.Logger.Error("Unable to fetch audit events from remote", err)
+				}
+			case <-c.closing:
+				return
+			}
+		}
+	}()
+}
+
+// pollAuditEvents applies the audit events created at or after the latest
+// one seen. Events at that time which were already applied are skipped
+func (c *Boltdb) pollAuditEvents() error {
+	events, err := c.appClient.ListAuditEvents(auditEventTypes, c.auditEventsSince)
+	if err != nil {
+		return err
+	}
+
+	since, seen := c.auditEventsSince, c.auditEventsSeen
+	for _, event := range events {
+		if _, ok := c.auditEventsSeen[event.GUID]; ok {
+			continue
+		}
+		c.applyAuditEvent(event)
+
+		if event.CreatedAt.After(since) {
+			since = event.CreatedAt
+			seen = make(map[string]struct{})
+		}
+		if event.CreatedAt.Equal(since) {
+			seen[event.GUID] = struct{}{}
+		}
+	}
+	c.auditEventsSince, c.auditEventsSeen = since, seen
+
+	return nil
+}
+
+func (c *Boltdb) applyAuditEvent(event *resource.AuditEvent) {
+	guid := event.Target.GUID
+	c.config.Logger.Debug(fmt.Sprintf("Invalidating %s %s after %s", event.Target.Type, guid, event.Type))
+	c.auditInvalidations.Add(uint64(1))
+
+	switch {
+	case strings.HasPrefix(event.Type, "audit.app."):
+		// The database goes first, so a lookup between the two can not load
+		// the deleted app from it into memory again
+		if event.Type == "audit.app.delete-request" {
+			c.removeAppFromDatabase(guid)
+		}
+		c.cache.remove(guid)
+		c.lock.Lock()
+		delete(c.missingApps, guid)
+		c.lock.Unlock()
+
+	case strings.HasPrefix(event.Type, "audit.space."):
+		c.lock.Lock()
+		delete(c.spaceNameCache, guid)
+		c.lock.Unlock()
+
+	case strings.HasPrefix(event.Type, "audit.organization."):
+		c.lock.Lock()
+		delete(c.orgNameCache, guid)
+		c.lock.Unlock()
+	}
+}
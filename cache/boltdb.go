@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"maps"
+	"math"
+	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
@@ -13,8 +16,6 @@ import (
 
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/monitoring"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
-	json "github.com/mailru/easyjson"
-	bolt "go.etcd.io/bbolt"
 )
 
 // IsResourceNotFound checks whether the error represents a definitive
@@ -27,6 +28,9 @@ func IsResourceNotFound(err error) bool {
 
 const (
 	APP_BUCKET = "AppBucket"
+
+	// appPageSize is the largest page of apps the Cloud Controller returns
+	appPageSize = 5000
 )
 
 var (
@@ -43,6 +47,18 @@ type BoltdbConfig struct {
 	AppLimits               int
 	UseEnvVarForSplunkIndex bool
 	UseLabelsForSplunkIndex bool
+	FetchProcesses          bool          // process types and instance count, one CAPI request per app
+	FetchDroplet            bool          // current droplet and detected buildpacks, one CAPI request per app
+	RequestRate             float64       // Cloud Controller requests per second, 0 is unlimited
+	IncrementalRefresh      bool          // only fetch apps updated since the last sync every AppCacheTTL
+	ReconcileInterval       time.Duration // full refresh interval in incremental mode, 0 is never
+	AuditEventInterval      time.Duration // audit event poll interval, 0 disables the watcher
+	MaxApps                 int           // most recently used apps kept in memory, 0 is unbounded
+	Store                   Store         // persistent layer, a BoltStore at Path when nil
+	MissingAppBackoff       time.Duration // first retry delay of a missing app, 0 waits for MissingAppCacheTTL
+	MissingAppMaxBackoff    time.Duration // longest retry delay of a missing app, 0 is unbounded
+	BreakerFailures         int           // consecutive CAPI failures suspending requests, 0 disables the breaker
+	BreakerCooldown         time.Duration // how long requests are suspended
 
 	Logger lager.Logger
 }
@@ -50,6 +66,8 @@ type BoltdbConfig struct {
 // Org is a CAPI org
 type Org struct {
 	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
 	LastUpdated time.Time
 }
 
@@ -57,16 +75,34 @@ type Org struct {
 type Space struct {
 	Name        string
 	OrgGUID     string
+	Labels      map[string]string
+	Annotations map[string]string
 	LastUpdated time.Time
 }
 
+// missingApp is a negative cache entry. The app is looked up again from
+// remote after until, or never when zero. The entry, with its failure count,
+// is forgotten after expires, or never when zero
+type missingApp struct {
+	until    time.Time
+	expires  time.Time
+	failures int
+}
+
+// Boltdb is the app cache, kept in memory and persisted to its store
 type Boltdb struct {
 	appClient AppClient
-	appdb     *bolt.DB
+	store     Store
 
 	lock        sync.RWMutex
-	cache       map[string]*App
-	missingApps map[string]struct{}
+	cache       *appLRU
+	missingApps map[string]missingApp
+
+	syncedUntil  time.Time // latest update time of the apps fetched by listing
+	reconciledAt time.Time // time of the last full listing
+
+	auditEventsSince time.Time           // creation time of the latest audit event applied
+	auditEventsSeen  map[string]struct{} // audit events applied at auditEventsSince
 
 	orgNameCache   map[string]Org   // caches org guid->org name mapping
 	spaceNameCache map[string]Space // caches space guid->space name mapping
@@ -80,13 +116,29 @@ type Boltdb struct {
 	remoteCachehit  utils.Counter
 	boltdbCachemiss utils.Counter
 	boltdbCachehit  utils.Counter
+
+	auditInvalidations utils.Counter
 }
 
 func NewBoltdb(client AppClient, config *BoltdbConfig) (*Boltdb, error) {
+	if config.RequestRate > 0 {
+		client = newRateLimitedClient(client, config.RequestRate)
+	}
+	// Outside the rate limiter, so suspended requests don't wait for a token
+	if config.BreakerFailures > 0 {
+		client = newBreakerClient(client, config.BreakerFailures, config.BreakerCooldown)
+	}
+
+	store := config.Store
+	if store == nil {
+		store = NewBoltStore(config.Path)
+	}
+
 	Boltdb := &Boltdb{
 		appClient:       client,
-		cache:           make(map[string]*App),
-		missingApps:     make(map[string]struct{}),
+		store:           store,
+		cache:           newAppLRU(config.MaxApps),
+		missingApps:     make(map[string]missingApp),
 		orgNameCache:    make(map[string]Org),
 		spaceNameCache:  make(map[string]Space),
 		closing:         make(chan struct{}),
@@ -97,22 +149,16 @@ func NewBoltdb(client AppClient, config *BoltdbConfig) (*Boltdb, error) {
 		remoteCachehit:  monitoring.RegisterCounter("nozzle.cache.remote.hit", utils.UintType),
 		boltdbCachemiss: monitoring.RegisterCounter("nozzle.cache.boltdb.miss", utils.UintType),
 		boltdbCachehit:  monitoring.RegisterCounter("nozzle.cache.boltdb.hit", utils.UintType),
+
+		auditInvalidations: monitoring.RegisterCounter("nozzle.cache.audit.invalidations", utils.UintType),
 	}
 
 	return Boltdb, nil
 }
 
 func (c *Boltdb) Open() error {
-	// Open bolt db
-	db, err := bolt.Open(c.config.Path, 0600, &bolt.Options{Timeout: 5 * time.Second})
-	if err != nil {
-		c.config.Logger.Error("Failed to open boltdb: ", err)
-		return err
-	}
-	c.appdb = db
-
-	if err := c.createBucket(); err != nil {
-		c.config.Logger.Error("Failed to create bucket: ", err)
+	if err := c.store.Open(); err != nil {
+		c.config.Logger.Error("Failed to open app cache store: ", err)
 		return err
 	}
 
@@ -124,11 +170,17 @@ func (c *Boltdb) Open() error {
 		c.invalidateMissingAppCache()
 	}
 
+	if c.config.AuditEventInterval != time.Duration(0) {
+		c.auditEventsSince = time.Now()
+		c.auditEventsSeen = make(map[string]struct{})
+		c.watchAuditEvents()
+	}
+
 	return c.populateCache()
 }
 
 func (c *Boltdb) populateCache() error {
-	apps, err := c.getAllAppsFromBoltDB()
+	apps, err := c.store.GetAll()
 	if err != nil {
 		return err
 	}
@@ -141,7 +193,7 @@ func (c *Boltdb) populateCache() error {
 		}
 	}
 
-	c.cache = apps
+	c.cache.replace(apps)
 
 	return nil
 }
@@ -152,7 +204,7 @@ func (c *Boltdb) Close() error {
 	// Wait for background goroutine exit
 	c.wg.Wait()
 
-	return c.appdb.Close()
+	return c.store.Close()
 }
 
 // GetApp tries to retrieve the app info from in-memory cache. If it finds the app then it returns.
@@ -168,7 +220,11 @@ func (c *Boltdb) Close() error {
 // serve last-known-good metadata until the remote recovers.
 //
 // If not found anywhere and IgnoreMissingApps config is enabled, the app will be
-// added to missingApps cache.
+// added to missingApps cache. With MissingAppBackoff it is looked up again after
+// a jittered delay, doubling with every failed lookup up to MissingAppMaxBackoff.
+//
+// While the circuit breaker suspends requests, ErrCircuitOpen is returned for apps
+// neither in memory nor in BoltDB, without recording them as missing.
 func (c *Boltdb) GetApp(appGuid string) (*App, error) {
 	app, err := c.getAppFromCache(appGuid)
 	if err != nil {
@@ -195,19 +251,15 @@ func (c *Boltdb) GetApp(appGuid string) (*App, error) {
 			// and record in missingApps so we don't keep hitting the API.
 			c.config.Logger.Debug(fmt.Sprintf("Starting removal of app %s from database", appGuid))
 			c.removeAppFromDatabase(appGuid)
-			c.lock.Lock()
-			c.missingApps[appGuid] = struct{}{}
-			c.lock.Unlock()
+			c.markMissing(appGuid)
 			return nil, ErrMissingAndIgnored
 		}
 
 		// Transient error — fall back to BoltDB for last-known-good data
-		dbApp, _ := c.getAppFromDatabase(appGuid)
+		dbApp, _ := c.store.Get(appGuid)
 		if dbApp != nil {
 			c.config.Logger.Debug(fmt.Sprintf("Using old app info for cf_app_id %s", appGuid))
-			c.lock.Lock()
-			c.cache[appGuid] = dbApp
-			c.lock.Unlock()
+			c.cache.add(dbApp)
 			c.fillOrgAndSpace(dbApp)
 			return dbApp, nil
 		}
@@ -217,19 +269,18 @@ func (c *Boltdb) GetApp(appGuid string) (*App, error) {
 
 	if err != nil {
 		c.boltdbCachemiss.Add(uint64(1))
-		if c.config.IgnoreMissingApps {
-			c.lock.Lock()
-			c.missingApps[appGuid] = struct{}{}
-			c.lock.Unlock()
+		if c.config.IgnoreMissingApps && !errors.Is(err, ErrCircuitOpen) {
+			c.markMissing(appGuid)
 		}
 		return nil, err
 	} else {
 		c.boltdbCachehit.Add(uint64(1))
 	}
 
-	// Add to in-memory cache
+	// Add to in-memory cache, forgetting earlier failed lookups
+	c.cache.add(app)
 	c.lock.Lock()
-	c.cache[app.Guid] = app
+	delete(c.missingApps, appGuid)
 	c.lock.Unlock()
 
 	return app, nil
@@ -237,15 +288,7 @@ func (c *Boltdb) GetApp(appGuid string) (*App, error) {
 
 // GetAllApps returns all apps info
 func (c *Boltdb) GetAllApps() (map[string]*App, error) {
-	c.lock.RLock()
-	apps := make(map[string]*App, len(c.cache))
-	for _, app := range c.cache {
-		dup := *app
-		apps[dup.Guid] = &dup
-	}
-	c.lock.RUnlock()
-
-	return apps, nil
+	return c.cache.copies(), nil
 }
 
 func (c *Boltdb) ManuallyInvalidateCaches() error {
@@ -258,118 +301,239 @@ func (c *Boltdb) ManuallyInvalidateCaches() error {
 	if err != nil {
 		return err
 	}
-	c.lock.Lock()
-	c.cache = apps
-	c.lock.Unlock()
+	c.cache.replace(apps)
 	return nil
 }
 
-func (c *Boltdb) getAppFromCache(appGuid string) (*App, error) {
-	c.lock.RLock()
-	if app, ok := c.cache[appGuid]; ok {
-		// in in-memory cache
-		c.lock.RUnlock()
-		return app, nil
+// GetCachedApp returns the app from the in-memory cache, without fetching
+// it from remote
+func (c *Boltdb) GetCachedApp(appGuid string) (*App, bool) {
+	app, ok := c.cache.get(appGuid)
+	if !ok {
+		return nil, false
 	}
+	dup := *app
+	return &dup, true
+}
 
-	_, alreadyMissed := c.missingApps[appGuid]
-	if c.config.IgnoreMissingApps && alreadyMissed {
-		c.lock.RUnlock()
-		return nil, ErrMissingAlreadyCached
+// MissingApps returns the sorted GUIDs of the apps recorded as missing
+func (c *Boltdb) MissingApps() []string {
+	c.lock.RLock()
+	guids := make([]string, 0, len(c.missingApps))
+	for guid := range c.missingApps {
+		guids = append(guids, guid)
 	}
 	c.lock.RUnlock()
 
-	// Didn't find in cache and it is not missed or we are not ignoring missed app
-	return nil, nil
+	sort.Strings(guids)
+	return guids
 }
 
-func (c *Boltdb) getAllAppsFromBoltDB() (map[string]*App, error) {
-	var allData [][]byte
-	c.appdb.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(APP_BUCKET))
-		b.ForEach(func(guid []byte, v []byte) error {
-			allData = append(allData, v)
-			return nil
-		})
-		return nil
-	})
+// ClearMissingApps forgets the missing apps, so they are queried from
+// remote on their next event
+func (c *Boltdb) ClearMissingApps() {
+	c.lock.Lock()
+	c.missingApps = make(map[string]missingApp)
+	c.lock.Unlock()
+}
 
-	apps := make(map[string]*App, len(allData))
-	for i := range allData {
-		var app App
-		err := json.Unmarshal(allData[i], &app)
-		if err != nil {
-			return nil, err
+// markMissing records the app as missing, backing off its next lookup
+func (c *Boltdb) markMissing(appGuid string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	missing := c.missingApps[appGuid]
+	missing.failures++
+	if c.config.MissingAppBackoff > 0 {
+		missing.until = now.Add(c.missingAppBackoff(missing.failures))
+		now = missing.until
+	}
+	if c.config.MissingAppCacheTTL > 0 {
+		missing.expires = now.Add(c.config.MissingAppCacheTTL)
+	}
+	c.missingApps[appGuid] = missing
+}
+
+// missingAppBackoff doubles the backoff with every failure. Equal jitter
+// spreads the lookups of apps which went missing together
+func (c *Boltdb) missingAppBackoff(failures int) time.Duration {
+	backoff := c.config.MissingAppBackoff
+	limit := c.config.MissingAppMaxBackoff
+	for i := 1; i < failures && (limit == 0 || backoff < limit) && backoff < time.Duration(math.MaxInt64/2); i++ {
+		backoff *= 2
+	}
+	if limit > 0 && backoff > limit {
+		backoff = limit
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// RefreshApp fetches the app, its space and its org from remote into the
+// cache, also when the app was recorded as missing. An app deleted from CF
+// is removed from the cache
+func (c *Boltdb) RefreshApp(appGuid string) (*App, error) {
+	c.lock.Lock()
+	delete(c.missingApps, appGuid)
+	if app, ok := c.cache.get(appGuid); ok {
+		delete(c.spaceNameCache, app.SpaceGuid)
+		delete(c.orgNameCache, app.OrgGuid)
+	}
+	c.lock.Unlock()
+
+	app, err := c.getAppFromRemote(appGuid)
+	if err != nil {
+		if IsResourceNotFound(err) {
+			c.cache.remove(appGuid)
+			c.removeAppFromDatabase(appGuid)
 		}
-		apps[app.Guid] = &app
+		return nil, err
 	}
+	c.cache.add(app)
 
-	return apps, nil
+	dup := *app
+	return &dup, nil
+}
+
+func (c *Boltdb) getAppFromCache(appGuid string) (*App, error) {
+	if app, ok := c.cache.get(appGuid); ok {
+		// in in-memory cache
+		return app, nil
+	}
+
+	// Didn't find in cache and it is not missed or we are not ignoring missed app
+	return nil, c.MissingApp(appGuid)
 }
 
-// getAppFromDatabase will try to get the app from the database and return it.
-func (c *Boltdb) getAppFromDatabase(appGuid string) (*App, error) {
-	var appData []byte
-	c.appdb.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(APP_BUCKET))
+// MissingApp returns ErrMissingAlreadyCached while the app is recorded as
+// missing and not due to be looked up again, nil otherwise
+func (c *Boltdb) MissingApp(appGuid string) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 
-		appData = b.Get([]byte(appGuid))
-		return nil
-	})
-	if appData == nil {
-		return nil, nil
+	now := time.Now()
+	missing, alreadyMissed := c.missingApps[appGuid]
+	if alreadyMissed && !missing.until.IsZero() && now.After(missing.until) {
+		alreadyMissed = false
 	}
-	var app App
-	if err := json.Unmarshal(appData, &app); err != nil {
-		return nil, err
+	if alreadyMissed && !missing.expires.IsZero() && now.After(missing.expires) {
+		alreadyMissed = false
+	}
+	if c.config.IgnoreMissingApps && alreadyMissed {
+		return ErrMissingAlreadyCached
 	}
-	return &app, nil
+	return nil
 }
 
 func (c *Boltdb) removeAppFromDatabase(appGuid string) {
-	c.appdb.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(APP_BUCKET))
-		return b.Delete([]byte(appGuid))
-	})
+	if err := c.store.Delete(appGuid); err != nil {
+		c.config.Logger.Error(fmt.Sprintf("Failed to remove app %s from database", appGuid), err)
+		return
+	}
 	c.config.Logger.Info(fmt.Sprintf("Removed app %s from database", appGuid))
 }
 
+// getAllAppsFromRemote pages through the apps with their spaces and orgs
+// included, so no per app space and org requests are needed. With AppLimits
+// only that many most recently updated apps are loaded, others are fetched
+// when their first event arrives. Apps no longer listed are removed from
+// the database, unless the listing was limited and so may leave out apps
+// which still exist
 func (c *Boltdb) getAllAppsFromRemote() (map[string]*App, error) {
 	c.config.Logger.Info("Retrieving apps from remote")
 
-	cfApps, err := c.appClient.ListApps()
+	now := time.Now()
+	// Apps beyond MaxApps would be evicted right away
+	limit := c.config.AppLimits
+	if c.config.MaxApps > 0 && (limit == 0 || limit > c.config.MaxApps) {
+		limit = c.config.MaxApps
+	}
+	apps, syncedUntil, err := c.listAppsFromRemote(time.Time{}, limit)
 	if err != nil {
 		return nil, err
 	}
 
-	apps := make(map[string]*App, len(cfApps))
-	for i := range cfApps {
-		app := c.fromPCFApp(cfApps[i])
-		apps[app.Guid] = app
+	store := c.store.Replace
+	if limit > 0 {
+		store = c.store.Put
 	}
-
-	if err := c.fillDatabase(apps); err != nil {
+	if err := store(apps); err != nil {
 		return nil, fmt.Errorf("error filling database: %s", err)
 	}
 
+	c.lock.Lock()
+	c.syncedUntil = syncedUntil
+	c.reconciledAt = now
+	c.lock.Unlock()
+
 	c.config.Logger.Info(fmt.Sprintf("Found %d apps", len(apps)))
 
 	return apps, nil
 }
 
-func (c *Boltdb) createBucket() error {
-	return c.appdb.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(APP_BUCKET))
+// getUpdatedAppsFromRemote lists the apps created or updated since the
+// last sync and stores them in the database
+func (c *Boltdb) getUpdatedAppsFromRemote(since time.Time) (map[string]*App, error) {
+	apps, syncedUntil, err := c.listAppsFromRemote(since, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.store.Put(apps); err != nil {
+		return nil, fmt.Errorf("error filling database: %s", err)
+	}
+
+	c.lock.Lock()
+	if syncedUntil.After(c.syncedUntil) {
+		c.syncedUntil = syncedUntil
+	}
+	c.lock.Unlock()
+
+	c.config.Logger.Info(fmt.Sprintf("Found %d apps updated since %s", len(apps), since.Format(time.RFC3339)))
+
+	return apps, nil
+}
+
+// listAppsFromRemote pages through the apps updated since the given time,
+// all apps when zero, up to limit apps when positive. It also returns the
+// latest update time of the listed apps, as set by the Cloud Controller
+func (c *Boltdb) listAppsFromRemote(since time.Time, limit int) (map[string]*App, time.Time, error) {
+	perPage := appPageSize
+	if limit > 0 && limit < perPage {
+		perPage = limit
+	}
+
+	apps := make(map[string]*App)
+	syncedUntil := since
+	for page := 1; ; page++ {
+		result, err := c.appClient.ListAppsPage(page, perPage, since)
 		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
+			return nil, time.Time{}, err
 		}
-		return nil
-	})
+		c.cacheSpacesAndOrgs(result.Spaces, result.Orgs)
+
+		for _, cfApp := range result.Apps {
+			if limit > 0 && len(apps) >= limit {
+				break
+			}
+			if cfApp.UpdatedAt.After(syncedUntil) {
+				syncedUntil = cfApp.UpdatedAt
+			}
+			app := c.fromPCFApp(cfApp)
+			apps[app.Guid] = app
+		}
+
+		if !result.HasNext || (limit > 0 && len(apps) >= limit) {
+			break
+		}
+	}
+
+	return apps, syncedUntil, nil
 }
 
 // invalidateMissingAppCache perodically cleanup inmemory house keeping for
-// not found apps. When the this cache is cleaned up, end clients have chance
-// to retry missing apps
+// not found apps. Each entry is dropped once it expired, so end clients have
+// chance to retry missing apps while the backoff of the others keeps growing
 func (c *Boltdb) invalidateMissingAppCache() { // nosemgrep false-positive : Execution of ticker `ticker` more times than desired will not be causing any issues for function "invalidateMissingAppCache".
 	ticker := time.NewTicker(c.config.MissingAppCacheTTL)
 
@@ -379,9 +543,13 @@ func (c *Boltdb) invalidateMissingAppCache() { // nosemgrep false-positive : Exe
 
 		for {
 			select {
-			case <-ticker.C:
+			case now := <-ticker.C:
 				c.lock.Lock()
-				c.missingApps = make(map[string]struct{})
+				for guid, missing := range c.missingApps {
+					if !missing.expires.IsZero() && now.After(missing.expires) {
+						delete(c.missingApps, guid)
+					}
+				}
 				c.lock.Unlock()
 			case <-c.closing:
 				return
@@ -391,7 +559,8 @@ func (c *Boltdb) invalidateMissingAppCache() { // nosemgrep false-positive : Exe
 }
 
 // invalidateCache perodically fetches a full copy apps info from remote
-// and update boltdb and in-memory cache
+// and update boltdb and in-memory cache. In incremental mode only apps
+// updated since the last sync are fetched in between full reconciliations
 func (c *Boltdb) invalidateCache() { // nosemgrep false-positive : Execution of ticker `ticker` and `orgSpaceTicker` more times than desired will not be causing any issues for function "invalidateCache".
 	ticker := time.NewTicker(c.config.AppCacheTTL)
 	orgSpaceTicker := time.NewTicker(c.config.OrgSpaceCacheTTL)
@@ -403,14 +572,7 @@ func (c *Boltdb) invalidateCache() { // nosemgrep false-positive : Execution of
 		for {
 			select {
 			case <-ticker.C:
-				apps, err := c.getAllAppsFromRemote()
-				if err == nil {
-					c.lock.Lock()
-					c.cache = apps
-					c.lock.Unlock()
-				} else {
-					c.config.Logger.Error("Unable to fetch copy of cache from remote", err)
-				}
+				c.refreshCache()
 			case <-orgSpaceTicker.C:
 				c.lock.Lock()
 				c.orgNameCache = make(map[string]Org)
@@ -423,37 +585,39 @@ func (c *Boltdb) invalidateCache() { // nosemgrep false-positive : Execution of
 	}()
 }
 
-func (c *Boltdb) fillDatabase(apps map[string]*App) error {
-	return c.appdb.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(APP_BUCKET))
+// refreshCache merges the apps updated since the last sync into the cache
+// in incremental mode. Otherwise, before the first sync and every
+// ReconcileInterval the cache is replaced by a full listing, which drops
+// deleted apps
+func (c *Boltdb) refreshCache() {
+	c.lock.RLock()
+	since := c.syncedUntil
+	reconciledAt := c.reconciledAt
+	c.lock.RUnlock()
 
-		// Remove BoltDB entries for apps that no longer exist in the
-		// remote listing, preventing unbounded growth of stale data.
-		var staleKeys [][]byte
-		b.ForEach(func(k, v []byte) error {
-			if _, exists := apps[string(k)]; !exists {
-				staleKeys = append(staleKeys, k)
-			}
-			return nil
-		})
-		for _, k := range staleKeys {
-			if err := b.Delete(k); err != nil {
-				return fmt.Errorf("error deleting stale key: %s", err)
-			}
+	reconcile := c.config.ReconcileInterval != 0 && time.Since(reconciledAt) >= c.config.ReconcileInterval
+	if c.config.IncrementalRefresh && !since.IsZero() && !reconcile {
+		apps, err := c.getUpdatedAppsFromRemote(since)
+		if err != nil {
+			c.config.Logger.Error("Unable to fetch updated apps from remote", err)
+			return
 		}
 
-		// Add/update entries for current apps
-		for _, app := range apps {
-			serialize, err := json.Marshal(app)
-			if err != nil {
-				return fmt.Errorf("error Marshaling data: %s", err)
-			}
-			if err := b.Put([]byte(app.Guid), serialize); err != nil {
-				return fmt.Errorf("error inserting data: %s", err)
-			}
+		c.lock.Lock()
+		for guid, app := range apps {
+			c.cache.add(app)
+			delete(c.missingApps, guid)
 		}
-		return nil
-	})
+		c.lock.Unlock()
+		return
+	}
+
+	apps, err := c.getAllAppsFromRemote()
+	if err != nil {
+		c.config.Logger.Error("Unable to fetch copy of cache from remote", err)
+		return
+	}
+	c.cache.replace(apps)
 }
 
 func (c *Boltdb) fromPCFApp(app *resource.App) *App {
@@ -481,12 +645,43 @@ func (c *Boltdb) fromPCFApp(app *resource.App) *App {
 		IgnoredApp:      c.isOptOut(app.Metadata.Labels),
 		CfAppProperties: appProperties,
 	}
+	cachedApp.Labels, cachedApp.Annotations = metadataValues(app.Metadata)
+	c.fillDeployment(cachedApp, app)
 
 	c.fillOrgAndSpace(cachedApp)
 
 	return cachedApp
 }
 
+// cacheSpacesAndOrgs stores the spaces and orgs included in a page of apps
+func (c *Boltdb) cacheSpacesAndOrgs(spaces []*resource.Space, orgs []*resource.Organization) {
+	now := time.Now()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, cfspace := range spaces {
+		space := Space{
+			Name:        cfspace.Name,
+			LastUpdated: now,
+		}
+		if r := cfspace.Relationships; r != nil && r.Organization != nil && r.Organization.Data != nil {
+			space.OrgGUID = r.Organization.Data.GUID
+		}
+		space.Labels, space.Annotations = metadataValues(cfspace.Metadata)
+		c.spaceNameCache[cfspace.GUID] = space
+	}
+
+	for _, cforg := range orgs {
+		org := Org{
+			Name:        cforg.Name,
+			LastUpdated: now,
+		}
+		org.Labels, org.Annotations = metadataValues(cforg.Metadata)
+		c.orgNameCache[cforg.GUID] = org
+	}
+}
+
 func (c *Boltdb) fillOrgAndSpace(app *App) error {
 	now := time.Now()
 
@@ -505,6 +700,7 @@ func (c *Boltdb) fillOrgAndSpace(app *App) error {
 			OrgGUID:     cfspace.Relationships.Organization.Data.GUID,
 			LastUpdated: now,
 		}
+		space.Labels, space.Annotations = metadataValues(cfspace.Metadata)
 
 		c.lock.Lock()
 		c.spaceNameCache[app.SpaceGuid] = space
@@ -512,6 +708,8 @@ func (c *Boltdb) fillOrgAndSpace(app *App) error {
 	}
 
 	app.SpaceName = space.Name
+	app.SpaceLabels = space.Labels
+	app.SpaceAnnotations = space.Annotations
 	app.OrgGuid = space.OrgGUID
 
 	c.lock.RLock()
@@ -527,6 +725,7 @@ func (c *Boltdb) fillOrgAndSpace(app *App) error {
 			Name:        cforg.Name,
 			LastUpdated: now,
 		}
+		org.Labels, org.Annotations = metadataValues(cforg.Metadata)
 
 		c.lock.Lock()
 		c.orgNameCache[space.OrgGUID] = org
@@ -535,6 +734,8 @@ func (c *Boltdb) fillOrgAndSpace(app *App) error {
 
 	app.OrgGuid = space.OrgGUID
 	app.OrgName = org.Name
+	app.OrgLabels = org.Labels
+	app.OrgAnnotations = org.Annotations
 
 	return nil
 }
@@ -545,13 +746,83 @@ func (c *Boltdb) getAppFromRemote(appGuid string) (*App, error) {
 		return nil, err
 	}
 	app := c.fromPCFApp(cfApp)
-	if err := c.fillDatabase(map[string]*App{app.Guid: app}); err != nil {
+	if err := c.store.Put(map[string]*App{app.Guid: app}); err != nil {
 		return nil, fmt.Errorf("error filling database: %s", err)
 	}
 
 	return app, nil
 }
 
+// fillDeployment sets the state, stack and buildpacks of the app, refined
+// with the current droplet and processes when configured. CAPI errors leave
+// the optional fields empty
+func (c *Boltdb) fillDeployment(cachedApp *App, app *resource.App) {
+	cachedApp.State = app.State
+	cachedApp.Stack = app.Lifecycle.BuildpackData.Stack
+	cachedApp.Buildpacks = app.Lifecycle.BuildpackData.Buildpacks
+
+	if c.config.FetchDroplet {
+		droplet, err := c.appClient.GetCurrentDroplet(app.GUID)
+		if err == nil && droplet != nil {
+			cachedApp.DropletGuid = droplet.GUID
+			if droplet.Stack != "" {
+				cachedApp.Stack = droplet.Stack
+			}
+			if len(droplet.Buildpacks) > 0 {
+				cachedApp.Buildpacks = detectedBuildpacks(droplet.Buildpacks)
+			}
+		}
+	}
+
+	if c.config.FetchProcesses {
+		processes, err := c.appClient.GetAppProcesses(app.GUID)
+		if err == nil {
+			for _, process := range processes {
+				cachedApp.ProcessTypes = append(cachedApp.ProcessTypes, process.Type)
+				cachedApp.InstanceCount += process.Instances
+			}
+			sort.Strings(cachedApp.ProcessTypes)
+		}
+	}
+}
+
+// detectedBuildpacks returns the buildpacks of a droplet as name@version
+func detectedBuildpacks(buildpacks []resource.DetectedBuildpack) []string {
+	names := make([]string, 0, len(buildpacks))
+	for _, bp := range buildpacks {
+		name := bp.Name
+		if bp.BuildpackName != "" {
+			name = bp.BuildpackName
+		}
+		if bp.Version != "" {
+			name += "@" + bp.Version
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// metadataValues returns the labels and annotations which have a value
+func metadataValues(metadata *resource.Metadata) (map[string]string, map[string]string) {
+	if metadata == nil {
+		return nil, nil
+	}
+	return stringValues(metadata.Labels), stringValues(metadata.Annotations)
+}
+
+func stringValues(values map[string]*string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(values))
+	for k, v := range values {
+		if v != nil {
+			result[k] = *v
+		}
+	}
+	return result
+}
+
 func (c *Boltdb) isOptOut(appLabels map[string]*string) bool {
 	if val, ok := appLabels["F2S_DISABLE_LOGGING"]; ok && *val == "true" {
 		return true
@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	json "github.com/mailru/easyjson"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStore keeps the apps in a BoltDB file, which only one process can
+// open at a time
+type BoltStore struct {
+	path  string
+	appdb *bolt.DB
+}
+
+func NewBoltStore(path string) *BoltStore {
+	return &BoltStore{path: path}
+}
+
+func (s *BoltStore) Open() error {
+	db, err := bolt.Open(s.path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	s.appdb = db
+
+	return s.appdb.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(APP_BUCKET))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.appdb.Close()
+}
+
+func (s *BoltStore) GetAll() (map[string]*App, error) {
+	var allData [][]byte
+	s.appdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(APP_BUCKET))
+		b.ForEach(func(guid []byte, v []byte) error {
+			allData = append(allData, v)
+			return nil
+		})
+		return nil
+	})
+
+	apps := make(map[string]*App, len(allData))
+	for i := range allData {
+		var app App
+		err := json.Unmarshal(allData[i], &app)
+		if err != nil {
+			return nil, err
+		}
+		apps[app.Guid] = &app
+	}
+
+	return apps, nil
+}
+
+func (s *BoltStore) Get(appGuid string) (*App, error) {
+	var appData []byte
+	s.appdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(APP_BUCKET))
+
+		appData = b.Get([]byte(appGuid))
+		return nil
+	})
+	if appData == nil {
+		return nil, nil
+	}
+	var app App
+	if err := json.Unmarshal(appData, &app); err != nil {
+		return nil, err
+	}
+	return &app, nil
+}
+
+func (s *BoltStore) Put(apps map[string]*App) error {
+	return s.appdb.Update(func(tx *bolt.Tx) error {
+		return putApps(tx.Bucket([]byte(APP_BUCKET)), apps)
+	})
+}
+
+func (s *BoltStore) Replace(apps map[string]*App) error {
+	return s.appdb.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(APP_BUCKET))
+
+		// Remove BoltDB entries for apps that no longer exist in the
+		// remote listing, preventing unbounded growth of stale data.
+		var staleKeys [][]byte
+		b.ForEach(func(k, v []byte) error {
+			if _, exists := apps[string(k)]; !exists {
+				staleKeys = append(staleKeys, k)
+			}
+			return nil
+		})
+		for _, k := range staleKeys {
+			if err := b.Delete(k); err != nil {
+				return fmt.Errorf("error deleting stale key: %s", err)
+			}
+		}
+
+		return putApps(b, apps)
+	})
+}
+
+func (s *BoltStore) Delete(appGuid string) error {
+	return s.appdb.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(APP_BUCKET))
+		return b.Delete([]byte(appGuid))
+	})
+}
+
+func putApps(b *bolt.Bucket, apps map[string]*App) error {
+	for _, app := range apps {
+		serialize, err := json.Marshal(app)
+		if err != nil {
+			return fmt.Errorf("error Marshaling data: %s", err)
+		}
+		if err := b.Put([]byte(app.Guid), serialize); err != nil {
+			return fmt.Errorf("error inserting data: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/monitoring"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+var ErrCircuitOpen = errors.New("Cloud Controller requests suspended after repeated failures")
+
+// breakerClient fails Cloud Controller requests right away for the
+// cooldown after the given number of consecutive failures, so lookups don't
+// block on an erroring Cloud Controller. After the cooldown a single request
+// probes whether it recovered. Not found errors are not failures
+type breakerClient struct {
+	client   AppClient
+	failures int
+	cooldown time.Duration
+	opened   utils.Counter
+
+	lock        sync.Mutex
+	consecutive int
+	openUntil   time.Time
+	probing     bool
+}
+
+func newBreakerClient(client AppClient, failures int, cooldown time.Duration) AppClient {
+	return &breakerClient{
+		client:   client,
+		failures: failures,
+		cooldown: cooldown,
+		opened:   monitoring.RegisterCounter("nozzle.cache.breaker.opened", utils.UintType),
+	}
+}
+
+// allow reports whether a request may be made
+func (c *breakerClient) allow() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.consecutive < c.failures {
+		return true
+	}
+	if time.Now().Before(c.openUntil) || c.probing {
+		return false
+	}
+	c.probing = true
+	return true
+}
+
+func (c *breakerClient) record(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.probing = false
+	if err == nil || IsResourceNotFound(err) {
+		c.consecutive = 0
+		return
+	}
+
+	c.consecutive++
+	if c.consecutive >= c.failures {
+		if c.consecutive == c.failures {
+			c.opened.Add(uint64(1))
+		}
+		c.openUntil = time.Now().Add(c.cooldown)
+	}
+}
+
+func call[T any](c *breakerClient, request func() (T, error)) (T, error) {
+	if !c.allow() {
+		var zero T
+		return zero, ErrCircuitOpen
+	}
+	result, err := request()
+	c.record(err)
+	return result, err
+}
+
+func (c *breakerClient) AppByGuid(appGuid string) (*resource.App, error) {
+	return call(c, func() (*resource.App, error) { return c.client.AppByGuid(appGuid) })
+}
+
+func (c *breakerClient) ListAppsPage(page, perPage int, updatedSince time.Time) (*AppPage, error) {
+	return call(c, func() (*AppPage, error) { return c.client.ListAppsPage(page, perPage, updatedSince) })
+}
+
+func (c *breakerClient) GetSpaceByGuid(spaceGUID string) (*resource.Space, error) {
+	return call(c, func() (*resource.Space, error) { return c.client.GetSpaceByGuid(spaceGUID) })
+}
+
+func (c *breakerClient) GetOrgByGuid(orgGUID string) (*resource.Organization, error) {
+	return call(c, func() (*resource.Organization, error) { return c.client.GetOrgByGuid(orgGUID) })
+}
+
+func (c *breakerClient) GetAppEnvVars(appGuid string) (map[string]*string, error) {
+	return call(c, func() (map[string]*string, error) { return c.client.GetAppEnvVars(appGuid) })
+}
+
+func (c *breakerClient) GetAppProcesses(appGuid string) ([]*resource.Process, error) {
+	return call(c, func() ([]*resource.Process, error) { return c.client.GetAppProcesses(appGuid) })
+}
+
+func (c *breakerClient) GetCurrentDroplet(appGuid string) (*resource.Droplet, error) {
+	return call(c, func() (*resource.Droplet, error) { return c.client.GetCurrentDroplet(appGuid) })
+}
+
+func (c *breakerClient) ListAuditEvents(types []string, createdSince time.Time) ([]*resource.AuditEvent, error) {
+	return call(c, func() ([]*resource.AuditEvent, error) { return c.client.ListAuditEvents(types, createdSince) })
+}
@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"time"
+
 	"github.com/cloudfoundry/go-cfclient/v3/resource"
 )
 
@@ -13,6 +15,23 @@ type App struct {
 	OrgGuid         string
 	CfAppProperties map[string]*string
 	IgnoredApp      bool
+
+	// CF metadata of the app, its space and org
+	Labels           map[string]string
+	Annotations      map[string]string
+	SpaceLabels      map[string]string
+	SpaceAnnotations map[string]string
+	OrgLabels        map[string]string
+	OrgAnnotations   map[string]string
+
+	// Deployment of the app. Process types and instances are only set with
+	// FetchProcesses, the droplet GUID with FetchDroplet
+	State         string
+	Stack         string
+	Buildpacks    []string
+	DropletGuid   string
+	ProcessTypes  []string
+	InstanceCount int
 }
 
 type Cache interface {
@@ -22,10 +41,22 @@ type Cache interface {
 	GetApp(string) (*App, error)
 }
 
+// AppPage is one page of apps, most recently updated first, with their
+// spaces and orgs included
+type AppPage struct {
+	Apps    []*resource.App
+	Spaces  []*resource.Space
+	Orgs    []*resource.Organization
+	HasNext bool
+}
+
 type AppClient interface {
 	AppByGuid(appGuid string) (*resource.App, error)
-	ListApps() ([]*resource.App, error)
+	ListAppsPage(page, perPage int, updatedSince time.Time) (*AppPage, error)
 	GetSpaceByGuid(spaceGUID string) (*resource.Space, error)
 	GetOrgByGuid(orgGUID string) (*resource.Organization, error)
 	GetAppEnvVars(appGuid string) (map[string]*string, error)
+	GetAppProcesses(appGuid string) ([]*resource.Process, error)
+	GetCurrentDroplet(appGuid string) (*resource.Droplet, error)
+	ListAuditEvents(types []string, createdSince time.Time) ([]*resource.AuditEvent, error)
 }
@@ -47,6 +47,30 @@ func easyjsonA591d1bcDecodeGithubComCloudfoundryCommunitySplunkFirehoseNozzleCac
 			parseCfAppEnv(in, out)
 		case "IgnoredApp":
 			out.IgnoredApp = bool(in.Bool())
+		case "Labels":
+			out.Labels = parseStringMap(in)
+		case "Annotations":
+			out.Annotations = parseStringMap(in)
+		case "SpaceLabels":
+			out.SpaceLabels = parseStringMap(in)
+		case "SpaceAnnotations":
+			out.SpaceAnnotations = parseStringMap(in)
+		case "OrgLabels":
+			out.OrgLabels = parseStringMap(in)
+		case "OrgAnnotations":
+			out.OrgAnnotations = parseStringMap(in)
+		case "State":
+			out.State = string(in.String())
+		case "Stack":
+			out.Stack = string(in.String())
+		case "Buildpacks":
+			out.Buildpacks = parseStringSlice(in)
+		case "DropletGuid":
+			out.DropletGuid = string(in.String())
+		case "ProcessTypes":
+			out.ProcessTypes = parseStringSlice(in)
+		case "InstanceCount":
+			out.InstanceCount = int(in.Int())
 		default:
 			in.SkipRecursive()
 		}
@@ -76,6 +100,79 @@ func parseCfAppEnv(in *jlexer.Lexer, out *App) {
 	}
 }
 
+func parseStringMap(in *jlexer.Lexer) map[string]string {
+	if in.IsNull() {
+		in.Skip()
+		return nil
+	}
+	in.Delim('{')
+	var out map[string]string
+	if !in.IsDelim('}') {
+		out = make(map[string]string)
+	}
+	for !in.IsDelim('}') {
+		key := string(in.String())
+		in.WantColon()
+		out[key] = string(in.String())
+		in.WantComma()
+	}
+	in.Delim('}')
+	return out
+}
+
+func parseStringSlice(in *jlexer.Lexer) []string {
+	if in.IsNull() {
+		in.Skip()
+		return nil
+	}
+	in.Delim('[')
+	var out []string
+	for !in.IsDelim(']') {
+		out = append(out, string(in.String()))
+		in.WantComma()
+	}
+	in.Delim(']')
+	return out
+}
+
+func writeStringSlice(out *jwriter.Writer, name string, in []string) {
+	out.RawByte(',')
+	out.RawString("\"" + name + "\":")
+	if in == nil {
+		out.RawString(`null`)
+		return
+	}
+	out.RawByte('[')
+	for i, v := range in {
+		if i > 0 {
+			out.RawByte(',')
+		}
+		out.String(string(v))
+	}
+	out.RawByte(']')
+}
+
+func writeStringMap(out *jwriter.Writer, name string, in map[string]string) {
+	out.RawByte(',')
+	out.RawString("\"" + name + "\":")
+	if in == nil {
+		out.RawString(`null`)
+		return
+	}
+	out.RawByte('{')
+	first := true
+	for k, v := range in {
+		if !first {
+			out.RawByte(',')
+		}
+		first = false
+		out.String(string(k))
+		out.RawByte(':')
+		out.String(string(v))
+	}
+	out.RawByte('}')
+}
+
 func easyjsonA591d1bcEncodeGithubComCloudfoundryCommunitySplunkFirehoseNozzleCache(out *jwriter.Writer, in App) {
 	out.RawByte('{')
 	first := true
@@ -143,6 +240,22 @@ func easyjsonA591d1bcEncodeGithubComCloudfoundryCommunitySplunkFirehoseNozzleCac
 	first = false
 	out.RawString("\"IgnoredApp\":")
 	out.Bool(bool(in.IgnoredApp))
+	writeStringMap(out, "Labels", in.Labels)
+	writeStringMap(out, "Annotations", in.Annotations)
+	writeStringMap(out, "SpaceLabels", in.SpaceLabels)
+	writeStringMap(out, "SpaceAnnotations", in.SpaceAnnotations)
+	writeStringMap(out, "OrgLabels", in.OrgLabels)
+	writeStringMap(out, "OrgAnnotations", in.OrgAnnotations)
+	out.RawString(",\"State\":")
+	out.String(string(in.State))
+	out.RawString(",\"Stack\":")
+	out.String(string(in.Stack))
+	writeStringSlice(out, "Buildpacks", in.Buildpacks)
+	out.RawString(",\"DropletGuid\":")
+	out.String(string(in.DropletGuid))
+	writeStringSlice(out, "ProcessTypes", in.ProcessTypes)
+	out.RawString(",\"InstanceCount\":")
+	out.Int(int(in.InstanceCount))
 	out.RawByte('}')
 }
 
@@ -1,8 +1,10 @@
 package cache_test
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"code.cloudfoundry.org/lager/v3"
@@ -77,6 +79,51 @@ var _ = Describe("Cache", func() {
 			Expect(app).NotTo(Equal(nil))
 			Expect(app.Guid).To(Equal(guid))
 		})
+
+		It("Expect app, space and org metadata", func() {
+			app, err := cache.GetApp("cf_app_id_1")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Expect(app.Labels).To(Equal(map[string]string{"team": "team_1"}))
+			Expect(app.Annotations).To(Equal(map[string]string{"owner": "owner@example.com"}))
+			Expect(app.SpaceLabels).To(Equal(map[string]string{"tier": "tier_1"}))
+			Expect(app.OrgLabels).To(Equal(map[string]string{"cost-center": "cc_1"}))
+			Expect(app.OrgAnnotations).To(Equal(map[string]string{"owner": "owner@example.com"}))
+		})
+
+		It("Expect deployment info from the app", func() {
+			app, err := cache.GetApp("cf_app_id_1")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Expect(app.State).To(Equal("STARTED"))
+			Expect(app.Stack).To(Equal("cflinuxfs3"))
+			Expect(app.Buildpacks).To(Equal([]string{"go_buildpack"}))
+			Expect(app.DropletGuid).To(BeEmpty())
+			Expect(app.ProcessTypes).To(BeEmpty())
+			Expect(app.InstanceCount).To(Equal(0))
+		})
+
+		It("Expect deployment info from droplet and processes", func() {
+			dup := *config
+			dup.Path = fmt.Sprintf("/tmp/%d", time.Now().UnixNano())
+			dup.FetchDroplet = true
+			dup.FetchProcesses = true
+			defer os.Remove(dup.Path)
+
+			bcache, err := NewBoltdb(client, &dup)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(bcache.Open()).Should(Succeed())
+			defer bcache.Close()
+
+			app, err := bcache.GetApp("cf_app_id_1")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Expect(app.Stack).To(Equal("cflinuxfs4"))
+			Expect(app.Buildpacks).To(Equal([]string{"go_buildpack@1.10.2"}))
+			Expect(app.DropletGuid).To(Equal("droplet_cf_app_id_1"))
+			Expect(app.ProcessTypes).To(Equal([]string{"web", "worker"}))
+			Expect(app.InstanceCount).To(Equal(3))
+		})
 	})
 
 	Context("Get app bad case", func() {
@@ -106,6 +153,18 @@ var _ = Describe("Cache", func() {
 	})
 
 	Context("When orphan app is requested", func() {
+		BeforeEach(func() {
+			// Only a listing of all apps removes deleted ones from the database
+			cache.Close()
+
+			dup := *config
+			dup.AppLimits = 0
+			cache, gerr = NewBoltdb(client, &dup)
+			Ω(gerr).ShouldNot(HaveOccurred())
+
+			gerr = cache.Open()
+			Ω(gerr).ShouldNot(HaveOccurred())
+		})
 
 		It("Should not find deleted app after cache invalidation", func() {
 			app_guid := "orphan_app_id"
@@ -147,13 +206,15 @@ var _ = Describe("Cache", func() {
 			Expect(app).NotTo(Equal(nilApp))
 			Expect(app.Guid).To(Equal(id))
 
+			// Spaces and orgs are included in the app pages. Only the new app,
+			// beyond AppLimits, is fetched on its own
 			Expect(app.SpaceGuid).NotTo(BeEmpty())
 			Expect(app.SpaceName).NotTo(BeEmpty())
-			Expect(client.GetSpaceByGUIDCallCount()).To(Equal(11))
+			Expect(client.GetSpaceByGUIDCallCount()).To(Equal(1))
 
 			Expect(app.OrgGuid).NotTo(BeEmpty())
 			Expect(app.OrgName).NotTo(BeEmpty())
-			Expect(client.GetOrgByGUIDCallCount()).To(Equal(11))
+			Expect(client.GetOrgByGUIDCallCount()).To(Equal(1))
 
 			apps, err := cache.GetAllApps()
 			Ω(err).ShouldNot(HaveOccurred())
@@ -212,9 +273,9 @@ var _ = Describe("Cache", func() {
 			Expect(app.OrgGuid).NotTo(BeEmpty())
 			Expect(app.OrgName).NotTo(BeEmpty())
 
-			// this will be 1 because `invalidateCache` will have been called between ResetCallCounts and now but the org and space cache has not reached its TTL
-			Expect(client.GetSpaceByGUIDCallCount()).To(Equal(1))
-			Expect(client.GetOrgByGUIDCallCount()).To(Equal(1))
+			// this will be 0 because `invalidateCache` will have been called between ResetCallCounts and now, and it includes the spaces and orgs of all apps
+			Expect(client.GetSpaceByGUIDCallCount()).To(Equal(0))
+			Expect(client.GetOrgByGUIDCallCount()).To(Equal(0))
 
 			apps, err := cache.GetAllApps()
 			Ω(err).ShouldNot(HaveOccurred())
@@ -224,6 +285,508 @@ var _ = Describe("Cache", func() {
 		})
 	})
 
+	Context("Bulk loading", func() {
+		var dup BoltdbConfig
+
+		BeforeEach(func() {
+			dup = *config
+			dup.Path = fmt.Sprintf("/tmp/%d", time.Now().UnixNano())
+			dup.AppCacheTTL = 0
+			dup.MissingAppCacheTTL = 0
+			client.ResetCallCounts()
+		})
+
+		AfterEach(func() {
+			os.Remove(dup.Path)
+		})
+
+		It("Loads all pages without space and org requests", func() {
+			// One more app than fits in a page
+			total := 5001
+			client = testing.NewAppClientMock(total)
+			dup.AppLimits = 0
+
+			bcache, err := NewBoltdb(client, &dup)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(bcache.Open()).Should(Succeed())
+			defer bcache.Close()
+
+			apps, err := bcache.GetAllApps()
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(apps).To(HaveLen(total))
+			Expect(client.ListAppsCallCount()).To(Equal(2))
+			Expect(client.GetSpaceByGUIDCallCount()).To(Equal(0))
+			Expect(client.GetOrgByGUIDCallCount()).To(Equal(0))
+			Expect(apps["cf_app_id_7"].SpaceName).To(Equal("cf_space_name_7"))
+			Expect(apps["cf_app_id_7"].OrgName).To(Equal("cf_org_name_7"))
+			Expect(apps["cf_app_id_7"].OrgLabels).To(Equal(map[string]string{"cost-center": "cc_7"}))
+		})
+
+		It("Honours AppLimits", func() {
+			dup.AppLimits = 3
+
+			bcache, err := NewBoltdb(client, &dup)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(bcache.Open()).Should(Succeed())
+			defer bcache.Close()
+
+			apps, err := bcache.GetAllApps()
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(apps).To(HaveLen(3))
+			Expect(client.ListAppsCallCount()).To(Equal(1))
+		})
+
+		It("Keeps apps beyond AppLimits in the database", func() {
+			dup.AppLimits = 3
+			store := NewBoltStore(dup.Path)
+			Ω(store.Open()).Should(Succeed())
+			Ω(store.Put(map[string]*App{"cf_app_id_9": {Guid: "cf_app_id_9", Name: "cf_app_name_9"}})).Should(Succeed())
+			Ω(store.Close()).Should(Succeed())
+
+			dup.Store = NewBoltStore(dup.Path)
+			bcache, err := NewBoltdb(client, &dup)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(bcache.Open()).Should(Succeed())
+			Ω(bcache.ManuallyInvalidateCaches()).Should(Succeed())
+			Ω(bcache.Close()).Should(Succeed())
+
+			Ω(store.Open()).Should(Succeed())
+			defer store.Close()
+			apps, err := store.GetAll()
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(apps).To(HaveLen(4))
+			Expect(apps).To(HaveKey("cf_app_id_9"))
+		})
+
+		It("Respects the request rate", func() {
+			dup.RequestRate = 20
+			dup.UseEnvVarForSplunkIndex = true
+
+			start := time.Now()
+			bcache, err := NewBoltdb(client, &dup)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(bcache.Open()).Should(Succeed())
+			defer bcache.Close()
+
+			// One page and 10 env var requests, 20 of them allowed at once
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+
+			for i := 0; i < 25; i++ {
+				bcache.GetApp(fmt.Sprintf("cf_app_id_missing_%d", i))
+			}
+			Expect(time.Since(start)).To(BeNumerically(">=", 700*time.Millisecond))
+		})
+	})
+
+	Context("Incremental refresh", func() {
+		var dup BoltdbConfig
+
+		BeforeEach(func() {
+			dup = *config
+			dup.Path = fmt.Sprintf("/tmp/%d", time.Now().UnixNano())
+			dup.AppCacheTTL = 300 * time.Millisecond
+			dup.MissingAppCacheTTL = 0
+			dup.AppLimits = 0
+			dup.IncrementalRefresh = true
+			client = testing.NewAppClientMock(n)
+		})
+
+		AfterEach(func() {
+			os.Remove(dup.Path)
+		})
+
+		It("Merges updated apps and keeps deleted ones until reconciled", func() {
+			dup.ReconcileInterval = 0
+
+			bcache, err := NewBoltdb(client, &dup)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(bcache.Open()).Should(Succeed())
+			defer bcache.Close()
+
+			client.RenameApp("cf_app_id_3", "renamed")
+			client.DeleteApp("cf_app_id_4")
+			client.CreateApp("new_app_id", "cf_space_id_1")
+
+			Eventually(func() string {
+				apps, _ := bcache.GetAllApps()
+				return apps["cf_app_id_3"].Name
+			}, 2*time.Second).Should(Equal("renamed"))
+
+			apps, err := bcache.GetAllApps()
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(apps).To(HaveLen(n + 1))
+			Expect(apps).To(HaveKey("cf_app_id_4"))
+			Expect(apps["new_app_id"].SpaceName).To(Equal("cf_space_name_1"))
+			Expect(client.GetSpaceByGUIDCallCount()).To(Equal(0))
+		})
+
+		It("Drops deleted apps on reconciliation", func() {
+			dup.ReconcileInterval = time.Second
+
+			bcache, err := NewBoltdb(client, &dup)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(bcache.Open()).Should(Succeed())
+			defer bcache.Close()
+
+			client.DeleteApp("cf_app_id_4")
+
+			Eventually(func() map[string]*App {
+				apps, _ := bcache.GetAllApps()
+				return apps
+			}, 3*time.Second).ShouldNot(HaveKey("cf_app_id_4"))
+		})
+	})
+
+	Context("Audit events", func() {
+		var (
+			dup    BoltdbConfig
+			bcache *Boltdb
+			store  *BoltStore
+		)
+
+		BeforeEach(func() {
+			dup = *config
+			dup.Path = fmt.Sprintf("/tmp/%d", time.Now().UnixNano())
+			dup.AppCacheTTL = 0
+			dup.MissingAppCacheTTL = 0
+			dup.OrgSpaceCacheTTL = 48 * time.Hour
+			dup.AuditEventInterval = 200 * time.Millisecond
+			store = NewBoltStore(dup.Path)
+			dup.Store = store
+			client = testing.NewAppClientMock(n)
+
+			var err error
+			bcache, err = NewBoltdb(client, &dup)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(bcache.Open()).Should(Succeed())
+		})
+
+		AfterEach(func() {
+			bcache.Close()
+			os.Remove(dup.Path)
+		})
+
+		It("Invalidates renamed apps, spaces and orgs", func() {
+			client.Rename("cf_org_id_7", "renamed_org")
+			client.Rename("cf_space_id_8", "renamed_space")
+			client.RenameApp("cf_app_id_3", "renamed_app")
+
+			Eventually(func() string {
+				app, _ := bcache.GetApp("cf_app_id_7")
+				return app.OrgName
+			}, 2*time.Second).Should(Equal("renamed_org"))
+			Eventually(func() string {
+				app, _ := bcache.GetApp("cf_app_id_8")
+				return app.SpaceName
+			}, 2*time.Second).Should(Equal("renamed_space"))
+			Eventually(func() string {
+				app, _ := bcache.GetApp("cf_app_id_3")
+				return app.Name
+			}, 2*time.Second).Should(Equal("renamed_app"))
+
+			// Only the changed space and org are fetched again
+			Expect(client.GetOrgByGUIDCallCount()).To(Equal(1))
+			Expect(client.GetSpaceByGUIDCallCount()).To(Equal(1))
+			Expect(client.AppByGUIDCallCount()).To(Equal(1))
+		})
+
+		It("Removes deleted apps", func() {
+			client.DeleteApp("cf_app_id_5")
+
+			// The app leaves memory only once it is gone from the database
+			Eventually(func() map[string]*App {
+				apps, _ := bcache.GetAllApps()
+				return apps
+			}, 2*time.Second).ShouldNot(HaveKey("cf_app_id_5"))
+			Expect(store.Get("cf_app_id_5")).To(BeNil())
+
+			app, err := bcache.GetApp("cf_app_id_5")
+			Ω(err).Should(HaveOccurred())
+			Expect(app).To(Equal(nilApp))
+		})
+	})
+
+	Context("Bounded in-memory cache", func() {
+		var (
+			dup    BoltdbConfig
+			bcache *Boltdb
+		)
+
+		BeforeEach(func() {
+			dup = *config
+			dup.Path = fmt.Sprintf("/tmp/%d", time.Now().UnixNano())
+			dup.AppCacheTTL = 0
+			dup.MissingAppCacheTTL = 0
+			dup.AppLimits = 0
+			dup.MaxApps = 3
+			dup.Store = NewNopStore()
+			client = testing.NewAppClientMock(n)
+
+			var err error
+			bcache, err = NewBoltdb(client, &dup)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(bcache.Open()).Should(Succeed())
+		})
+
+		AfterEach(func() {
+			bcache.Close()
+		})
+
+		It("Keeps the most recently used apps in memory only", func() {
+			apps, err := bcache.GetAllApps()
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(apps).To(HaveLen(3))
+
+			for i := 0; i < 6; i++ {
+				_, err := bcache.GetApp(fmt.Sprintf("cf_app_id_%d", i))
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+			bcache.GetApp("cf_app_id_3")
+			bcache.GetApp("cf_app_id_6")
+
+			apps, err = bcache.GetAllApps()
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(apps).To(HaveLen(3))
+			Expect(apps).To(HaveKey("cf_app_id_3"))
+			Expect(apps).To(HaveKey("cf_app_id_5"))
+			Expect(apps).To(HaveKey("cf_app_id_6"))
+
+			_, err = os.Stat(dup.Path)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("Rejects unknown stores", func() {
+			_, err := NewStore("redis", dup.Path)
+			Ω(err).Should(HaveOccurred())
+
+			store, err := NewStore(StoreNone, dup.Path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(store).To(BeAssignableToTypeOf(&NopStore{}))
+		})
+	})
+
+	Context("Missing app backoff and circuit breaker", func() {
+		var (
+			dup    BoltdbConfig
+			bcache *Boltdb
+		)
+
+		open := func() {
+			var err error
+			bcache, err = NewBoltdb(client, &dup)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(bcache.Open()).Should(Succeed())
+			client.ResetCallCounts()
+		}
+
+		BeforeEach(func() {
+			dup = *config
+			dup.AppCacheTTL = 0
+			dup.MissingAppCacheTTL = 0
+			dup.Store = NewNopStore()
+			client = testing.NewAppClientMock(n)
+		})
+
+		AfterEach(func() {
+			bcache.Close()
+		})
+
+		It("Looks up missing apps again after a growing backoff", func() {
+			dup.MissingAppBackoff = 200 * time.Millisecond
+			dup.MissingAppMaxBackoff = 400 * time.Millisecond
+			open()
+
+			_, err := bcache.GetApp("later_app_id")
+			Ω(err).Should(HaveOccurred())
+			_, err = bcache.GetApp("later_app_id")
+			Expect(err).To(Equal(ErrMissingAlreadyCached))
+			Expect(client.AppByGUIDCallCount()).To(Equal(1))
+
+			time.Sleep(250 * time.Millisecond)
+			_, err = bcache.GetApp("later_app_id")
+			Ω(err).Should(HaveOccurred())
+			Expect(client.AppByGUIDCallCount()).To(Equal(2))
+
+			client.CreateApp("later_app_id", "cf_space_id_1")
+			time.Sleep(450 * time.Millisecond)
+			app, err := bcache.GetApp("later_app_id")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(app.Guid).To(Equal("later_app_id"))
+			Expect(bcache.MissingApps()).To(BeEmpty())
+		})
+
+		It("Expires missing apps one by one", func() {
+			dup.MissingAppCacheTTL = 300 * time.Millisecond
+			open()
+
+			bcache.GetApp("early_app_id")
+			time.Sleep(400 * time.Millisecond)
+			bcache.GetApp("late_app_id")
+			Expect(bcache.MissingApp("early_app_id")).To(Succeed())
+			Expect(bcache.MissingApp("late_app_id")).To(Equal(ErrMissingAlreadyCached))
+
+			// the expiry of each app is checked on the next tick
+			Eventually(bcache.MissingApps, 400*time.Millisecond, 10*time.Millisecond).Should(Equal([]string{"late_app_id"}))
+		})
+
+		It("Keeps growing the backoff across missing app cache expiries", func() {
+			dup.MissingAppBackoff = 400 * time.Millisecond
+			dup.MissingAppCacheTTL = 400 * time.Millisecond
+			open()
+
+			bcache.GetApp("later_app_id")
+			time.Sleep(500 * time.Millisecond)
+			_, err := bcache.GetApp("later_app_id")
+			Ω(err).Should(HaveOccurred())
+			Expect(err).NotTo(Equal(ErrMissingAlreadyCached))
+			Expect(client.AppByGUIDCallCount()).To(Equal(2))
+
+			// the second backoff lasts 400ms at least, past the next expiry
+			time.Sleep(340 * time.Millisecond)
+			_, err = bcache.GetApp("later_app_id")
+			Expect(err).To(Equal(ErrMissingAlreadyCached))
+			Expect(client.AppByGUIDCallCount()).To(Equal(2))
+		})
+
+		It("Suspends lookups while the Cloud Controller is failing", func() {
+			dup.BreakerFailures = 2
+			dup.BreakerCooldown = 300 * time.Millisecond
+			open()
+
+			client.Fail(errors.New("500 Internal Server Error"))
+			bcache.GetApp("cf_app_id_missing_1")
+			bcache.GetApp("cf_app_id_missing_2")
+			_, err := bcache.GetApp("cf_app_id_missing_3")
+			Expect(err).To(Equal(ErrCircuitOpen))
+			Expect(client.AppByGUIDCallCount()).To(Equal(2))
+			Expect(bcache.MissingApps()).NotTo(ContainElement("cf_app_id_missing_3"))
+
+			app, err := bcache.GetApp("cf_app_id_1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(app.Name).To(Equal("cf_app_name_1"))
+
+			client.Fail(nil)
+			client.CreateApp("cf_app_id_missing_3", "cf_space_id_1")
+			time.Sleep(350 * time.Millisecond)
+			app, err = bcache.GetApp("cf_app_id_missing_3")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(app.Guid).To(Equal("cf_app_id_missing_3"))
+			Expect(client.AppByGUIDCallCount()).To(Equal(3))
+		})
+	})
+
+	Context("Asynchronous lookups", func() {
+		var (
+			dup    BoltdbConfig
+			bcache *Boltdb
+			async  *Async
+		)
+
+		BeforeEach(func() {
+			dup = *config
+			dup.AppCacheTTL = 0
+			dup.MissingAppCacheTTL = 0
+			dup.Store = NewNopStore()
+			client = testing.NewAppClientMock(n)
+
+			var err error
+			bcache, err = NewBoltdb(client, &dup)
+			Ω(err).ShouldNot(HaveOccurred())
+			async = NewAsync(bcache, 50*time.Millisecond, 2)
+			Ω(async.Open()).Should(Succeed())
+			client.ResetCallCounts()
+		})
+
+		AfterEach(func() {
+			async.Close()
+		})
+
+		It("Fetches each missing app once in the background", func() {
+			client.CreateApp("slow_app_id", "cf_space_id_1")
+			client.Delay(300 * time.Millisecond)
+
+			start := time.Now()
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer GinkgoRecover()
+					_, err := async.GetApp("slow_app_id")
+					Expect(err).To(Equal(ErrAppPending))
+				}()
+			}
+			wg.Wait()
+			Expect(time.Since(start)).To(BeNumerically("<", 200*time.Millisecond))
+
+			Eventually(func() error {
+				_, err := async.GetApp("slow_app_id")
+				return err
+			}, 2*time.Second).ShouldNot(HaveOccurred())
+			Expect(client.AppByGUIDCallCount()).To(Equal(1))
+		})
+
+		It("Returns lookups completing within the wait", func() {
+			client.CreateApp("quick_app_id", "cf_space_id_1")
+			client.Delay(10 * time.Millisecond)
+
+			app, err := async.GetApp("quick_app_id")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(app.Guid).To(Equal("quick_app_id"))
+
+			app, err = async.GetApp("cf_app_id_1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(app.Name).To(Equal("cf_app_name_1"))
+		})
+
+		It("Waits for lookups in flight on close", func() {
+			client.CreateApp("slow_app_id", "cf_space_id_1")
+			client.Delay(200 * time.Millisecond)
+
+			_, err := async.GetApp("slow_app_id")
+			Expect(err).To(Equal(ErrAppPending))
+			Ω(async.Close()).Should(Succeed())
+			Expect(client.AppByGUIDCallCount()).To(Equal(1))
+
+			_, err = async.GetApp("other_app_id")
+			Expect(err).To(Equal(ErrAppPending))
+			Expect(client.AppByGUIDCallCount()).To(Equal(1))
+		})
+
+		It("Does not look up apps recorded as missing", func() {
+			_, err := async.GetApp("no_such_app_id")
+			Ω(err).Should(HaveOccurred())
+			Expect(err).NotTo(Equal(ErrAppPending))
+
+			_, err = async.GetApp("no_such_app_id")
+			Expect(err).To(Equal(ErrMissingAlreadyCached))
+			Expect(client.AppByGUIDCallCount()).To(Equal(1))
+		})
+
+		It("Limits the lookups in flight", func() {
+			for _, id := range []string{"slow_app_id_1", "slow_app_id_2", "slow_app_id_3"} {
+				client.CreateApp(id, "cf_space_id_1")
+			}
+			client.Delay(300 * time.Millisecond)
+
+			_, err := async.GetApp("slow_app_id_1")
+			Expect(err).To(Equal(ErrAppPending))
+			_, err = async.GetApp("slow_app_id_2")
+			Expect(err).To(Equal(ErrAppPending))
+
+			start := time.Now()
+			_, err = async.GetApp("slow_app_id_3")
+			Expect(err).To(Equal(ErrAppPending))
+			Expect(time.Since(start)).To(BeNumerically("<", 50*time.Millisecond))
+
+			Eventually(func() error {
+				_, err := async.GetApp("slow_app_id_3")
+				return err
+			}, 2*time.Second).ShouldNot(HaveOccurred())
+			Expect(client.AppByGUIDCallCount()).To(Equal(3))
+		})
+	})
+
 	Context("NewBoltdb error", func() {
 		It("Expect error", func() {
 			dup := *config
@@ -262,6 +825,8 @@ var _ = Describe("Cache", func() {
 
 			Expect(apps).NotTo(Equal(nil))
 			Expect(len(apps)).To(Equal(n))
+			Expect(apps["cf_app_id_1"].Labels).To(Equal(map[string]string{"team": "team_1"}))
+			Expect(apps["cf_app_id_1"].SpaceLabels).To(Equal(map[string]string{"tier": "tier_1"}))
 		})
 	})
 
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// appLRU is the in-memory app cache. With a positive size it only keeps
+// that many most recently used apps
+type appLRU struct {
+	lock  sync.Mutex
+	size  int
+	apps  map[string]*list.Element
+	order *list.List
+}
+
+func newAppLRU(size int) *appLRU {
+	return &appLRU{
+		size:  size,
+		apps:  make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// get returns the app and marks it as the most recently used
+func (l *appLRU) get(appGuid string) (*App, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	elem, ok := l.apps[appGuid]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(elem)
+	return elem.Value.(*App), true
+}
+
+// add adds or replaces the app, evicting the least recently used apps
+// beyond the size
+func (l *appLRU) add(app *App) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if elem, ok := l.apps[app.Guid]; ok {
+		elem.Value = app
+		l.order.MoveToFront(elem)
+		return
+	}
+	l.apps[app.Guid] = l.order.PushFront(app)
+
+	for l.size > 0 && l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.apps, oldest.Value.(*App).Guid)
+	}
+}
+
+func (l *appLRU) remove(appGuid string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if elem, ok := l.apps[appGuid]; ok {
+		l.order.Remove(elem)
+		delete(l.apps, appGuid)
+	}
+}
+
+// replace replaces all apps
+func (l *appLRU) replace(apps map[string]*App) {
+	l.lock.Lock()
+	l.apps = make(map[string]*list.Element, len(apps))
+	l.order.Init()
+	l.lock.Unlock()
+
+	for _, app := range apps {
+		l.add(app)
+	}
+}
+
+// copies returns a copy of every app
+func (l *appLRU) copies() map[string]*App {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	apps := make(map[string]*App, len(l.apps))
+	for guid, elem := range l.apps {
+		dup := *elem.Value.(*App)
+		apps[guid] = &dup
+	}
+	return apps
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	"github.com/cloudfoundry/go-cfclient/v3/resource"
+)
+
+// rateLimitedClient waits for a token before each Cloud Controller request
+type rateLimitedClient struct {
+	client AppClient
+	bucket *utils.TokenBucket
+}
+
+func newRateLimitedClient(client AppClient, rate float64) AppClient {
+	return &rateLimitedClient{
+		client: client,
+		bucket: utils.NewTokenBucket(rate, 0),
+	}
+}
+
+func (c *rateLimitedClient) AppByGuid(appGuid string) (*resource.App, error) {
+	c.bucket.Wait()
+	return c.client.AppByGuid(appGuid)
+}
+
+func (c *rateLimitedClient) ListAppsPage(page, perPage int, updatedSince time.Time) (*AppPage, error) {
+	c.bucket.Wait()
+	return c.client.ListAppsPage(page, perPage, updatedSince)
+}
+
+func (c *rateLimitedClient) GetSpaceByGuid(spaceGUID string) (*resource.Space, error) {
+	c.bucket.Wait()
+	return c.client.GetSpaceByGuid(spaceGUID)
+}
+
+func (c *rateLimitedClient) GetOrgByGuid(orgGUID string) (*resource.Organization, error) {
+	c.bucket.Wait()
+	return c.client.GetOrgByGuid(orgGUID)
+}
+
+func (c *rateLimitedClient) GetAppEnvVars(appGuid string) (map[string]*string, error) {
+	c.bucket.Wait()
+	return c.client.GetAppEnvVars(appGuid)
+}
+
+func (c *rateLimitedClient) GetAppProcesses(appGuid string) ([]*resource.Process, error) {
+	c.bucket.Wait()
+	return c.client.GetAppProcesses(appGuid)
+}
+
+func (c *rateLimitedClient) GetCurrentDroplet(appGuid string) (*resource.Droplet, error) {
+	c.bucket.Wait()
+	return c.client.GetCurrentDroplet(appGuid)
+}
+
+func (c *rateLimitedClient) ListAuditEvents(types []string, createdSince time.Time) ([]*resource.AuditEvent, error) {
+	c.bucket.Wait()
+	return c.client.ListAuditEvents(types, createdSince)
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"fmt"
+)
+
+const (
+	StoreBoltdb = "boltdb"
+	StoreNone   = "none"
+)
+
+// Store persists the app cache, so it can be loaded without querying the
+// Cloud Controller on start. Get returns nil for unknown apps
+type Store interface {
+	Open() error
+	Close() error
+	GetAll() (map[string]*App, error)
+	Get(appGuid string) (*App, error)
+	Put(apps map[string]*App) error
+	// Replace stores the apps and removes all others
+	Replace(apps map[string]*App) error
+	Delete(appGuid string) error
+}
+
+// NewStore creates the store of the kind, StoreBoltdb keeping its
+// database at path
+func NewStore(kind, path string) (Store, error) {
+	switch kind {
+	case StoreBoltdb, "":
+		return NewBoltStore(path), nil
+	case StoreNone:
+		return NewNopStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown app cache store %q, valid options are %s and %s", kind, StoreBoltdb, StoreNone)
+	}
+}
+
+// NopStore persists nothing, so the app cache is in memory only and apps
+// are fetched again from the Cloud Controller on start
+type NopStore struct{}
+
+func NewNopStore() *NopStore {
+	return &NopStore{}
+}
+
+func (s *NopStore) Open() error {
+	return nil
+}
+
+func (s *NopStore) Close() error {
+	return nil
+}
+
+func (s *NopStore) GetAll() (map[string]*App, error) {
+	return map[string]*App{}, nil
+}
+
+func (s *NopStore) Get(appGuid string) (*App, error) {
+	return nil, nil
+}
+
+func (s *NopStore) Put(apps map[string]*App) error {
+	return nil
+}
+
+func (s *NopStore) Replace(apps map[string]*App) error {
+	return nil
+}
+
+func (s *NopStore) Delete(appGuid string) error {
+	return nil
+}
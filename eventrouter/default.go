@@ -1,44 +1,180 @@
 package eventrouter
 
 import (
+	"sync"
+
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
 	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/monitoring"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
 	"github.com/cloudfoundry/sonde-go/events"
 )
 
 type Config = fevents.Config
 
+// appDataFields are only available after an app metadata lookup
+var appDataFields = []string{"cf_app_name", "cf_space_id", "cf_space_name", "cf_org_id", "cf_org_name", "cf_ignored_app", "info_splunk_index",
+	"cf_app_labels", "cf_app_annotations", "cf_space_labels", "cf_space_annotations", "cf_org_labels", "cf_org_annotations",
+	"cf_app_state", "cf_stack", "cf_buildpacks", "cf_droplet_guid", "cf_process_types", "cf_process_type", "cf_process_instance_id", "cf_app_instances"}
+
+// filterConfig annotates events with everything a filter may reference,
+// independent of what is configured to be sent to Splunk
+var filterConfig = &fevents.Config{
+	AddAppName:   true,
+	AddOrgName:   true,
+	AddOrgGuid:   true,
+	AddSpaceName: true,
+	AddSpaceGuid: true,
+	AddTags:      true,
+
+	AddAppLabels:        true,
+	AddAppAnnotations:   true,
+	AddSpaceLabels:      true,
+	AddSpaceAnnotations: true,
+	AddOrgLabels:        true,
+	AddOrgAnnotations:   true,
+	AddAppState:         true,
+	AddStack:            true,
+	AddBuildpacks:       true,
+	AddDropletGuid:      true,
+	AddProcessTypes:     true,
+	AddInstanceCount:    true,
+}
+
 type router struct {
 	appCache       cache.Cache
 	sink           eventsink.Sink
-	selectedEvents map[string]bool
+	filteredEvents utils.Counter
+
+	// rules are replaced as a whole by Reload
+	lock  sync.RWMutex
+	rules *routerRules
+}
+
+type routerRules struct {
 	config         *Config
+	selectedEvents map[string]bool
+	include        *Filter
+	exclude        *Filter
+	filterAppData  bool
 }
 
 func New(appCache cache.Cache, sink eventsink.Sink, config *Config) (Router, error) {
-	selectedEvents, err := fevents.ParseSelectedEvents(config.SelectedEvents)
-
+	rules, err := newRouterRules(config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &router{
+	r := &router{
 		appCache:       appCache,
 		sink:           sink,
-		selectedEvents: selectedEvents,
-		config:         config,
-	}, nil
+		rules:          rules,
+		filteredEvents: monitoring.RegisterCounter("firehose.events.filtered.count", utils.UintType),
+	}
+	return r, nil
+}
+
+func newRouterRules(config *Config) (*routerRules, error) {
+	selectedEvents, err := fevents.ParseSelectedEvents(config.SelectedEvents)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &routerRules{config: config, selectedEvents: selectedEvents}
+	if config.IncludeFilter != "" {
+		if rules.include, err = ParseFilter(config.IncludeFilter); err != nil {
+			return nil, err
+		}
+	}
+	if config.ExcludeFilter != "" {
+		if rules.exclude, err = ParseFilter(config.ExcludeFilter); err != nil {
+			return nil, err
+		}
+	}
+
+	rules.filterAppData = (rules.include != nil && rules.include.ReferencesAppData()) ||
+		(rules.exclude != nil && rules.exclude.ReferencesAppData())
+	return rules, nil
+}
+
+// Reload replaces the selected events and filters. The current ones are
+// kept when the new config is invalid
+func (r *router) Reload(config *Config) error {
+	rules, err := newRouterRules(config)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	r.rules = rules
+	r.lock.Unlock()
+	return nil
 }
 
 func (r *router) Route(msg *events.Envelope) error {
+	r.lock.RLock()
+	rules := r.rules
+	r.lock.RUnlock()
+
 	eventType := msg.GetEventType()
 
-	if _, ok := r.selectedEvents[eventType.String()]; !ok {
+	if _, ok := rules.selectedEvents[eventType.String()]; !ok {
 		// Ignore this event since we are not interested
 		return nil
 	}
-	_ = r.sink.Write(msg)
+
+	if rules.include == nil && rules.exclude == nil {
+		_ = r.sink.Write(msg)
+		return nil
+	}
+
+	// The sink takes the event parsed for the filters, so it is parsed once
+	event := fevents.FromEnvelope(msg)
+	if event == nil {
+		_ = r.sink.Write(msg)
+		return nil
+	}
+	event.AnnotateWithEnvelopeData(msg, rules.config)
+	event.AnnotateWithCFMetaData()
+
+	if !r.filter(rules, msg, event) {
+		r.filteredEvents.Add(1)
+		return nil
+	}
+	if sink, ok := r.sink.(eventsink.EventWriter); ok {
+		_ = sink.WriteEvent(msg, event)
+	} else {
+		_ = r.sink.Write(msg)
+	}
 
 	return nil
 }
+
+// filter reports whether the event passes the include and exclude filters.
+// They see every field they may reference, without changing the event. App
+// data is only taken from memory, so a remote lookup never blocks routing.
+// Events of apps not in memory pass, and the sink looking up their app
+// brings it into memory for the next events
+func (r *router) filter(rules *routerRules, msg *events.Envelope, event *fevents.Event) bool {
+	fields := make(map[string]interface{}, len(event.Fields)+2)
+	for k, v := range event.Fields {
+		fields[k] = v
+	}
+	fields["tags"] = msg.GetTags()
+	if rules.filterAppData {
+		filtered := *event
+		filtered.Fields = fields
+		if !filtered.AnnotateWithCachedAppData(r.appCache, filterConfig) {
+			return true
+		}
+	}
+	if len(event.Msg) > 0 {
+		fields["msg"] = event.Msg
+	}
+
+	if rules.include != nil && !rules.include.Match(fields) {
+		return false
+	}
+	return rules.exclude == nil || !rules.exclude.Match(fields)
+}
@@ -1,13 +1,42 @@
 package eventrouter_test
 
 import (
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
 	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
 	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
+// uncachedAppsMock has no app in memory and counts remote lookups
+type uncachedAppsMock struct {
+	testing.MemoryCacheMock
+	lookups int
+}
+
+func (c *uncachedAppsMock) GetApp(appGuid string) (*cache.App, error) {
+	c.lookups++
+	return c.MemoryCacheMock.GetApp(appGuid)
+}
+
+func (c *uncachedAppsMock) GetCachedApp(appGuid string) (*cache.App, bool) {
+	return nil, false
+}
+
+// parsedSinkMock records the events parsed by the router
+type parsedSinkMock struct {
+	testing.MemorySinkMock
+	parsed []*fevents.Event
+}
+
+func (s *parsedSinkMock) WriteEvent(msg *events.Envelope, event *fevents.Event) error {
+	s.parsed = append(s.parsed, event)
+	return s.Write(msg)
+}
+
 var _ = Describe("eventrouter", func() {
 
 	var (
@@ -99,4 +128,108 @@ var _ = Describe("eventrouter", func() {
 		_, err = New(noCache, memSink, config)
 		Ω(err).Should(HaveOccurred())
 	})
+
+	Context("with filters", func() {
+		var statusCode int32
+
+		BeforeEach(func() {
+			statusCode = 200
+			eventType = events.Envelope_HttpStartStop
+			origin = "gorouter"
+			msg.HttpStartStop = &events.HttpStartStop{
+				StatusCode: &statusCode,
+				Uri:        proto.String("/healthcheck"),
+			}
+		})
+
+		It("routes only events matching the include filter", func() {
+			r, err = New(noCache, memSink, &Config{
+				SelectedEvents: "HttpStartStop,LogMessage",
+				IncludeFilter:  `origin == "gorouter" && status_code >= 400`,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(r.Route(msg)).Should(Succeed())
+			Expect(memSink.Events).To(BeEmpty())
+
+			statusCode = 503
+			Ω(r.Route(msg)).Should(Succeed())
+			Expect(memSink.Events).To(HaveLen(1))
+		})
+
+		It("drops events matching the exclude filter", func() {
+			r, err = New(noCache, memSink, &Config{
+				SelectedEvents: "HttpStartStop,LogMessage",
+				ExcludeFilter:  `uri =~ /health/ || msg =~ /^debug/`,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(r.Route(msg)).Should(Succeed())
+			Expect(memSink.Events).To(BeEmpty())
+
+			eventType = events.Envelope_LogMessage
+			Ω(r.Route(msg)).Should(Succeed())
+			Expect(memSink.Events).To(HaveLen(1))
+		})
+
+		It("looks up app metadata referenced by a filter", func() {
+			eventType = events.Envelope_LogMessage
+			r, err = New(noCache, memSink, &Config{
+				SelectedEvents: "LogMessage",
+				ExcludeFilter:  `app_name == "testing-app" && space_name == "testing-space"`,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(r.Route(msg)).Should(Succeed())
+			Expect(memSink.Events).To(BeEmpty())
+		})
+
+		It("looks up app metadata for a filter from memory only", func() {
+			eventType = events.Envelope_LogMessage
+			uncached := &uncachedAppsMock{}
+			r, err = New(uncached, memSink, &Config{
+				SelectedEvents: "LogMessage",
+				ExcludeFilter:  `app_name == "testing-app"`,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(r.Route(msg)).Should(Succeed())
+			Expect(memSink.Events).To(HaveLen(1))
+			Expect(uncached.lookups).To(BeZero())
+		})
+
+		It("passes events of apps not in memory through an include filter", func() {
+			eventType = events.Envelope_LogMessage
+			uncached := &uncachedAppsMock{}
+			r, err = New(uncached, memSink, &Config{
+				SelectedEvents: "LogMessage",
+				IncludeFilter:  `org_name == "other-org"`,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(r.Route(msg)).Should(Succeed())
+			Expect(memSink.Events).To(HaveLen(1))
+		})
+
+		It("passes the event parsed for the filters to the sink", func() {
+			sink := &parsedSinkMock{}
+			r, err = New(noCache, sink, &Config{
+				SelectedEvents: "HttpStartStop",
+				IncludeFilter:  `origin == "gorouter"`,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(r.Route(msg)).Should(Succeed())
+			Expect(sink.Events).To(HaveLen(1))
+			Expect(sink.parsed).To(HaveLen(1))
+			Expect(sink.parsed[0].Fields).To(HaveKeyWithValue("origin", "gorouter"))
+			Expect(sink.parsed[0].Fields).To(HaveKeyWithValue("event_type", "HttpStartStop"))
+			Expect(sink.parsed[0].Fields).NotTo(HaveKey("tags"))
+		})
+
+		It("rejects an invalid filter", func() {
+			_, err = New(noCache, memSink, &Config{ExcludeFilter: `uri =~`})
+			Ω(err).Should(HaveOccurred())
+		})
+	})
 })
@@ -0,0 +1,564 @@
+package eventrouter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// fieldAliases lets filters use short names for the app metadata fields
+var fieldAliases = map[string]string{
+	"org_name":   "cf_org_name",
+	"space_name": "cf_space_name",
+	"app_name":   "cf_app_name",
+	"org_id":     "cf_org_id",
+	"space_id":   "cf_space_id",
+	"app_id":     "cf_app_id",
+}
+
+// Filter is a compiled filter expression, for example
+//
+//	origin == "gorouter" && status_code < 400 && uri =~ /health/
+//
+// Operands on the left are event field names, optionally dotted to reach
+// into maps such as tags.source_id. Supported operators are && || ! ( ) and
+// the comparisons == != < <= > >= =~ !~ in. Literals are "strings",
+// numbers, true/false, /regular expressions/ and [lists]. A bare field name
+// is true when the field is present and not empty, zero or false
+type Filter struct {
+	expr   string
+	root   filterNode
+	fields map[string]bool
+}
+
+// ParseFilter compiles a filter expression
+func ParseFilter(expr string) (*Filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %s", expr, err)
+	}
+
+	p := &filterParser{tokens: tokens, fields: map[string]bool{}}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokEOF {
+		err = fmt.Errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %s", expr, err)
+	}
+	return &Filter{expr: expr, root: root, fields: p.fields}, nil
+}
+
+// Match evaluates the filter against event fields
+func (f *Filter) Match(fields map[string]interface{}) bool {
+	return f.root.eval(fields)
+}
+
+// References reports whether the filter reads the field or a key of it
+func (f *Filter) References(field string) bool {
+	if f.fields[field] {
+		return true
+	}
+	for name := range f.fields {
+		if strings.HasPrefix(name, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// ReferencesAppData reports whether the filter reads a field which is only
+// available from the app metadata cache
+func (f *Filter) ReferencesAppData() bool {
+	for _, field := range appDataFields {
+		if f.References(field) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokRegex
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q at position %d", t.text, t.pos+1)
+}
+
+var filterOps = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!"}
+
+func lexFilter(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case c == '[':
+			tokens = append(tokens, token{tokLBracket, "[", i})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{tokRBracket, "]", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case c == '"' || c == '\'' || c == '/':
+			text, n, err := lexQuoted(expr[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%s at position %d", err, i+1)
+			}
+			kind := tokString
+			if c == '/' {
+				kind = tokRegex
+			}
+			tokens = append(tokens, token{kind, text, i})
+			i += n
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(expr) && (unicode.IsDigit(rune(expr[j])) || strings.ContainsRune(".eE+-", rune(expr[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, expr[i:j], i})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i + 1
+			for j < len(expr) && (expr[j] == '_' || expr[j] == '.' || unicode.IsLetter(rune(expr[j])) || unicode.IsDigit(rune(expr[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, expr[i:j], i})
+			i = j
+		default:
+			matched := false
+			for _, op := range filterOps {
+				if strings.HasPrefix(expr[i:], op) {
+					tokens = append(tokens, token{tokOp, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i+1)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(expr)}), nil
+}
+
+// lexQuoted reads a string or regex delimited by its first character. A
+// backslash escapes the delimiter; in strings it also escapes itself
+func lexQuoted(s string) (string, int, error) {
+	delim := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == delim:
+			return b.String(), i + 1, nil
+		case c == '\\' && i+1 < len(s) && (s[i+1] == delim || (delim != '/' && s[i+1] == '\\')):
+			b.WriteByte(s[i+1])
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated %c", delim)
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+	fields map[string]bool
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *filterParser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.isOp("!") {
+		p.next()
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{node}, nil
+	}
+
+	if p.peek().kind == tokLParen {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) but got %s", t)
+		}
+		return node, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, fmt.Errorf("expected field name but got %s", t)
+	}
+	field := t.text
+	if alias, ok := fieldAliases[field]; ok {
+		field = alias
+	}
+	p.fields[field] = true
+
+	op := p.peek()
+	switch {
+	case op.kind == tokIdent && op.text == "in":
+		p.next()
+		list, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &inNode{field, list}, nil
+	case op.kind == tokOp && (op.text == "=~" || op.text == "!~"):
+		p.next()
+		t := p.next()
+		if t.kind != tokRegex && t.kind != tokString {
+			return nil, fmt.Errorf("expected regular expression but got %s", t)
+		}
+		re, err := regexp.Compile(t.text)
+		if err != nil {
+			return nil, err
+		}
+		return &regexNode{field, re, op.text == "!~"}, nil
+	case op.kind == tokOp && strings.ContainsAny(op.text, "=<>") && op.text != "!":
+		p.next()
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		if _, isBool := value.(bool); isBool && op.text != "==" && op.text != "!=" {
+			return nil, fmt.Errorf("operator %s can not compare booleans", op.text)
+		}
+		return &compareNode{field, op.text, value}, nil
+	}
+	return &fieldNode{field}, nil
+}
+
+func (p *filterParser) parseList() ([]interface{}, error) {
+	if t := p.next(); t.kind != tokLBracket {
+		return nil, fmt.Errorf("expected [ but got %s", t)
+	}
+	var list []interface{}
+	if p.peek().kind == tokRBracket {
+		p.next()
+		return list, nil
+	}
+	for {
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+
+		t := p.next()
+		if t.kind == tokRBracket {
+			return list, nil
+		}
+		if t.kind != tokComma {
+			return nil, fmt.Errorf("expected , or ] but got %s", t)
+		}
+	}
+}
+
+func (p *filterParser) parseLiteral() (interface{}, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokString:
+		return t.text, nil
+	case t.kind == tokNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t)
+		}
+		return n, nil
+	case t.kind == tokIdent && (t.text == "true" || t.text == "false"):
+		return t.text == "true", nil
+	}
+	return nil, fmt.Errorf("expected value but got %s", t)
+}
+
+type filterNode interface {
+	eval(fields map[string]interface{}) bool
+}
+
+type andNode struct{ left, right filterNode }
+
+func (n *andNode) eval(fields map[string]interface{}) bool {
+	return n.left.eval(fields) && n.right.eval(fields)
+}
+
+type orNode struct{ left, right filterNode }
+
+func (n *orNode) eval(fields map[string]interface{}) bool {
+	return n.left.eval(fields) || n.right.eval(fields)
+}
+
+type notNode struct{ node filterNode }
+
+func (n *notNode) eval(fields map[string]interface{}) bool {
+	return !n.node.eval(fields)
+}
+
+type fieldNode struct{ field string }
+
+func (n *fieldNode) eval(fields map[string]interface{}) bool {
+	v, ok := lookupField(fields, n.field)
+	if !ok || v == nil {
+		return false
+	}
+	switch val := v.(type) {
+	case bool:
+		return val
+	case string:
+		return val != ""
+	}
+	if num, ok := toNumber(v); ok {
+		return num != 0
+	}
+	return true
+}
+
+// compareNode treats a missing field as unequal to every value and as
+// neither less nor greater than any value
+type compareNode struct {
+	field string
+	op    string
+	value interface{}
+}
+
+func (n *compareNode) eval(fields map[string]interface{}) bool {
+	v, ok := lookupField(fields, n.field)
+	switch n.op {
+	case "==":
+		return ok && equalValue(v, n.value)
+	case "!=":
+		return !ok || !equalValue(v, n.value)
+	}
+	if !ok {
+		return false
+	}
+
+	var cmp int
+	if want, isNum := n.value.(float64); isNum {
+		got, ok := toNumber(v)
+		if !ok {
+			return false
+		}
+		switch {
+		case got < want:
+			cmp = -1
+		case got > want:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(toString(v), n.value.(string))
+	}
+
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+type regexNode struct {
+	field  string
+	re     *regexp.Regexp
+	negate bool
+}
+
+func (n *regexNode) eval(fields map[string]interface{}) bool {
+	v, ok := lookupField(fields, n.field)
+	if !ok {
+		return n.negate
+	}
+	return n.re.MatchString(toString(v)) != n.negate
+}
+
+type inNode struct {
+	field string
+	list  []interface{}
+}
+
+func (n *inNode) eval(fields map[string]interface{}) bool {
+	v, ok := lookupField(fields, n.field)
+	if !ok {
+		return false
+	}
+	for _, item := range n.list {
+		if equalValue(v, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupField returns the field value, descending into maps for dotted
+// names which are not a field themselves
+func lookupField(fields map[string]interface{}, name string) (interface{}, bool) {
+	if v, ok := fields[name]; ok {
+		return v, true
+	}
+
+	i := strings.Index(name, ".")
+	if i < 0 {
+		return nil, false
+	}
+	switch m := fields[name[:i]].(type) {
+	case map[string]interface{}:
+		return lookupField(m, name[i+1:])
+	case map[string]string:
+		v, ok := m[name[i+1:]]
+		return v, ok
+	}
+	return nil, false
+}
+
+func equalValue(v interface{}, want interface{}) bool {
+	switch w := want.(type) {
+	case string:
+		return toString(v) == w
+	case float64:
+		got, ok := toNumber(v)
+		return ok && got == w
+	case bool:
+		got, ok := v.(bool)
+		return ok && got == w
+	}
+	return false
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,102 @@
+package eventrouter_test
+
+import (
+	"encoding/json"
+
+	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filter", func() {
+	var fields map[string]interface{}
+
+	BeforeEach(func() {
+		fields = map[string]interface{}{
+			"origin":      "gorouter",
+			"status_code": int32(200),
+			"uri":         "http://app.example.com/healthcheck",
+			"cf_org_name": "sales",
+			"duration_ms": int64(12),
+			"value":       json.Number("0.5"),
+			"forwarded":   []string{},
+			"tags":        map[string]string{"source_id": "abc"},
+			"msg":         "GET /healthcheck",
+			"ignored":     false,
+		}
+	})
+
+	match := func(expr string) bool {
+		f, err := ParseFilter(expr)
+		Expect(err).ShouldNot(HaveOccurred())
+		return f.Match(fields)
+	}
+
+	It("compares strings and numbers", func() {
+		Expect(match(`origin == "gorouter" && status_code < 400`)).To(BeTrue())
+		Expect(match(`origin == 'rep' || status_code >= 500`)).To(BeFalse())
+		Expect(match(`duration_ms <= 12 && duration_ms > 11.5`)).To(BeTrue())
+		Expect(match(`value < 1`)).To(BeTrue())
+		Expect(match(`status_code != 200`)).To(BeFalse())
+		Expect(match(`origin > "a"`)).To(BeTrue())
+	})
+
+	It("matches regular expressions", func() {
+		Expect(match(`msg =~ /healthcheck/`)).To(BeTrue())
+		Expect(match(`uri !~ /health/`)).To(BeFalse())
+		Expect(match(`uri =~ /^http:\/\/app\./`)).To(BeTrue())
+		Expect(match(`msg =~ "^GET"`)).To(BeTrue())
+	})
+
+	It("supports lists, aliases and nested fields", func() {
+		Expect(match(`org_name in ["dev", "sales"]`)).To(BeTrue())
+		Expect(match(`status_code in [404, 500]`)).To(BeFalse())
+		Expect(match(`tags.source_id == "abc"`)).To(BeTrue())
+	})
+
+	It("supports negation, grouping and bare fields", func() {
+		Expect(match(`!(origin == "gorouter" && uri =~ /health/)`)).To(BeFalse())
+		Expect(match(`(origin == "rep" || status_code == 200) && !ignored`)).To(BeTrue())
+		Expect(match(`ignored == false && msg`)).To(BeTrue())
+	})
+
+	It("treats missing fields as not matching", func() {
+		Expect(match(`app_name == "foo"`)).To(BeFalse())
+		Expect(match(`app_name != "foo"`)).To(BeTrue())
+		Expect(match(`missing < 10 || missing >= 10`)).To(BeFalse())
+		Expect(match(`missing !~ /x/`)).To(BeTrue())
+		Expect(match(`missing in ["x"]`)).To(BeFalse())
+		Expect(match(`missing`)).To(BeFalse())
+	})
+
+	It("reports referenced fields", func() {
+		f, err := ParseFilter(`app_name == "x" || origin == "rep"`)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(f.References("cf_app_name")).To(BeTrue())
+		Expect(f.References("origin")).To(BeTrue())
+		Expect(f.References("cf_org_name")).To(BeFalse())
+
+		f, err = ParseFilter(`cf_app_labels.team == "payments"`)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(f.References("cf_app_labels")).To(BeTrue())
+		Expect(f.Match(map[string]interface{}{"cf_app_labels": map[string]string{"team": "payments"}})).To(BeTrue())
+	})
+
+	It("rejects invalid expressions", func() {
+		for _, expr := range []string{
+			`origin ==`,
+			`origin == "gorouter" &&`,
+			`(origin == "x"`,
+			`origin == "x")`,
+			`msg =~ /(/`,
+			`msg =~ /unterminated`,
+			`status_code in 200`,
+			`ignored < true`,
+			`== "x"`,
+			`origin # "x"`,
+		} {
+			_, err := ParseFilter(expr)
+			Expect(err).To(HaveOccurred(), expr)
+		}
+	})
+})
@@ -7,3 +7,9 @@ import (
 type Router interface {
 	Route(msg *events.Envelope) error
 }
+
+// Reloader is implemented by routers whose selected events and filters can
+// be changed while events are routed
+type Reloader interface {
+	Reload(config *Config) error
+}
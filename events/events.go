@@ -18,16 +18,40 @@ type Event struct {
 	Fields map[string]interface{}
 	Msg    string
 	Type   string
+
+	// tags of the envelope, kept for app annotations even without AddTags
+	tags map[string]string
 }
 
 type Config struct {
 	SelectedEvents string
+	IncludeFilter  string // only events matching this filter expression are routed
+	ExcludeFilter  string // events matching this filter expression are dropped
 	AddAppName     bool
 	AddOrgName     bool
 	AddOrgGuid     bool
 	AddSpaceName   bool
 	AddSpaceGuid   bool
 	AddTags        bool
+
+	// CF labels and annotations added as cf_<scope>_labels and
+	// cf_<scope>_annotations maps, restricted to keys matching
+	// MetadataInclude and not MetadataExclude prefixes
+	AddAppLabels        bool
+	AddAppAnnotations   bool
+	AddSpaceLabels      bool
+	AddSpaceAnnotations bool
+	AddOrgLabels        bool
+	AddOrgAnnotations   bool
+	MetadataInclude     []string
+	MetadataExclude     []string
+
+	AddAppState      bool
+	AddStack         bool
+	AddBuildpacks    bool
+	AddDropletGuid   bool
+	AddProcessTypes  bool
+	AddInstanceCount bool
 }
 
 var AppMetadata = []string{
@@ -36,6 +60,18 @@ var AppMetadata = []string{
 	"OrgGuid",
 	"SpaceName",
 	"SpaceGuid",
+	"AppLabels",
+	"AppAnnotations",
+	"SpaceLabels",
+	"SpaceAnnotations",
+	"OrgLabels",
+	"OrgAnnotations",
+	"AppState",
+	"Stack",
+	"Buildpacks",
+	"DropletGuid",
+	"ProcessTypes",
+	"InstanceCount",
 }
 
 func HttpStart(msg *events.Envelope) *Event {
@@ -195,27 +231,48 @@ func ContainerMetric(msg *events.Envelope) *Event {
 	}
 }
 
-func (e *Event) AnnotateWithAppData(appCache cache.Cache, config *Config) {
-	cfAppId := e.Fields["cf_app_id"]
-	appGuid := fmt.Sprintf("%s", cfAppId)
-
-	if cfAppId == nil || cfAppId == "" || appGuid == "<nil>" {
-		return
+// FromEnvelope converts an envelope to an Event of the matching type, or
+// returns nil for unsupported event types
+func FromEnvelope(msg *events.Envelope) *Event {
+	switch msg.GetEventType() {
+	case events.Envelope_HttpStartStop:
+		return HttpStartStop(msg)
+	case events.Envelope_LogMessage:
+		return LogMessage(msg)
+	case events.Envelope_ValueMetric:
+		return ValueMetric(msg)
+	case events.Envelope_CounterEvent:
+		return CounterEvent(msg)
+	case events.Envelope_Error:
+		return ErrorEvent(msg)
+	case events.Envelope_ContainerMetric:
+		return ContainerMetric(msg)
+	case events.Envelope_HttpStart:
+		return HttpStart(msg)
+	case events.Envelope_HttpStop:
+		return HttpStop(msg)
+	default:
+		return nil
 	}
+}
 
-	// Skip non-UUID app IDs (e.g. system component names like "routing_api")
-	if err := uuid.Validate(appGuid); err != nil {
+func (e *Event) AnnotateWithAppData(appCache cache.Cache, config *Config) {
+	appGuid, ok := e.appGuid()
+	if !ok {
 		return
 	}
 
 	appInfo, err := appCache.GetApp(appGuid)
 	if err != nil {
-		if err == cache.ErrMissingAlreadyCached {
+		if err == cache.ErrAppPending {
+			// Events of the app are enriched once the lookup completes
+			e.Fields["cf_app_metadata_pending"] = true
+		} else if err == cache.ErrMissingAlreadyCached {
 			// Already recorded as missing; skip silently to avoid log noise.
 		} else if err == cache.ErrMissingAndIgnored {
-			logrus.Info(err.Error(), " (", cfAppId, ")")
+			logrus.Info(err.Error(), " (", appGuid, ")")
 		} else if cache.IsResourceNotFound(err) {
-			logrus.Warn("App no longer exists in CF: ", cfAppId)
+			logrus.Warn("App no longer exists in CF: ", appGuid)
 		} else {
 			logrus.Error("Failed to fetch application metadata from remote: ", err)
 		}
@@ -227,6 +284,37 @@ func (e *Event) AnnotateWithAppData(appCache cache.Cache, config *Config) {
 	e.parseAndAnnotateWithAppInfo(appInfo, config)
 }
 
+// AnnotateWithCachedAppData annotates the event only when its app is in
+// memory, so it never waits for a remote lookup. It reports false when the
+// event has an app which is not in memory
+func (e *Event) AnnotateWithCachedAppData(appCache cache.Cache, config *Config) bool {
+	appGuid, ok := e.appGuid()
+	if !ok {
+		return true
+	}
+	appInfo, cached := cache.GetCachedApp(appCache, appGuid)
+	if !cached || appInfo == nil {
+		return false
+	}
+	e.parseAndAnnotateWithAppInfo(appInfo, config)
+	return true
+}
+
+// appGuid returns the app GUID of the event. System components with non-UUID
+// app IDs, e.g. "routing_api", have no app
+func (e *Event) appGuid() (string, bool) {
+	cfAppId := e.Fields["cf_app_id"]
+	appGuid := fmt.Sprintf("%s", cfAppId)
+
+	if cfAppId == nil || cfAppId == "" || appGuid == "<nil>" {
+		return "", false
+	}
+	if err := uuid.Validate(appGuid); err != nil {
+		return "", false
+	}
+	return appGuid, true
+}
+
 func (e *Event) parseAndAnnotateWithAppInfo(appInfo *cache.App, config *Config) {
 	cfAppName := appInfo.Name
 	cfSpaceId := appInfo.SpaceGuid
@@ -256,6 +344,47 @@ func (e *Event) parseAndAnnotateWithAppInfo(appInfo *cache.App, config *Config)
 		e.Fields["cf_org_name"] = cfOrgName
 	}
 
+	e.addMetadata("cf_app_labels", appInfo.Labels, config.AddAppLabels, config)
+	e.addMetadata("cf_app_annotations", appInfo.Annotations, config.AddAppAnnotations, config)
+	e.addMetadata("cf_space_labels", appInfo.SpaceLabels, config.AddSpaceLabels, config)
+	e.addMetadata("cf_space_annotations", appInfo.SpaceAnnotations, config.AddSpaceAnnotations, config)
+	e.addMetadata("cf_org_labels", appInfo.OrgLabels, config.AddOrgLabels, config)
+	e.addMetadata("cf_org_annotations", appInfo.OrgAnnotations, config.AddOrgAnnotations, config)
+
+	if appInfo.State != "" && config.AddAppState {
+		e.Fields["cf_app_state"] = appInfo.State
+	}
+
+	if appInfo.Stack != "" && config.AddStack {
+		e.Fields["cf_stack"] = appInfo.Stack
+	}
+
+	if len(appInfo.Buildpacks) > 0 && config.AddBuildpacks {
+		e.Fields["cf_buildpacks"] = appInfo.Buildpacks
+	}
+
+	if appInfo.DropletGuid != "" && config.AddDropletGuid {
+		e.Fields["cf_droplet_guid"] = appInfo.DropletGuid
+	}
+
+	if len(appInfo.ProcessTypes) > 0 && config.AddProcessTypes {
+		e.Fields["cf_process_types"] = appInfo.ProcessTypes
+	}
+
+	if config.AddProcessTypes {
+		processType, processInstance := e.process()
+		if processType != "" {
+			e.Fields["cf_process_type"] = processType
+		}
+		if processInstance != "" {
+			e.Fields["cf_process_instance_id"] = processInstance
+		}
+	}
+
+	if appInfo.InstanceCount > 0 && config.AddInstanceCount {
+		e.Fields["cf_app_instances"] = appInfo.InstanceCount
+	}
+
 	if appProperties["SPLUNK_INDEX"] != nil {
 		e.Fields["info_splunk_index"] = appProperties["SPLUNK_INDEX"]
 	}
@@ -265,6 +394,76 @@ func (e *Event) parseAndAnnotateWithAppInfo(appInfo *cache.App, config *Config)
 	}
 }
 
+// process returns the process type and instance which emitted the event. The
+// RLP gateway tags envelopes with both, otherwise they are taken from the log
+// source type, e.g. APP/PROC/WEB, and the instance index
+func (e *Event) process() (string, string) {
+	processType := e.tags["process_type"]
+	if processType == "" {
+		if sourceType, ok := e.Fields["source_type"].(string); ok && strings.HasPrefix(sourceType, "APP/PROC/") {
+			processType = strings.ToLower(strings.SplitN(strings.TrimPrefix(sourceType, "APP/PROC/"), "/", 2)[0])
+		}
+	}
+
+	processInstance := e.tags["process_instance_id"]
+	if processInstance == "" {
+		for _, field := range []string{"source_instance", "instance_index"} {
+			if index, ok := e.Fields[field]; ok {
+				processInstance = fmt.Sprintf("%v", index)
+				break
+			}
+		}
+	}
+	return processType, processInstance
+}
+
+// addMetadata adds the allowed keys of CF labels or annotations as a map field
+func (e *Event) addMetadata(field string, metadata map[string]string, enabled bool, config *Config) {
+	if !enabled || len(metadata) == 0 {
+		return
+	}
+
+	values := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if config.AllowMetadata(k) {
+			values[k] = v
+		}
+	}
+	if len(values) > 0 {
+		e.Fields[field] = values
+	}
+}
+
+// AllowMetadata reports whether a label or annotation key passes the
+// include and exclude prefixes
+func (c *Config) AllowMetadata(key string) bool {
+	for _, prefix := range c.MetadataExclude {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+	if len(c.MetadataInclude) == 0 {
+		return true
+	}
+	for _, prefix := range c.MetadataInclude {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePrefixes splits a comma separated list of label and annotation key prefixes
+func ParsePrefixes(prefixes string) []string {
+	var result []string
+	for _, prefix := range strings.Split(prefixes, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			result = append(result, prefix)
+		}
+	}
+	return result
+}
+
 func (e *Event) AnnotateWithCFMetaData() {
 	e.Fields["event_type"] = e.Type
 }
@@ -276,6 +475,7 @@ func (e *Event) AnnotateWithEnvelopeData(msg *events.Envelope, config *Config) {
 	e.Fields["job"] = msg.GetJob()
 	e.Fields["job_index"] = msg.GetIndex()
 	e.Type = msg.GetEventType().String()
+	e.tags = msg.GetTags()
 
 	if config.AddTags {
 		e.Fields["tags"] = msg.GetTags()
@@ -3,6 +3,7 @@ package events_test
 import (
 	"math"
 
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
 	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
 	. "github.com/cloudfoundry/sonde-go/events"
@@ -209,6 +210,13 @@ var _ = Describe("Events", func() {
 		})
 	})
 
+	It("Marks events whose app metadata is pending", func() {
+		fcache.SetErr(cache.ErrAppPending)
+		event.AnnotateWithAppData(fcache, &fevents.Config{AddAppName: true})
+		Expect(event.Fields["cf_app_metadata_pending"]).To(BeTrue())
+		Expect(event.Fields).NotTo(HaveKey("cf_app_name"))
+	})
+
 	It("HttpStart", func() {
 		var config = &fevents.Config{
 			AddAppName:   true,
@@ -225,6 +233,86 @@ var _ = Describe("Events", func() {
 		Expect(event.Fields["cf_org_name"]).To(Equal("testing-org"))
 	})
 
+	Context("given labels and annotations", func() {
+		It("adds nothing unless enabled", func() {
+			event.AnnotateWithAppData(fcache, &fevents.Config{AddAppName: true})
+			Expect(event.Fields).NotTo(HaveKey("cf_app_labels"))
+			Expect(event.Fields).NotTo(HaveKey("cf_app_annotations"))
+		})
+
+		It("adds the enabled scopes", func() {
+			event.AnnotateWithAppData(fcache, &fevents.Config{
+				AddAppLabels:      true,
+				AddAppAnnotations: true,
+				AddOrgLabels:      true,
+			})
+			Expect(event.Fields["cf_app_labels"]).To(Equal(map[string]string{"team": "payments", "example.com/tier": "gold"}))
+			Expect(event.Fields["cf_app_annotations"]).To(Equal(map[string]string{"owner": "payments@example.com"}))
+			Expect(event.Fields["cf_org_labels"]).To(Equal(map[string]string{"cost-center": "cc-42"}))
+			Expect(event.Fields).NotTo(HaveKey("cf_space_labels"))
+			Expect(event.Fields).NotTo(HaveKey("cf_org_annotations"))
+		})
+
+		It("filters keys by prefix", func() {
+			event.AnnotateWithAppData(fcache, &fevents.Config{
+				AddAppLabels:        true,
+				AddSpaceLabels:      true,
+				AddSpaceAnnotations: true,
+				MetadataInclude:     fevents.ParsePrefixes("team, example.com/, env"),
+				MetadataExclude:     fevents.ParsePrefixes("example.com/"),
+			})
+			Expect(event.Fields["cf_app_labels"]).To(Equal(map[string]string{"team": "payments"}))
+			Expect(event.Fields["cf_space_labels"]).To(Equal(map[string]string{"env": "prod"}))
+			Expect(event.Fields).NotTo(HaveKey("cf_space_annotations"))
+		})
+	})
+
+	Context("given deployment info", func() {
+		It("adds the enabled fields", func() {
+			event.AnnotateWithAppData(fcache, &fevents.Config{
+				AddAppState:      true,
+				AddStack:         true,
+				AddBuildpacks:    true,
+				AddDropletGuid:   true,
+				AddProcessTypes:  true,
+				AddInstanceCount: true,
+			})
+			Expect(event.Fields["cf_app_state"]).To(Equal("STARTED"))
+			Expect(event.Fields["cf_stack"]).To(Equal("cflinuxfs4"))
+			Expect(event.Fields["cf_buildpacks"]).To(Equal([]string{"java_buildpack@4.77.0"}))
+			Expect(event.Fields["cf_droplet_guid"]).To(Equal("9ad4a4b0-1c8e-4bfe-a1b6-0f37a5cdb0f1"))
+			Expect(event.Fields["cf_process_types"]).To(Equal([]string{"web", "worker"}))
+			Expect(event.Fields["cf_process_instance_id"]).To(Equal(">9000"))
+			Expect(event.Fields).NotTo(HaveKey("cf_process_type"))
+			Expect(event.Fields["cf_app_instances"]).To(Equal(3))
+		})
+
+		It("adds the process of the event", func() {
+			sourceType := "APP/PROC/WORKER"
+			msg.LogMessage.SourceType = &sourceType
+			event = fevents.LogMessage(msg)
+			event.AnnotateWithEnvelopeData(msg, &fevents.Config{})
+			event.AnnotateWithAppData(fcache, &fevents.Config{AddProcessTypes: true})
+			Expect(event.Fields["cf_process_type"]).To(Equal("worker"))
+			Expect(event.Fields["cf_process_instance_id"]).To(Equal(">9000"))
+
+			msg.Tags = map[string]string{"process_type": "web", "process_instance_id": "6d2a8f0c-1e2b-4c3d-5e6f-7a8b9c0d1e2f"}
+			event = fevents.LogMessage(msg)
+			event.AnnotateWithEnvelopeData(msg, &fevents.Config{})
+			event.AnnotateWithAppData(fcache, &fevents.Config{AddProcessTypes: true})
+			Expect(event.Fields["cf_process_type"]).To(Equal("web"))
+			Expect(event.Fields["cf_process_instance_id"]).To(Equal("6d2a8f0c-1e2b-4c3d-5e6f-7a8b9c0d1e2f"))
+			Expect(event.Fields).NotTo(HaveKey("tags"))
+		})
+
+		It("adds nothing unless enabled", func() {
+			event.AnnotateWithAppData(fcache, &fevents.Config{AddAppName: true})
+			for _, field := range []string{"cf_app_state", "cf_stack", "cf_buildpacks", "cf_droplet_guid", "cf_process_types", "cf_process_type", "cf_process_instance_id", "cf_app_instances"} {
+				Expect(event.Fields).NotTo(HaveKey(field))
+			}
+		})
+	})
+
 	Context("ParseSelectedEvents, empty select events passed in", func() {
 		It("should return a hash of only the default event", func() {
 			results, err := fevents.ParseSelectedEvents("")
@@ -0,0 +1,230 @@
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"regexp"
+	"strings"
+)
+
+const (
+	RedactMask      = "mask"
+	RedactHash      = "hash"
+	RedactDropField = "drop-field"
+)
+
+// RedactionRule replaces what Pattern matches in log messages. When the
+// pattern has a capture group only the first group is redacted, so context
+// such as "Bearer " is kept
+type RedactionRule struct {
+	Name    string `json:"name" yaml:"name"`
+	Pattern string `json:"pattern" yaml:"pattern"`
+	Action  string `json:"action,omitempty" yaml:"action,omitempty"`
+
+	regex *regexp.Regexp
+	valid func(string) bool
+}
+
+type redactionDetector struct {
+	pattern string
+	valid   func(string) bool
+}
+
+// redactionDetectors are the built-in rules, by name
+var redactionDetectors = map[string]redactionDetector{
+	"credit_card": {`\b(?:\d[ -]?){12,18}\d\b`, luhnValid},
+	"email":       {`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`, nil},
+	"bearer":      {`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`, nil},
+	"aws_key":     {`\b((?:AKIA|ASIA)[0-9A-Z]{16})\b|(?i)aws_secret_access_key["']?\s*[=:]\s*["']?([A-Za-z0-9/+=]{40})`, nil},
+	"jwt":         {`\beyJ[A-Za-z0-9_\-]+\.eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`, nil},
+}
+
+// Redactor removes personal data and secrets from log messages
+type Redactor struct {
+	rules   []*RedactionRule
+	hashKey []byte
+}
+
+// ParseRedactor builds a Redactor from a comma separated list of built-in
+// detectors with an optional action, such as "credit_card,email:hash", and a
+// JSON array of custom rules. Hashes are HMAC-SHA256 when hashKey is set
+func ParseRedactor(detectors, patterns, hashKey string) (*Redactor, error) {
+	var rules []*RedactionRule
+	for _, spec := range strings.Split(detectors, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, action := spec, RedactMask
+		if i := strings.Index(spec, ":"); i >= 0 {
+			name, action = strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+		}
+		detector, ok := redactionDetectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redaction detector %q", name)
+		}
+		rules = append(rules, &RedactionRule{Name: name, Pattern: detector.pattern, Action: action, valid: detector.valid})
+	}
+
+	if patterns = strings.TrimSpace(patterns); patterns != "" {
+		var custom []*RedactionRule
+		if err := json.Unmarshal([]byte(patterns), &custom); err != nil {
+			return nil, fmt.Errorf("invalid redaction patterns: %s", err)
+		}
+		rules = append(rules, custom...)
+	}
+	return NewRedactor(rules, hashKey)
+}
+
+// NewRedactor validates the rules and compiles their patterns
+func NewRedactor(rules []*RedactionRule, hashKey string) (*Redactor, error) {
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("pattern%d", i+1)
+		}
+		switch rule.Action {
+		case "":
+			rule.Action = RedactMask
+		case RedactMask, RedactHash, RedactDropField:
+		default:
+			return nil, fmt.Errorf("redaction rule %s has invalid action %q, must be one of mask, hash or drop-field", rule.Name, rule.Action)
+		}
+
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %s has invalid pattern: %s", rule.Name, err)
+		}
+		rule.regex = re
+	}
+
+	r := &Redactor{rules: rules}
+	if hashKey != "" {
+		r.hashKey = []byte(hashKey)
+	}
+	return r, nil
+}
+
+// Len returns the number of rules
+func (r *Redactor) Len() int {
+	return len(r.rules)
+}
+
+// Redact redacts a message as returned by utils.ToJson: a plain string, or a
+// parsed JSON object or array whose string values are redacted in place.
+// drop is set when a plain string message matched a drop-field rule, fields
+// of JSON messages are dropped from their parent instead
+func (r *Redactor) Redact(msg interface{}) (redacted interface{}, drop bool, changed bool) {
+	return r.redactValue(msg)
+}
+
+func (r *Redactor) redactValue(value interface{}) (interface{}, bool, bool) {
+	switch v := value.(type) {
+	case string:
+		return r.redactString(v)
+	case map[string]interface{}:
+		changed := false
+		for key, elem := range v {
+			redacted, drop, ch := r.redactValue(elem)
+			changed = changed || ch
+			if drop {
+				delete(v, key)
+			} else if ch {
+				v[key] = redacted
+			}
+		}
+		return v, false, changed
+	case []interface{}:
+		changed := false
+		kept := v[:0]
+		for _, elem := range v {
+			redacted, drop, ch := r.redactValue(elem)
+			changed = changed || ch
+			if !drop {
+				kept = append(kept, redacted)
+			}
+		}
+		return kept, false, changed
+	}
+	return value, false, false
+}
+
+func (r *Redactor) redactString(s string) (string, bool, bool) {
+	changed := false
+	for _, rule := range r.rules {
+		matches := rule.regex.FindAllStringSubmatchIndex(s, -1)
+		if len(matches) == 0 {
+			continue
+		}
+
+		var b strings.Builder
+		last := 0
+		for _, m := range matches {
+			start, end := secretSpan(m)
+			if rule.valid != nil && !rule.valid(s[start:end]) {
+				continue
+			}
+			if rule.Action == RedactDropField {
+				return "", true, true
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(r.replacement(rule, s[start:end]))
+			last = end
+			changed = true
+		}
+		if last > 0 {
+			b.WriteString(s[last:])
+			s = b.String()
+		}
+	}
+	return s, false, changed
+}
+
+// secretSpan returns the first matched capture group, or the whole match
+func secretSpan(m []int) (int, int) {
+	for i := 2; i+1 < len(m); i += 2 {
+		if m[i] >= 0 {
+			return m[i], m[i+1]
+		}
+	}
+	return m[0], m[1]
+}
+
+func (r *Redactor) replacement(rule *RedactionRule, secret string) string {
+	if rule.Action != RedactHash {
+		return "[REDACTED:" + rule.Name + "]"
+	}
+
+	var h hash.Hash
+	if r.hashKey != nil {
+		h = hmac.New(sha256.New, r.hashKey)
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(secret))
+	return "[REDACTED:" + rule.Name + ":" + hex.EncodeToString(h.Sum(nil))[:16] + "]"
+}
+
+// luhnValid checks the credit card number checksum, ignoring separators
+func luhnValid(number string) bool {
+	sum, digits := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
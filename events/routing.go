@@ -0,0 +1,126 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RoutingMatch describes which events a routing rule applies to. Every
+// non-empty condition must hold. Named conditions are exact matches, Fields
+// maps an event field name to a regular expression its value must match
+type RoutingMatch struct {
+	Org        string            `json:"org,omitempty" yaml:"org,omitempty"`
+	Space      string            `json:"space,omitempty" yaml:"space,omitempty"`
+	App        string            `json:"app,omitempty" yaml:"app,omitempty"`
+	EventType  string            `json:"event_type,omitempty" yaml:"event_type,omitempty"`
+	Origin     string            `json:"origin,omitempty" yaml:"origin,omitempty"`
+	Job        string            `json:"job,omitempty" yaml:"job,omitempty"`
+	Deployment string            `json:"deployment,omitempty" yaml:"deployment,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+// RoutingRule assigns Splunk metadata, and optionally a different HEC
+// token, to the events it matches
+type RoutingRule struct {
+	Name       string       `json:"name,omitempty" yaml:"name,omitempty"`
+	Match      RoutingMatch `json:"match" yaml:"match"`
+	Index      string       `json:"index,omitempty" yaml:"index,omitempty"`
+	Sourcetype string       `json:"sourcetype,omitempty" yaml:"sourcetype,omitempty"`
+	Source     string       `json:"source,omitempty" yaml:"source,omitempty"`
+	Token      string       `json:"token,omitempty" yaml:"token,omitempty"`
+
+	fieldRegexes map[string]*regexp.Regexp
+}
+
+// RoutingTable is an ordered list of rules, the first matching rule wins
+type RoutingTable struct {
+	rules []*RoutingRule
+}
+
+// routingMatchFields maps the named match conditions to event fields
+var routingMatchFields = []struct {
+	field string
+	value func(m *RoutingMatch) string
+}{
+	{"cf_org_name", func(m *RoutingMatch) string { return m.Org }},
+	{"cf_space_name", func(m *RoutingMatch) string { return m.Space }},
+	{"cf_app_name", func(m *RoutingMatch) string { return m.App }},
+	{"event_type", func(m *RoutingMatch) string { return m.EventType }},
+	{"origin", func(m *RoutingMatch) string { return m.Origin }},
+	{"job", func(m *RoutingMatch) string { return m.Job }},
+	{"deployment", func(m *RoutingMatch) string { return m.Deployment }},
+}
+
+// ParseRoutingRules parses a JSON array of routing rules
+func ParseRoutingRules(rules string) (*RoutingTable, error) {
+	rules = strings.TrimSpace(rules)
+	if rules == "" {
+		return NewRoutingTable(nil)
+	}
+
+	var parsed []*RoutingRule
+	if err := json.Unmarshal([]byte(rules), &parsed); err != nil {
+		return nil, fmt.Errorf("invalid routing rules: %s", err)
+	}
+	return NewRoutingTable(parsed)
+}
+
+// NewRoutingTable validates rules and compiles their field expressions
+func NewRoutingTable(rules []*RoutingRule) (*RoutingTable, error) {
+	for i, rule := range rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		if rule.Index == "" && rule.Sourcetype == "" && rule.Source == "" && rule.Token == "" {
+			return nil, fmt.Errorf("routing rule %s must set at least one of index, sourcetype, source or token", name)
+		}
+
+		rule.fieldRegexes = make(map[string]*regexp.Regexp, len(rule.Match.Fields))
+		for field, expr := range rule.Match.Fields {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("routing rule %s has invalid regex for field %s: %s", name, field, err)
+			}
+			rule.fieldRegexes[field] = re
+		}
+	}
+	return &RoutingTable{rules: rules}, nil
+}
+
+// Len returns the number of rules
+func (t *RoutingTable) Len() int {
+	return len(t.rules)
+}
+
+// Route returns the first rule matching the event fields, or nil
+func (t *RoutingTable) Route(fields map[string]interface{}) *RoutingRule {
+	for _, rule := range t.rules {
+		if rule.matches(fields) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (r *RoutingRule) matches(fields map[string]interface{}) bool {
+	for _, m := range routingMatchFields {
+		want := m.value(&r.Match)
+		if want == "" {
+			continue
+		}
+		if got, ok := fields[m.field]; !ok || fmt.Sprint(got) != want {
+			return false
+		}
+	}
+
+	for field, re := range r.fieldRegexes {
+		got, ok := fields[field]
+		if !ok || !re.MatchString(fmt.Sprint(got)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package events_test
+
+import (
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routing", func() {
+	var fields map[string]interface{}
+
+	BeforeEach(func() {
+		fields = map[string]interface{}{
+			"cf_org_name":   "sales",
+			"cf_space_name": "prod-eu",
+			"cf_app_name":   "checkout",
+			"event_type":    "LogMessage",
+			"origin":        "rep",
+			"job":           "diego_cell",
+			"deployment":    "cf",
+			"msg":           "GET /health 200",
+		}
+	})
+
+	It("returns an empty table without rules", func() {
+		table, err := fevents.ParseRoutingRules("")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(table.Len()).To(Equal(0))
+		Expect(table.Route(fields)).To(BeNil())
+	})
+
+	It("picks the first matching rule", func() {
+		table, err := fevents.ParseRoutingRules(`[
+			{"name": "router", "match": {"event_type": "HttpStartStop"}, "index": "access"},
+			{"name": "sales", "match": {"org": "sales", "fields": {"cf_space_name": "^prod-"}}, "index": "sales_prod", "token": "sales-token"},
+			{"name": "platform", "match": {"deployment": "cf"}, "index": "system", "sourcetype": "cf:system"}
+		]`)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(table.Len()).To(Equal(3))
+
+		rule := table.Route(fields)
+		Expect(rule).NotTo(BeNil())
+		Expect(rule.Name).To(Equal("sales"))
+		Expect(rule.Index).To(Equal("sales_prod"))
+		Expect(rule.Token).To(Equal("sales-token"))
+
+		fields["cf_space_name"] = "dev"
+		rule = table.Route(fields)
+		Expect(rule.Name).To(Equal("platform"))
+		Expect(rule.Sourcetype).To(Equal("cf:system"))
+	})
+
+	It("requires every condition to match", func() {
+		table, err := fevents.ParseRoutingRules(`[{"match": {"app": "checkout", "origin": "gorouter"}, "index": "x"}]`)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(table.Route(fields)).To(BeNil())
+
+		fields["origin"] = "gorouter"
+		Expect(table.Route(fields)).NotTo(BeNil())
+	})
+
+	It("does not match missing fields", func() {
+		table, err := fevents.ParseRoutingRules(`[{"match": {"fields": {"status_code": "^5"}}, "source": "errors"}]`)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(table.Route(fields)).To(BeNil())
+
+		fields["status_code"] = int32(503)
+		Expect(table.Route(fields)).NotTo(BeNil())
+	})
+
+	It("rejects invalid rules", func() {
+		_, err := fevents.ParseRoutingRules(`{"index": "x"}`)
+		Expect(err).Should(HaveOccurred())
+
+		_, err = fevents.ParseRoutingRules(`[{"name": "noop", "match": {"app": "x"}}]`)
+		Expect(err).To(MatchError(ContainSubstring("routing rule noop must set")))
+
+		_, err = fevents.ParseRoutingRules(`[{"match": {"fields": {"msg": "("}}, "index": "x"}]`)
+		Expect(err).To(MatchError(ContainSubstring("invalid regex for field msg")))
+	})
+})
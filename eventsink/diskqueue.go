@@ -0,0 +1,359 @@
+package eventsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	segmentSuffix  = ".seg"
+	cursorFileName = "cursor"
+	recordHeader   = 4
+)
+
+var ErrDiskQueueFull = errors.New("disk spill queue is full")
+
+// ErrCorruptBatch is returned by Peek for a batch which can not be decoded.
+// The batch is removed, so it does not block the batches behind it
+var ErrCorruptBatch = errors.New("corrupt spilled batch")
+
+type DiskQueueConfig struct {
+	Dir         string
+	MaxSize     int64 // max bytes of pending batches on disk
+	SegmentSize int64 // segment file is rotated when it grows beyond this size
+}
+
+// DiskQueue is a persistent FIFO of event batches. Batches are appended to
+// length-prefixed segment files under Dir. The read position is kept in a
+// cursor file so batches already replayed are not sent again after restart.
+// Fully consumed segments are deleted. Batches hold the HEC tokens of routed
+// events, so Dir and its files are only accessible to the nozzle user
+type DiskQueue struct {
+	config *DiskQueueConfig
+
+	lock       sync.Mutex
+	segments   []uint64 // segment ids, oldest first
+	tail       *os.File
+	tailSize   int64
+	readOffset int64 // offset of the next batch in the oldest segment
+	size       int64 // pending bytes
+	count      int   // pending batches
+}
+
+func NewDiskQueue(config *DiskQueueConfig) (*DiskQueue, error) {
+	if err := os.MkdirAll(config.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("create spill queue dir: %s", err)
+	}
+	// MkdirAll keeps the mode of an existing dir
+	if err := os.Chmod(config.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("restrict spill queue dir: %s", err)
+	}
+
+	q := &DiskQueue{config: config}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// load discovers segments left by a previous run and positions the cursor
+func (q *DiskQueue) load() error {
+	entries, err := os.ReadDir(q.config.Dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, id)
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i] < q.segments[j] })
+
+	cursorID, cursorOffset := q.readCursor()
+	for len(q.segments) > 0 && q.segments[0] < cursorID {
+		os.Remove(q.segmentPath(q.segments[0]))
+		q.segments = q.segments[1:]
+	}
+	if len(q.segments) > 0 && q.segments[0] == cursorID {
+		q.readOffset = cursorOffset
+	}
+
+	for i, id := range q.segments {
+		offset := int64(0)
+		if i == 0 {
+			offset = q.readOffset
+		}
+		count, size, err := q.scanSegment(id, offset)
+		if err != nil {
+			return err
+		}
+		q.count += count
+		q.size += size
+	}
+
+	if len(q.segments) == 0 {
+		q.segments = append(q.segments, 1)
+	}
+	return q.openTail()
+}
+
+func (q *DiskQueue) readCursor() (uint64, int64) {
+	data, err := os.ReadFile(filepath.Join(q.config.Dir, cursorFileName))
+	if err != nil {
+		return 0, 0
+	}
+	var id uint64
+	var offset int64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &id, &offset); err != nil {
+		return 0, 0
+	}
+	return id, offset
+}
+
+func (q *DiskQueue) writeCursor() error {
+	tmp := filepath.Join(q.config.Dir, cursorFileName+".tmp")
+	data := fmt.Sprintf("%d %d", q.segments[0], q.readOffset)
+	if err := os.WriteFile(tmp, []byte(data), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(q.config.Dir, cursorFileName))
+}
+
+// scanSegment counts complete records from offset. A torn record at the
+// end of a segment (crash during write) is truncated away
+func (q *DiskQueue) scanSegment(id uint64, offset int64) (int, int64, error) {
+	f, err := os.OpenFile(q.segmentPath(id), os.O_RDWR, 0600)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	count := 0
+	pos := offset
+	header := make([]byte, recordHeader)
+	for {
+		if _, err := io.ReadFull(f, header); err != nil {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(header))
+		if _, err := f.Seek(length, io.SeekCurrent); err != nil {
+			break
+		}
+		info, err := f.Stat()
+		if err != nil || pos+recordHeader+length > info.Size() {
+			break
+		}
+		pos += recordHeader + length
+		count++
+	}
+
+	if err := f.Truncate(pos); err != nil {
+		return 0, 0, err
+	}
+	return count, pos - offset, nil
+}
+
+func (q *DiskQueue) segmentPath(id uint64) string {
+	return filepath.Join(q.config.Dir, fmt.Sprintf("%020d%s", id, segmentSuffix))
+}
+
+func (q *DiskQueue) openTail() error {
+	id := q.segments[len(q.segments)-1]
+	f, err := os.OpenFile(q.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	q.tail = f
+	q.tailSize = info.Size()
+	return nil
+}
+
+// Push appends a batch to the queue. ErrDiskQueueFull is returned when
+// accepting the batch would grow the queue beyond MaxSize
+func (q *DiskQueue) Push(batch []map[string]interface{}) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	recordSize := int64(recordHeader + len(data))
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.config.MaxSize > 0 && q.size+recordSize > q.config.MaxSize {
+		return ErrDiskQueueFull
+	}
+
+	if q.tailSize > 0 && q.tailSize+recordSize > q.config.SegmentSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+
+	record := make([]byte, recordSize)
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[recordHeader:], data)
+	if _, err := q.tail.Write(record); err != nil {
+		return err
+	}
+	if err := q.tail.Sync(); err != nil {
+		return err
+	}
+
+	q.tailSize += recordSize
+	q.size += recordSize
+	q.count++
+	return nil
+}
+
+func (q *DiskQueue) rotate() error {
+	if err := q.tail.Close(); err != nil {
+		return err
+	}
+	q.segments = append(q.segments, q.segments[len(q.segments)-1]+1)
+	return q.openTail()
+}
+
+// Peek returns the oldest batch without removing it, or nil if the queue
+// is empty
+func (q *DiskQueue) Peek() ([]map[string]interface{}, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	data, err := q.readHead()
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	// UseNumber keeps nanosecond timestamps and counters exact
+	var batch []map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&batch); err != nil {
+		if err := q.remove(data); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", ErrCorruptBatch, err)
+	}
+	return batch, nil
+}
+
+// Pop removes the oldest batch
+func (q *DiskQueue) Pop() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	data, err := q.readHead()
+	if err != nil || data == nil {
+		return err
+	}
+	return q.remove(data)
+}
+
+// remove must be called with the lock held. It moves the cursor past the
+// head record
+func (q *DiskQueue) remove(data []byte) error {
+	recordSize := int64(recordHeader + len(data))
+	q.readOffset += recordSize
+	q.size -= recordSize
+	q.count--
+
+	if len(q.segments) > 1 && q.readOffset >= q.segmentSize(q.segments[0]) {
+		os.Remove(q.segmentPath(q.segments[0]))
+		q.segments = q.segments[1:]
+		q.readOffset = 0
+	}
+	return q.writeCursor()
+}
+
+// readHead must be called with the lock held. Exhausted segments in front
+// of the tail are skipped and removed
+func (q *DiskQueue) readHead() ([]byte, error) {
+	for q.count > 0 {
+		id := q.segments[0]
+		if q.readOffset < q.segmentSize(id) {
+			return q.readRecord(id, q.readOffset)
+		}
+		if len(q.segments) == 1 {
+			return nil, nil
+		}
+		os.Remove(q.segmentPath(id))
+		q.segments = q.segments[1:]
+		q.readOffset = 0
+	}
+	return nil, nil
+}
+
+func (q *DiskQueue) readRecord(id uint64, offset int64) ([]byte, error) {
+	f, err := os.Open(q.segmentPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, recordHeader)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := f.ReadAt(data, offset+recordHeader); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (q *DiskQueue) segmentSize(id uint64) int64 {
+	if id == q.segments[len(q.segments)-1] {
+		return q.tailSize
+	}
+	info, err := os.Stat(q.segmentPath(id))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// Len returns the number of pending batches
+func (q *DiskQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.count
+}
+
+// Size returns the number of pending bytes
+func (q *DiskQueue) Size() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.size
+}
+
+func (q *DiskQueue) Close() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.tail.Close()
+}
@@ -0,0 +1,149 @@
+package eventsink_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+)
+
+var _ = Describe("DiskQueue", func() {
+	var (
+		dir    string
+		config *eventsink.DiskQueueConfig
+		queue  *eventsink.DiskQueue
+	)
+
+	batch := func(id int) []map[string]interface{} {
+		return []map[string]interface{}{
+			{"id": id, "time": "1467040874.046121775", "timestamp": int64(1467040874046121775)},
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "spill")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		config = &eventsink.DiskQueueConfig{
+			Dir:         dir,
+			MaxSize:     1 << 20,
+			SegmentSize: 200,
+		}
+		queue, err = eventsink.NewDiskQueue(config)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		queue.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("returns nothing when empty", func() {
+		b, err := queue.Peek()
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(b).To(BeNil())
+		Expect(queue.Len()).To(Equal(0))
+		Ω(queue.Pop()).ShouldNot(HaveOccurred())
+	})
+
+	It("replays batches in order across segments", func() {
+		for i := 0; i < 5; i++ {
+			Ω(queue.Push(batch(i))).ShouldNot(HaveOccurred())
+		}
+		Expect(queue.Len()).To(Equal(5))
+
+		for i := 0; i < 5; i++ {
+			b, err := queue.Peek()
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(b).To(HaveLen(1))
+			Expect(b[0]["id"]).To(Equal(json.Number(strconv.Itoa(i))))
+			Expect(b[0]["timestamp"]).To(Equal(json.Number("1467040874046121775")))
+			Ω(queue.Pop()).ShouldNot(HaveOccurred())
+		}
+		Expect(queue.Len()).To(Equal(0))
+		Expect(queue.Size()).To(Equal(int64(0)))
+	})
+
+	It("keeps pending batches across restarts", func() {
+		for i := 0; i < 4; i++ {
+			Ω(queue.Push(batch(i))).ShouldNot(HaveOccurred())
+		}
+		Ω(queue.Pop()).ShouldNot(HaveOccurred())
+		Ω(queue.Close()).ShouldNot(HaveOccurred())
+
+		var err error
+		queue, err = eventsink.NewDiskQueue(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(queue.Len()).To(Equal(3))
+
+		b, err := queue.Peek()
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(b[0]["id"]).To(Equal(json.Number("1")))
+	})
+
+	It("skips batches which can not be decoded", func() {
+		Ω(queue.Push(batch(0))).ShouldNot(HaveOccurred())
+		Ω(queue.Push(batch(1))).ShouldNot(HaveOccurred())
+		Ω(queue.Close()).ShouldNot(HaveOccurred())
+
+		// Garble the JSON of the first batch, keeping its length
+		segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(segments).To(HaveLen(1))
+		f, err := os.OpenFile(segments[0], os.O_WRONLY, 0600)
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = f.WriteAt([]byte("{"), 4)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(f.Close()).ShouldNot(HaveOccurred())
+
+		queue, err = eventsink.NewDiskQueue(config)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = queue.Peek()
+		Expect(errors.Is(err, eventsink.ErrCorruptBatch)).To(BeTrue())
+		Expect(queue.Len()).To(Equal(1))
+
+		b, err := queue.Peek()
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(b[0]["id"]).To(Equal(json.Number("1")))
+	})
+
+	It("keeps its files private", func() {
+		Ω(os.Chmod(dir, 0755)).ShouldNot(HaveOccurred())
+		Ω(queue.Close()).ShouldNot(HaveOccurred())
+		var err error
+		queue, err = eventsink.NewDiskQueue(config)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(queue.Push(batch(0))).ShouldNot(HaveOccurred())
+
+		info, err := os.Stat(dir)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0700)))
+		segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+		Ω(err).ShouldNot(HaveOccurred())
+		for _, segment := range segments {
+			info, err := os.Stat(segment)
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		}
+	})
+
+	It("rejects batches when full", func() {
+		queue.Close()
+		config.MaxSize = 150
+		var err error
+		queue, err = eventsink.NewDiskQueue(config)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(queue.Push(batch(0))).ShouldNot(HaveOccurred())
+		Expect(queue.Push(batch(1))).To(Equal(eventsink.ErrDiskQueueFull))
+		Expect(queue.Len()).To(Equal(1))
+	})
+})
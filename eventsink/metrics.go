@@ -0,0 +1,101 @@
+package eventsink
+
+import (
+	"math"
+	"strings"
+)
+
+const metricNamePrefix = "metric_name:"
+
+// containerMeasurements are the ContainerMetric fields sent as measurements
+// of a single multi-measurement metric event
+var containerMeasurements = []string{"cpu_percentage", "memory_bytes", "memory_bytes_quota", "disk_bytes", "disk_bytes_quota"}
+
+// metricIgnoredFields are never sent as metric dimensions
+var metricIgnoredFields = map[string]bool{
+	"timestamp":         true,
+	"msg":               true,
+	"info_splunk_index": true,
+	"cf_ignored_app":    true,
+}
+
+// metricFields converts a parsed ValueMetric, CounterEvent or ContainerMetric
+// to HEC metric fields: one "metric_name:<name>" key per measurement plus the
+// remaining event fields as dimensions. ok is false for other event types.
+// NaN and infinite values are skipped, a nil map is returned when no valid
+// measurement remains
+func metricFields(fields map[string]interface{}) (metric map[string]interface{}, ok bool) {
+	eventType, _ := fields["event_type"].(string)
+	measurements := map[string]interface{}{}
+	var consumed []string
+
+	switch eventType {
+	case "ValueMetric":
+		name := metricName(fields, fields["name"])
+		addMeasurement(measurements, name, fields["value"])
+		consumed = []string{"name", "value"}
+	case "CounterEvent":
+		name := metricName(fields, fields["name"])
+		addMeasurement(measurements, name, fields["total"])
+		addMeasurement(measurements, name+".delta", fields["delta"])
+		consumed = []string{"name", "total", "delta"}
+	case "ContainerMetric":
+		for _, field := range containerMeasurements {
+			addMeasurement(measurements, "container."+field, fields[field])
+		}
+		consumed = containerMeasurements
+	default:
+		return nil, false
+	}
+
+	if len(measurements) == 0 {
+		return nil, true
+	}
+
+	for k, v := range fields {
+		if metricIgnoredFields[k] {
+			continue
+		}
+		switch val := v.(type) {
+		case map[string]string:
+			// Flatten tags into dimensions
+			for tag, tagValue := range val {
+				if _, exists := fields[tag]; !exists {
+					measurements[tag] = tagValue
+				}
+			}
+		case map[string]interface{}, []interface{}:
+			continue
+		default:
+			measurements[k] = val
+		}
+	}
+	for _, k := range consumed {
+		delete(measurements, k)
+	}
+	return measurements, true
+}
+
+// metricName prefixes the metric with its origin, since components reuse
+// names such as memoryStats.numBytesAllocated
+func metricName(fields map[string]interface{}, name interface{}) string {
+	nameStr, _ := name.(string)
+	if origin, _ := fields["origin"].(string); origin != "" && !strings.HasPrefix(nameStr, origin+".") {
+		return origin + "." + nameStr
+	}
+	return nameStr
+}
+
+func addMeasurement(measurements map[string]interface{}, name string, value interface{}) {
+	switch v := value.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return
+		}
+	case uint64, int64, uint32, int32, int:
+	default:
+		// ValueMetric already replaced NaN and infinity with strings
+		return
+	}
+	measurements[metricNamePrefix+name] = value
+}
@@ -0,0 +1,152 @@
+package eventsink
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// MultilineConfig enables reassembly of multiline LogMessage events such as
+// stack traces. A line matching StartPattern starts a new event, any other
+// line is appended to the previous event of the same app instance and source
+type MultilineConfig struct {
+	StartPattern string
+	// MaxWait bounds how long the first line of an event is held back
+	MaxWait time.Duration
+	// MaxLines is the most lines joined into one event, 0 is unlimited
+	MaxLines int
+}
+
+type multilineKey struct {
+	appID          string
+	sourceType     string
+	sourceInstance string
+}
+
+type pendingLines struct {
+	first   *events.Envelope
+	lines   [][]byte
+	started time.Time
+}
+
+// multilineAggregator joins LogMessage envelopes per app id, source type and
+// source instance. It runs before events are queued so the lines of a stream
+// are seen in order, independent of the number of consumers
+type multilineAggregator struct {
+	start    *regexp.Regexp
+	maxWait  time.Duration
+	maxLines int
+	emit     func(*events.Envelope)
+	merged   utils.Counter
+
+	lock    sync.Mutex
+	pending map[multilineKey]*pendingLines
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newMultilineAggregator(start *regexp.Regexp, config *MultilineConfig, emit func(*events.Envelope), merged utils.Counter) *multilineAggregator {
+	return &multilineAggregator{
+		start:    start,
+		maxWait:  config.MaxWait,
+		maxLines: config.MaxLines,
+		emit:     emit,
+		merged:   merged,
+		pending:  make(map[multilineKey]*pendingLines),
+		done:     make(chan struct{}),
+	}
+}
+
+func (m *multilineAggregator) Open() {
+	m.wg.Add(1)
+	go m.flushLoop()
+}
+
+// Close emits all pending events
+func (m *multilineAggregator) Close() {
+	close(m.done)
+	m.wg.Wait()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key, p := range m.pending {
+		m.flush(key, p)
+	}
+}
+
+func (m *multilineAggregator) Add(msg *events.Envelope) {
+	logMessage := msg.GetLogMessage()
+	key := multilineKey{
+		appID:          logMessage.GetAppId(),
+		sourceType:     logMessage.GetSourceType(),
+		sourceInstance: logMessage.GetSourceInstance(),
+	}
+	line := logMessage.GetMessage()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	p, ok := m.pending[key]
+	if ok && !m.start.Match(line) {
+		p.lines = append(p.lines, line)
+		if m.maxLines > 0 && len(p.lines) >= m.maxLines {
+			m.flush(key, p)
+		}
+		return
+	}
+	if ok {
+		m.flush(key, p)
+	}
+	m.pending[key] = &pendingLines{
+		first:   msg,
+		lines:   [][]byte{line},
+		started: time.Now(),
+	}
+}
+
+func (m *multilineAggregator) flushLoop() {
+	defer m.wg.Done()
+
+	interval := m.maxWait / 2
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.lock.Lock()
+			for key, p := range m.pending {
+				if time.Since(p.started) >= m.maxWait {
+					m.flush(key, p)
+				}
+			}
+			m.lock.Unlock()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// flush must be called with the lock held
+func (m *multilineAggregator) flush(key multilineKey, p *pendingLines) {
+	delete(m.pending, key)
+	if len(p.lines) == 1 {
+		m.emit(p.first)
+		return
+	}
+
+	joined := *p.first
+	logMessage := *p.first.GetLogMessage()
+	logMessage.Message = bytes.Join(p.lines, []byte("\n"))
+	joined.LogMessage = &logMessage
+	m.merged.Add(len(p.lines) - 1)
+	m.emit(&joined)
+}
@@ -0,0 +1,126 @@
+package eventsink_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+var _ = Describe("Multiline", func() {
+	var (
+		writer  *testing.EventWriterMock
+		config  *eventsink.SplunkConfig
+		sink    *eventsink.Splunk
+		openErr error
+	)
+
+	logMessage := func(appID, instance, line string) *events.Envelope {
+		origin := "rep"
+		sourceType := "APP/PROC/WEB"
+		return &events.Envelope{
+			Origin:    &origin,
+			EventType: events.Envelope_LogMessage.Enum(),
+			LogMessage: &events.LogMessage{
+				Message:        []byte(line),
+				MessageType:    events.LogMessage_OUT.Enum(),
+				AppId:          &appID,
+				SourceType:     &sourceType,
+				SourceInstance: &instance,
+			},
+		}
+	}
+
+	messages := func() []interface{} {
+		var msgs []interface{}
+		for _, event := range writer.CapturedEvents() {
+			msgs = append(msgs, event["event"].(map[string]interface{})["msg"])
+		}
+		return msgs
+	}
+
+	BeforeEach(func() {
+		writer = &testing.EventWriterMock{}
+		config = &eventsink.SplunkConfig{
+			FlushInterval: time.Millisecond,
+			QueueSize:     100,
+			BatchSize:     1,
+			Retries:       1,
+			Logger:        lager.NewLogger("test"),
+			Multiline: &eventsink.MultilineConfig{
+				StartPattern: `^\S`,
+				MaxWait:      time.Minute,
+				MaxLines:     3,
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		sink = eventsink.NewSplunk([]eventwriter.Writer{writer, &testing.EventWriterMock{}}, config, &eventsink.ParseConfig{}, cache.NewNoCache())
+		sink.MergedLines = new(utils.IntCounter)
+		openErr = sink.Open()
+	})
+
+	It("joins continuation lines per app instance", func() {
+		Expect(openErr).ShouldNot(HaveOccurred())
+		sink.Write(logMessage("app1", "0", "java.lang.NullPointerException"))
+		sink.Write(logMessage("app1", "1", "started"))
+		sink.Write(logMessage("app1", "0", "\tat com.example.Foo.bar(Foo.java:10)"))
+		sink.Write(logMessage("app1", "0", "\tat com.example.Foo.main(Foo.java:5)"))
+		sink.Write(logMessage("app1", "0", "next"))
+		Expect(sink.Close()).To(Succeed())
+
+		Expect(messages()).To(ConsistOf(
+			"java.lang.NullPointerException\n\tat com.example.Foo.bar(Foo.java:10)\n\tat com.example.Foo.main(Foo.java:5)",
+			"started",
+			"next",
+		))
+		Expect(sink.MergedLines.Value()).To(Equal(uint64(2)))
+	})
+
+	It("limits the number of joined lines", func() {
+		Expect(openErr).ShouldNot(HaveOccurred())
+		sink.Write(logMessage("app1", "0", "Traceback (most recent call last):"))
+		for i := 0; i < 4; i++ {
+			sink.Write(logMessage("app1", "0", "  File \"app.py\""))
+		}
+		Expect(sink.Close()).To(Succeed())
+
+		Expect(messages()).To(ConsistOf(
+			"Traceback (most recent call last):\n  File \"app.py\"\n  File \"app.py\"",
+			"  File \"app.py\"\n  File \"app.py\"",
+		))
+	})
+
+	Context("when no continuation line arrives", func() {
+		BeforeEach(func() {
+			config.Multiline.MaxWait = 20 * time.Millisecond
+		})
+
+		It("flushes after the max wait", func() {
+			Expect(openErr).ShouldNot(HaveOccurred())
+			sink.Write(logMessage("app1", "0", "single line"))
+
+			Eventually(messages).Should(ConsistOf("single line"))
+			Expect(sink.Close()).To(Succeed())
+		})
+	})
+
+	Context("with an invalid start pattern", func() {
+		BeforeEach(func() {
+			config.Multiline.StartPattern = "("
+		})
+
+		It("fails to open", func() {
+			Expect(openErr).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,234 @@
+package eventsink
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/monitoring"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+)
+
+const (
+	RateLimitOverflowDrop   = "drop"
+	RateLimitOverflowSample = "sample"
+)
+
+// maxAppCounters bounds the per app dropped counters, which live as long as
+// the nozzle. Apps limited after that many are only counted in total
+const maxAppCounters = 100
+
+// RateLimitConfig holds token bucket limits in LogMessage events per second.
+// A rate of 0 disables the limit of that scope. A burst of 0 defaults to the
+// rate
+type RateLimitConfig struct {
+	AppRate    float64
+	AppBurst   float64
+	SpaceRate  float64
+	SpaceBurst float64
+	OrgRate    float64
+	OrgBurst   float64
+
+	// Overflow is either "drop" or "sample". When sampling, 1 in SampleRate
+	// events over the limit is still forwarded
+	Overflow   string
+	SampleRate int
+
+	// SummaryInterval is how often the limited apps are logged
+	SummaryInterval time.Duration
+}
+
+// Enabled reports whether any limit is set
+func (c *RateLimitConfig) Enabled() bool {
+	return c != nil && (c.AppRate > 0 || c.SpaceRate > 0 || c.OrgRate > 0)
+}
+
+type rateLimitStats struct {
+	overflow  uint64
+	dropped   uint64
+	sampled   uint64
+	limitedBy string
+}
+
+// RateLimiter enforces per app, space and org token buckets so a single
+// chatty app can not fill the consumer queue for every tenant
+type RateLimiter struct {
+	config   *RateLimitConfig
+	appCache cache.Cache
+
+	lock        sync.Mutex
+	buckets     map[string]*utils.TokenBucket
+	stats       map[string]*rateLimitStats
+	appCounters map[string]utils.Counter
+
+	DroppedEvents utils.Counter
+	SampledEvents utils.Counter
+}
+
+func NewRateLimiter(config *RateLimitConfig, appCache cache.Cache) *RateLimiter {
+	return &RateLimiter{
+		config:        config,
+		appCache:      appCache,
+		buckets:       make(map[string]*utils.TokenBucket),
+		stats:         make(map[string]*rateLimitStats),
+		appCounters:   make(map[string]utils.Counter),
+		DroppedEvents: monitoring.RegisterCounter("firehose.events.ratelimited.dropped.count", utils.UintType),
+		SampledEvents: monitoring.RegisterCounter("firehose.events.ratelimited.sampled.count", utils.UintType),
+	}
+}
+
+type rateLimitScope struct {
+	name  string
+	key   string
+	rate  float64
+	burst float64
+}
+
+// scopes returns the buckets which apply to the app. Space and org are only
+// looked up when a space or org limit is set, and only in memory so the
+// firehose reader never waits for the cloud controller. Apps not cached yet
+// are only limited by their own bucket
+func (r *RateLimiter) scopes(appID string) []rateLimitScope {
+	var scopes []rateLimitScope
+	if r.config.AppRate > 0 {
+		scopes = append(scopes, rateLimitScope{"app", "app:" + appID, r.config.AppRate, r.config.AppBurst})
+	}
+	if r.config.SpaceRate <= 0 && r.config.OrgRate <= 0 {
+		return scopes
+	}
+
+	app, ok := cache.GetCachedApp(r.appCache, appID)
+	if !ok || app == nil {
+		return scopes
+	}
+	if r.config.SpaceRate > 0 && app.SpaceGuid != "" {
+		scopes = append(scopes, rateLimitScope{"space", "space:" + app.SpaceGuid, r.config.SpaceRate, r.config.SpaceBurst})
+	}
+	if r.config.OrgRate > 0 && app.OrgGuid != "" {
+		scopes = append(scopes, rateLimitScope{"org", "org:" + app.OrgGuid, r.config.OrgRate, r.config.OrgBurst})
+	}
+	return scopes
+}
+
+// Allow reports whether a LogMessage of the app should be forwarded. A token
+// is only taken when every bucket of the app has one left, so an app limited
+// by its org does not also drain its own bucket
+func (r *RateLimiter) Allow(appID string) bool {
+	if appID == "" {
+		return true
+	}
+	scopes := r.scopes(appID)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	limitedBy := ""
+	buckets := make([]*utils.TokenBucket, 0, len(scopes))
+	for _, scope := range scopes {
+		bucket, ok := r.buckets[scope.key]
+		if !ok {
+			bucket = utils.NewTokenBucket(scope.rate, scope.burst)
+			r.buckets[scope.key] = bucket
+		}
+		if limitedBy == "" && bucket.Available() < 1 {
+			limitedBy = scope.name
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	if limitedBy == "" {
+		for _, bucket := range buckets {
+			bucket.Allow()
+		}
+		return true
+	}
+
+	stats, ok := r.stats[appID]
+	if !ok {
+		stats = &rateLimitStats{}
+		r.stats[appID] = stats
+	}
+	stats.limitedBy = limitedBy
+	stats.overflow++
+
+	if r.config.Overflow == RateLimitOverflowSample && r.config.SampleRate > 0 && (stats.overflow-1)%uint64(r.config.SampleRate) == 0 {
+		stats.sampled++
+		r.SampledEvents.Add(1)
+		return true
+	}
+
+	stats.dropped++
+	r.DroppedEvents.Add(1)
+	if ctr := r.appCounter(appID); ctr != nil {
+		ctr.Add(1)
+	}
+	return false
+}
+
+// appCounter must be called with the lock held. Counters are only registered
+// for apps which were actually limited, up to maxAppCounters
+func (r *RateLimiter) appCounter(appID string) utils.Counter {
+	ctr, ok := r.appCounters[appID]
+	if !ok && len(r.appCounters) < maxAppCounters {
+		ctr = monitoring.RegisterCounter("firehose.events.ratelimited.app."+appID+".dropped.count", utils.UintType)
+		r.appCounters[appID] = ctr
+	}
+	return ctr
+}
+
+// Prune forgets buckets which have refilled completely. Without a summary
+// nobody reads the stats of limited apps, so they are forgotten too
+func (r *RateLimiter) Prune() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for key, bucket := range r.buckets {
+		if bucket.Full() {
+			delete(r.buckets, key)
+		}
+	}
+	if r.config.SummaryInterval <= 0 {
+		r.stats = make(map[string]*rateLimitStats)
+	}
+}
+
+// Summary returns the apps limited since the last call, sorted by dropped
+// events
+func (r *RateLimiter) Summary() []lager.Data {
+	r.lock.Lock()
+	stats := r.stats
+	r.stats = make(map[string]*rateLimitStats)
+	r.lock.Unlock()
+
+	appIDs := make([]string, 0, len(stats))
+	for appID := range stats {
+		appIDs = append(appIDs, appID)
+	}
+	sort.Slice(appIDs, func(i, j int) bool {
+		a, b := stats[appIDs[i]], stats[appIDs[j]]
+		if a.dropped != b.dropped {
+			return a.dropped > b.dropped
+		}
+		return appIDs[i] < appIDs[j]
+	})
+
+	summary := make([]lager.Data, 0, len(appIDs))
+	for _, appID := range appIDs {
+		s := stats[appID]
+		data := lager.Data{
+			"app_id":     appID,
+			"dropped":    s.dropped,
+			"sampled":    s.sampled,
+			"limited_by": s.limitedBy,
+		}
+		if app, ok := cache.GetCachedApp(r.appCache, appID); ok && app != nil {
+			data["app_name"] = app.Name
+			data["space_name"] = app.SpaceName
+			data["org_name"] = app.OrgName
+		}
+		summary = append(summary, data)
+	}
+	return summary
+}
@@ -0,0 +1,169 @@
+package eventsink_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+var _ = Describe("RateLimiter", func() {
+	const (
+		app1 = "8463ec45-543c-4492-9ec6-f52707f7dd2b"
+		app2 = "a1b2c3d4-543c-4492-9ec6-f52707f7dd2b"
+		// slow enough that no token is refilled during a test
+		slowRate = 0.001
+	)
+
+	allowed := func(limiter *eventsink.RateLimiter, appID string, n int) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			if limiter.Allow(appID) {
+				count++
+			}
+		}
+		return count
+	}
+
+	It("limits each app to its own bucket", func() {
+		limiter := eventsink.NewRateLimiter(&eventsink.RateLimitConfig{
+			AppRate:  slowRate,
+			AppBurst: 3,
+			Overflow: eventsink.RateLimitOverflowDrop,
+		}, cache.NewNoCache())
+		limiter.DroppedEvents = new(utils.IntCounter)
+
+		Expect(allowed(limiter, app1, 10)).To(Equal(3))
+		Expect(allowed(limiter, app2, 10)).To(Equal(3))
+		Expect(limiter.DroppedEvents.Value()).To(Equal(uint64(14)))
+	})
+
+	It("never limits events without an app", func() {
+		limiter := eventsink.NewRateLimiter(&eventsink.RateLimitConfig{AppRate: slowRate}, cache.NewNoCache())
+		Expect(allowed(limiter, "", 10)).To(Equal(10))
+	})
+
+	It("limits apps sharing a space", func() {
+		limiter := eventsink.NewRateLimiter(&eventsink.RateLimitConfig{
+			AppRate:    slowRate,
+			AppBurst:   5,
+			SpaceRate:  slowRate,
+			SpaceBurst: 6,
+			Overflow:   eventsink.RateLimitOverflowDrop,
+		}, testing.NewMemoryCacheMock())
+
+		Expect(allowed(limiter, app1, 10)).To(Equal(5))
+		Expect(allowed(limiter, app2, 10)).To(Equal(1))
+
+		summary := limiter.Summary()
+		Expect(summary).To(HaveLen(2))
+		Expect(summary[0]["app_id"]).To(Equal(app2))
+		Expect(summary[0]["limited_by"]).To(Equal("space"))
+		Expect(summary[0]["dropped"]).To(Equal(uint64(9)))
+		Expect(summary[0]["space_name"]).To(Equal("testing-space"))
+		Expect(summary[1]["app_id"]).To(Equal(app1))
+		Expect(summary[1]["limited_by"]).To(Equal("app"))
+		Expect(summary[1]["dropped"]).To(Equal(uint64(5)))
+
+		Expect(limiter.Summary()).To(BeEmpty())
+	})
+
+	It("only limits apps by their space once they are cached", func() {
+		limiter := eventsink.NewRateLimiter(&eventsink.RateLimitConfig{
+			AppRate:    slowRate,
+			AppBurst:   5,
+			SpaceRate:  slowRate,
+			SpaceBurst: 6,
+			Overflow:   eventsink.RateLimitOverflowDrop,
+		}, cache.NewNoCache())
+
+		Expect(allowed(limiter, app1, 10)).To(Equal(5))
+		Expect(allowed(limiter, app2, 10)).To(Equal(5))
+	})
+
+	It("forgets limited apps without a summary", func() {
+		limiter := eventsink.NewRateLimiter(&eventsink.RateLimitConfig{
+			AppRate:  slowRate,
+			AppBurst: 1,
+			Overflow: eventsink.RateLimitOverflowDrop,
+		}, cache.NewNoCache())
+
+		Expect(allowed(limiter, app1, 3)).To(Equal(1))
+		limiter.Prune()
+		Expect(limiter.Summary()).To(BeEmpty())
+	})
+
+	It("samples events over the limit", func() {
+		limiter := eventsink.NewRateLimiter(&eventsink.RateLimitConfig{
+			AppRate:    slowRate,
+			AppBurst:   2,
+			Overflow:   eventsink.RateLimitOverflowSample,
+			SampleRate: 5,
+		}, cache.NewNoCache())
+		limiter.DroppedEvents = new(utils.IntCounter)
+		limiter.SampledEvents = new(utils.IntCounter)
+
+		Expect(allowed(limiter, app1, 22)).To(Equal(6))
+		Expect(limiter.SampledEvents.Value()).To(Equal(uint64(4)))
+		Expect(limiter.DroppedEvents.Value()).To(Equal(uint64(16)))
+
+		summary := limiter.Summary()
+		Expect(summary).To(HaveLen(1))
+		Expect(summary[0]["sampled"]).To(Equal(uint64(4)))
+	})
+
+	It("only limits log messages written to the sink", func() {
+		var (
+			origin    = "rep"
+			eventType = events.Envelope_LogMessage
+			appID     = app1
+		)
+		logMessage := &events.Envelope{
+			Origin:     &origin,
+			EventType:  &eventType,
+			LogMessage: &events.LogMessage{AppId: &appID},
+		}
+		otherType := events.Envelope_ContainerMetric
+		containerMetric := &events.Envelope{
+			Origin:          &origin,
+			EventType:       &otherType,
+			ContainerMetric: &events.ContainerMetric{ApplicationId: &appID},
+		}
+
+		config := &eventsink.SplunkConfig{
+			FlushInterval: time.Millisecond,
+			QueueSize:     100,
+			BatchSize:     1,
+			Retries:       1,
+			Logger:        lager.NewLogger("test"),
+			RateLimit: &eventsink.RateLimitConfig{
+				AppRate:  slowRate,
+				AppBurst: 1,
+				Overflow: eventsink.RateLimitOverflowDrop,
+			},
+		}
+		writer := &testing.EventWriterMock{}
+		sink := eventsink.NewSplunk([]eventwriter.Writer{writer, &testing.EventWriterMock{}}, config, &eventsink.ParseConfig{}, cache.NewNoCache())
+		Expect(sink.Open()).To(Succeed())
+
+		for i := 0; i < 3; i++ {
+			sink.Write(logMessage)
+			sink.Write(containerMetric)
+		}
+		Expect(sink.Close()).To(Succeed())
+
+		var sourcetypes []interface{}
+		for _, event := range writer.CapturedEvents() {
+			sourcetypes = append(sourcetypes, event["sourcetype"])
+		}
+		Expect(sourcetypes).To(ConsistOf("cf:logmessage", "cf:containermetric", "cf:containermetric", "cf:containermetric"))
+	})
+})
@@ -1,9 +1,19 @@
 package eventsink
 
-import "github.com/cloudfoundry/sonde-go/events"
+import (
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	"github.com/cloudfoundry/sonde-go/events"
+)
 
 type Sink interface {
 	Open() error
 	Close() error
 	Write(fields *events.Envelope) error
 }
+
+// EventWriter is implemented by sinks which take an event already parsed
+// from its envelope, so it is not parsed again. The event is annotated with
+// envelope data but not with app data
+type EventWriter interface {
+	WriteEvent(msg *events.Envelope, event *fevents.Event) error
+}
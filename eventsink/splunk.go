@@ -1,8 +1,10 @@
 package eventsink
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,6 +23,11 @@ import (
 
 const SPLUNK_HEC_FIELDS_SUPPORT_VERSION = "6.4"
 
+const defaultSpillReplayInterval = 5 * time.Second
+
+// rateLimitPruneInterval is how often idle rate limit buckets are forgotten
+const rateLimitPruneInterval = time.Minute
+
 type SplunkConfig struct {
 	FlushInterval           time.Duration
 	QueueSize               int // consumer queue buffer size
@@ -36,21 +43,75 @@ type SplunkConfig struct {
 	LoggingIndex            string
 	RefreshSplunkConnection bool
 	KeepAliveTimer          time.Duration
+
+	// NativeMetrics sends ValueMetric, CounterEvent and ContainerMetric in
+	// HEC metrics format, to MetricsIndex when set
+	NativeMetrics bool
+	MetricsIndex  string
+
+	// Routes assigns index, sourcetype, source and HEC token by rule
+	Routes *fevents.RoutingTable
+
+	// Redactor removes personal data and secrets from messages
+	Redactor *fevents.Redactor
+
+	// Optional reassembly of multiline LogMessage events
+	Multiline *MultilineConfig
+
+	// Optional per app, space and org limits on LogMessage events
+	RateLimit *RateLimitConfig
+
+	// Optional persistent queue which absorbs batches while HEC is unavailable
+	SpillQueueDir         string
+	SpillQueueMaxSize     int64 // bytes
+	SpillQueueSegmentSize int64 // bytes
+	SpillReplayInterval   time.Duration
 }
 
 type ParseConfig = fevents.Config
 
+// SplunkSettings are the settings which Reload can change while the sink is
+// running. They are taken from SplunkConfig when the sink is opened
+type SplunkSettings struct {
+	FlushInterval time.Duration
+	BatchSize     int
+	ExtraFields   map[string]string
+	Routes        *fevents.RoutingTable
+}
+
 type Splunk struct {
 	writers               []eventwriter.Writer
 	config                *SplunkConfig
 	parseConfig           *ParseConfig
 	appCache              cache.Cache
-	events                chan *events.Envelope
+	events                chan queuedEvent
 	wg                    sync.WaitGroup
 	eventCount            uint64
 	sentCountChan         chan uint64
 	FirehoseDroppedEvents utils.Counter
 	SplunkDroppedEvents   utils.Counter
+	RedactedEvents        utils.Counter
+
+	spillQueue          *DiskQueue
+	replayLock          sync.Mutex
+	nextReplay          time.Time
+	SpilledBatches      utils.Counter
+	ReplayedBatches     utils.Counter
+	SpillDroppedBatches utils.Counter
+	SpillCorruptBatches utils.Counter
+	closing             chan struct{}
+	backgroundWg        sync.WaitGroup
+
+	settingsLock sync.RWMutex
+	settings     *SplunkSettings
+
+	rateLimiter *RateLimiter
+	multiline   *multilineAggregator
+	MergedLines utils.Counter
+
+	// hecErr is the last delivery failure, cleared by the next success
+	hecLock sync.Mutex
+	hecErr  error
 
 	// cached IP
 	ip string
@@ -64,21 +125,79 @@ func NewSplunk(writers []eventwriter.Writer, config *SplunkConfig, parseConfig *
 		config:                config,
 		parseConfig:           parseConfig,
 		appCache:              appCache,
-		events:                make(chan *events.Envelope, config.QueueSize),
+		events:                make(chan queuedEvent, config.QueueSize),
 		ip:                    ip,
 		eventCount:            0,
 		sentCountChan:         make(chan uint64, 100),
 		FirehoseDroppedEvents: monitoring.RegisterCounter("firehose.events.dropped.count", utils.UintType),
 		SplunkDroppedEvents:   monitoring.RegisterCounter("splunk.events.dropped.count", utils.UintType),
+		RedactedEvents:        monitoring.RegisterCounter("firehose.events.redacted.count", utils.UintType),
+		MergedLines:           monitoring.RegisterCounter("firehose.events.multiline.merged.count", utils.UintType),
+		SpilledBatches:        monitoring.RegisterCounter("splunk.spill.batches.written.count", utils.UintType),
+		ReplayedBatches:       monitoring.RegisterCounter("splunk.spill.batches.replayed.count", utils.UintType),
+		SpillDroppedBatches:   monitoring.RegisterCounter("splunk.spill.batches.dropped.count", utils.UintType),
+		SpillCorruptBatches:   monitoring.RegisterCounter("splunk.spill.batches.corrupt.count", utils.UintType),
+		closing:               make(chan struct{}),
 	}
 	monitoring.RegisterFunc("nozzle.queue.percentage", func() interface{} {
 		return (float64(len(splunk.events)) / float64(splunk.config.QueueSize) * 100.0)
 	})
+	if config.RateLimit.Enabled() {
+		splunk.rateLimiter = NewRateLimiter(config.RateLimit, appCache)
+	}
+	monitoring.RegisterReadinessCheck("hec", splunk.hecHealth)
+	monitoring.RegisterReadinessCheck("queue", splunk.queueHealth)
 
 	return splunk
 }
 
 func (s *Splunk) Open() error {
+	s.Reload(&SplunkSettings{
+		FlushInterval: s.config.FlushInterval,
+		BatchSize:     s.config.BatchSize,
+		ExtraFields:   s.config.ExtraFields,
+		Routes:        s.config.Routes,
+	})
+
+	if s.config.Multiline != nil && s.config.Multiline.StartPattern != "" {
+		start, err := regexp.Compile(s.config.Multiline.StartPattern)
+		if err != nil {
+			return fmt.Errorf("invalid multiline start pattern: %s", err)
+		}
+		s.multiline = newMultilineAggregator(start, s.config.Multiline, s.enqueue, s.MergedLines)
+		s.multiline.Open()
+	}
+
+	if s.config.SpillQueueDir != "" {
+		queue, err := NewDiskQueue(&DiskQueueConfig{
+			Dir:         s.config.SpillQueueDir,
+			MaxSize:     s.config.SpillQueueMaxSize,
+			SegmentSize: s.config.SpillQueueSegmentSize,
+		})
+		if err != nil {
+			return err
+		}
+		s.spillQueue = queue
+		monitoring.RegisterFunc("splunk.spill.queue.size", func() interface{} {
+			return queue.Size()
+		})
+		monitoring.RegisterFunc("splunk.spill.queue.batches", func() interface{} {
+			return queue.Len()
+		})
+		if pending := queue.Len(); pending > 0 {
+			s.config.Logger.Info("Found spilled batches from a previous run", lager.Data{"batches": pending})
+		}
+	}
+
+	if s.rateLimiter != nil {
+		s.backgroundWg.Add(1)
+		go s.pruneRateLimits()
+	}
+	if s.rateLimiter != nil && s.config.RateLimit.SummaryInterval > 0 {
+		s.backgroundWg.Add(1)
+		go s.logRateLimited()
+	}
+
 	for _, client := range s.writers[:len(s.writers)-1] {
 		s.wg.Add(1)
 		go s.consume(client)
@@ -86,43 +205,59 @@ func (s *Splunk) Open() error {
 	return nil
 }
 
+// Reload replaces the settings used for events consumed from now on. Batches
+// in flight are not affected
+func (s *Splunk) Reload(settings *SplunkSettings) {
+	s.settingsLock.Lock()
+	s.settings = settings
+	s.settingsLock.Unlock()
+}
+
+func (s *Splunk) currentSettings() *SplunkSettings {
+	s.settingsLock.RLock()
+	defer s.settingsLock.RUnlock()
+	return s.settings
+}
+
 func (s *Splunk) Close() error {
+	if s.multiline != nil {
+		s.multiline.Close()
+	}
+
 	// Notify the consume loop to drain events and exit
 	close(s.events)
 	s.wg.Wait()
+
+	close(s.closing)
+	s.backgroundWg.Wait()
+
+	if s.spillQueue != nil {
+		// Whatever is still spilled stays on disk for the next run
+		return s.spillQueue.Close()
+	}
 	return nil
 }
 
-// parseEvent parses the event received from the doppler
-func (s *Splunk) parseEvent(msg *events.Envelope) map[string]interface{} {
-	eventType := msg.GetEventType()
-
-	var event *fevents.Event
-	switch eventType {
-	case events.Envelope_HttpStartStop:
-		event = fevents.HttpStartStop(msg)
-	case events.Envelope_LogMessage:
-		event = fevents.LogMessage(msg)
-	case events.Envelope_ValueMetric:
-		event = fevents.ValueMetric(msg)
-	case events.Envelope_CounterEvent:
-		event = fevents.CounterEvent(msg)
-	case events.Envelope_Error:
-		event = fevents.ErrorEvent(msg)
-	case events.Envelope_ContainerMetric:
-		event = fevents.ContainerMetric(msg)
-	case events.Envelope_HttpStart:
-		event = fevents.HttpStart(msg)
-	case events.Envelope_HttpStop:
-		event = fevents.HttpStop(msg)
+// queuedEvent is an envelope waiting for a worker, with its event when it
+// was parsed already
+type queuedEvent struct {
+	msg   *events.Envelope
+	event *fevents.Event
+}
 
-	default:
-		return nil
+// parseEvent parses the event received from the doppler, unless the router
+// parsed it already
+func (s *Splunk) parseEvent(queued queuedEvent) map[string]interface{} {
+	event := queued.event
+	if event == nil {
+		msg := queued.msg
+		if event = fevents.FromEnvelope(msg); event == nil {
+			return nil
+		}
+		event.AnnotateWithEnvelopeData(msg, s.parseConfig)
+		event.AnnotateWithCFMetaData()
 	}
 
-	event.AnnotateWithEnvelopeData(msg, s.parseConfig)
-	event.AnnotateWithCFMetaData()
-
 	if _, hasAppId := event.Fields["cf_app_id"]; hasAppId {
 		event.AnnotateWithAppData(s.appCache, s.parseConfig)
 	}
@@ -144,19 +279,44 @@ func (s *Splunk) parseEvent(msg *events.Envelope) map[string]interface{} {
 }
 
 func (s *Splunk) Write(fields *events.Envelope) error {
+	return s.WriteEvent(fields, nil)
+}
+
+// WriteEvent queues an envelope with its parsed event. Multiline log
+// messages are parsed again once their lines are joined
+func (s *Splunk) WriteEvent(fields *events.Envelope, event *fevents.Event) error {
+	if s.rateLimiter != nil && fields.GetEventType() == events.Envelope_LogMessage {
+		if !s.rateLimiter.Allow(fields.GetLogMessage().GetAppId()) {
+			return nil
+		}
+	}
+
+	if s.multiline != nil && fields.GetEventType() == events.Envelope_LogMessage {
+		s.multiline.Add(fields)
+		return nil
+	}
+
+	s.push(queuedEvent{msg: fields, event: event})
+	return nil
+}
+
+func (s *Splunk) enqueue(fields *events.Envelope) {
+	s.push(queuedEvent{msg: fields})
+}
+
+func (s *Splunk) push(queued queuedEvent) {
 	select {
-	case s.events <- fields:
+	case s.events <- queued:
 	default:
 		s.FirehoseDroppedEvents.Add(1)
 	}
-	return nil
 }
 
 func (s *Splunk) consume(writer eventwriter.Writer) {
 	defer s.wg.Done()
 
 	var batch []map[string]interface{}
-	timer := time.NewTimer(s.config.FlushInterval)
+	timer := time.NewTimer(s.currentSettings().FlushInterval)
 
 	// Flush takes place when 1) batch limit is reached. 2) flush window expires
 LOOP:
@@ -170,17 +330,21 @@ LOOP:
 
 			parsedEvent := s.parseEvent(event)
 			if parsedEvent != nil {
-				finalEvent := s.buildEvent(parsedEvent)
-				batch = append(batch, finalEvent)
-				if len(batch) >= s.config.BatchSize {
+				if finalEvent := s.buildEvent(parsedEvent); finalEvent != nil {
+					batch = append(batch, finalEvent)
+				}
+				if settings := s.currentSettings(); len(batch) >= settings.BatchSize {
 					batch = s.indexEvents(writer, batch)
-					timer.Reset(s.config.FlushInterval) // reset channel timer
+					// Under steady load the flush timer never fires
+					s.replay(writer)
+					timer.Reset(settings.FlushInterval) // reset channel timer
 				}
 			}
 
 		case <-timer.C:
 			batch = s.indexEvents(writer, batch)
-			timer.Reset(s.config.FlushInterval)
+			s.replay(writer)
+			timer.Reset(s.currentSettings().FlushInterval)
 		}
 
 	}
@@ -191,34 +355,155 @@ LOOP:
 // indexEvents indexes events to Splunk
 // return nil when successful which clears all outstanding events
 // return what the batch has if there is an error for next retry cycle
+// With indexer acknowledgement enabled, the writer only succeeds once Splunk
+// confirms the batch was indexed, so unacknowledged batches are retried too
 func (s *Splunk) indexEvents(writer eventwriter.Writer, batch []map[string]interface{}) []map[string]interface{} {
 	if len(batch) == 0 {
 		return batch
 	}
+
 	var err error
+	var sentCount uint64
 	for i := 0; i < s.config.Retries; i++ {
-		err, sentCount := writer.Write(batch)
+		err, sentCount = writer.Write(batch)
 		if err == nil {
-			if s.config.StatusMonitorInterval > time.Second*0 {
-				s.sentCountChan <- sentCount
-			}
+			s.setHecError(nil)
+			s.reportSent(sentCount)
 			return nil
 		}
+		// Only retry the events which were not delivered
+		var partial *eventwriter.PartialWriteError
+		if errors.As(err, &partial) {
+			s.reportSent(sentCount)
+			batch = partial.Failed
+		}
 		s.config.Logger.Error("Unable to talk to Splunk", err, lager.Data{"Retry attempt": i + 1})
 		time.Sleep(getRetryInterval(i))
 	}
+	if err != nil {
+		s.setHecError(err)
+	}
+
+	if s.spillQueue != nil {
+		s.spill(batch)
+		return nil
+	}
+
 	s.SplunkDroppedEvents.Add(len(batch))
 	s.config.Logger.Error("Finish retrying and dropping events", err, lager.Data{"events": len(batch)})
 	return nil
 }
 
+// spill persists a batch which could not be delivered to HEC. The batch is
+// dropped if the spill queue is full
+func (s *Splunk) spill(batch []map[string]interface{}) {
+	if err := s.spillQueue.Push(batch); err != nil {
+		s.SpillDroppedBatches.Add(1)
+		s.SplunkDroppedEvents.Add(len(batch))
+		s.config.Logger.Error("Unable to spill events to disk, dropping events", err, lager.Data{"events": len(batch)})
+		return
+	}
+	s.SpilledBatches.Add(1)
+}
+
+// replay drains the spill queue in order with the writer of a worker, when
+// no other worker is at it. After a failure the queue is left alone for the
+// replay interval
+func (s *Splunk) replay(writer eventwriter.Writer) {
+	if s.spillQueue == nil || s.spillQueue.Len() == 0 || !s.replayLock.TryLock() {
+		return
+	}
+	defer s.replayLock.Unlock()
+
+	if time.Now().Before(s.nextReplay) {
+		return
+	}
+
+	for {
+		batch, err := s.spillQueue.Peek()
+		if errors.Is(err, ErrCorruptBatch) {
+			s.SpillCorruptBatches.Add(1)
+			s.config.Logger.Error("Dropping spilled events which can not be read", err)
+			continue
+		}
+		if err != nil {
+			s.config.Logger.Error("Unable to read spilled events", err)
+			break
+		}
+		if batch == nil {
+			return
+		}
+
+		err, sentCount := writer.Write(batch)
+		var partial *eventwriter.PartialWriteError
+		if errors.As(err, &partial) {
+			// Spill the undelivered events again, so the delivered ones are
+			// not replayed twice
+			s.reportSent(sentCount)
+			s.spill(partial.Failed)
+		} else if err != nil {
+			s.setHecError(err)
+			s.config.Logger.Error("Unable to replay spilled events to Splunk", err, lager.Data{"pending_batches": s.spillQueue.Len()})
+			break
+		} else {
+			s.setHecError(nil)
+			s.reportSent(sentCount)
+			s.ReplayedBatches.Add(1)
+		}
+
+		if err := s.spillQueue.Pop(); err != nil {
+			s.config.Logger.Error("Unable to remove replayed events from spill queue", err)
+			break
+		}
+		if partial != nil {
+			s.setHecError(partial)
+			s.config.Logger.Error("Unable to replay some spilled events to Splunk", partial, lager.Data{"pending_batches": s.spillQueue.Len()})
+			break
+		}
+	}
+
+	interval := s.config.SpillReplayInterval
+	if interval <= 0 {
+		interval = defaultSpillReplayInterval
+	}
+	s.nextReplay = time.Now().Add(interval)
+}
+
+func (s *Splunk) reportSent(sentCount uint64) {
+	if s.config.StatusMonitorInterval > time.Second*0 {
+		s.sentCountChan <- sentCount
+	}
+}
+
 func (s *Splunk) buildEvent(fields map[string]interface{}) map[string]interface{} {
+	settings := s.currentSettings()
+
+	// Match before msg is expanded so field regexes see the raw message
+	var route *fevents.RoutingRule
+	if settings.Routes != nil {
+		route = settings.Routes.Route(fields)
+	}
+
 	if msg, ok := fields["msg"]; ok {
 		if msgStr, ok := msg.(string); ok && len(msgStr) > 0 {
 			fields["msg"] = utils.ToJson(msgStr)
 		}
 	}
 
+	if s.config.Redactor != nil {
+		if msg, ok := fields["msg"]; ok {
+			redacted, drop, changed := s.config.Redactor.Redact(msg)
+			if changed {
+				s.RedactedEvents.Add(1)
+			}
+			if drop {
+				delete(fields, "msg")
+			} else {
+				fields["msg"] = redacted
+			}
+		}
+	}
+
 	event := map[string]interface{}{}
 
 	var timestamp string
@@ -241,6 +526,44 @@ func (s *Splunk) buildEvent(fields map[string]interface{}) map[string]interface{
 		event["sourcetype"] = fmt.Sprintf("cf:%s", strings.ToLower(eventType))
 	}
 
+	var metric map[string]interface{}
+	isMetric := false
+	if s.config.NativeMetrics {
+		metric, isMetric = metricFields(fields)
+		if isMetric && metric == nil {
+			// Nothing left to index after dropping NaN and infinite values
+			return nil
+		}
+	}
+	if isMetric && s.config.MetricsIndex != "" {
+		event["index"] = s.config.MetricsIndex
+	}
+
+	// A routed index takes precedence over the app's SPLUNK_INDEX
+	if route != nil {
+		if route.Index != "" {
+			event["index"] = route.Index
+		}
+		if route.Sourcetype != "" {
+			event["sourcetype"] = route.Sourcetype
+		}
+		if route.Source != "" {
+			event["source"] = route.Source
+		}
+		if route.Token != "" {
+			event[eventwriter.TokenKey] = route.Token
+		}
+	}
+
+	if isMetric {
+		for k, v := range settings.ExtraFields {
+			metric[k] = v
+		}
+		event["event"] = "metric"
+		event["fields"] = metric
+		return event
+	}
+
 	extraFields := make(map[string]interface{})
 
 	if s.config.TraceLogging {
@@ -248,7 +571,7 @@ func (s *Splunk) buildEvent(fields map[string]interface{}) map[string]interface{
 		extraFields["firehose-subscription-id"] = s.config.SubscriptionID
 		extraFields["uuid"] = s.config.UUID
 	}
-	for k, v := range s.config.ExtraFields {
+	for k, v := range settings.ExtraFields {
 		extraFields[k] = v
 	}
 	event["fields"] = extraFields
@@ -321,6 +644,78 @@ func (s *Splunk) LogStatus() {
 	}
 }
 
+// pruneRateLimits keeps the rate limiter from remembering every app it ever
+// saw, independent of the summary
+func (s *Splunk) pruneRateLimits() {
+	defer s.backgroundWg.Done()
+
+	ticker := time.NewTicker(rateLimitPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.rateLimiter.Prune()
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// logRateLimited logs the apps limited during each summary interval
+func (s *Splunk) logRateLimited() {
+	defer s.backgroundWg.Done()
+
+	ticker := time.NewTicker(s.config.RateLimit.SummaryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			apps := s.rateLimiter.Summary()
+			if len(apps) == 0 {
+				continue
+			}
+			var dropped, sampled uint64
+			for _, app := range apps {
+				dropped += app["dropped"].(uint64)
+				sampled += app["sampled"].(uint64)
+			}
+			s.config.Logger.Info("Rate_Limited", lager.Data{
+				"interval": s.config.RateLimit.SummaryInterval.String(),
+				"dropped":  dropped,
+				"sampled":  sampled,
+				"apps":     apps,
+			})
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+func (s *Splunk) setHecError(err error) {
+	s.hecLock.Lock()
+	s.hecErr = err
+	s.hecLock.Unlock()
+}
+
+// hecHealth fails while the last attempt to deliver events to HEC failed
+func (s *Splunk) hecHealth() error {
+	s.hecLock.Lock()
+	defer s.hecLock.Unlock()
+	if s.hecErr != nil {
+		return fmt.Errorf("unable to deliver events to Splunk: %s", s.hecErr)
+	}
+	return nil
+}
+
+// queueHealth fails while the consumer queue is full and events are dropped
+func (s *Splunk) queueHealth() error {
+	percent := float64(len(s.events)) / float64(s.config.QueueSize) * 100.0
+	if percent > 99.9 {
+		return fmt.Errorf("consumer queue is full (%d events)", len(s.events))
+	}
+	return nil
+}
+
 func getRetryInterval(attempt int) time.Duration {
 	// algorithm taken from https://en.wikipedia.org/wiki/Exponential_backoff
 	timeInSec := 5 + (0.5 * (math.Exp2(float64(attempt)) - 1.0))
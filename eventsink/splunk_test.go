@@ -1,8 +1,11 @@
 package eventsink_test
 
 import (
+	"errors"
+	"math"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -11,8 +14,10 @@ import (
 	"code.cloudfoundry.org/lager/v3"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	fevents "github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
 	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
 
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
@@ -582,6 +587,107 @@ var _ = Describe("Splunk", func() {
 		})
 	})
 
+	Context("with native metrics", func() {
+		var captured func(*events.Envelope) []map[string]interface{}
+
+		BeforeEach(func() {
+			config.NativeMetrics = true
+			config.MetricsIndex = "cf_metrics"
+			origin = "gorouter"
+			job = "router"
+
+			captured = func(e *events.Envelope) []map[string]interface{} {
+				eventRouter.Route(e)
+				sink.Open()
+				sink.Write(memSink.Events[0])
+				sink.Close()
+				return mockClient.CapturedEvents()
+			}
+		})
+
+		It("sends ContainerMetric as a multi-measurement metric", func() {
+			applicationId := "8463ec45-543c-4492-9ec6-f52707f7dd2b"
+			instanceIndex := int32(1)
+			cpuPercentage := 1.5
+			memoryBytes := uint64(30011392)
+			eventType = events.Envelope_ContainerMetric
+			envelope.ContainerMetric = &events.ContainerMetric{
+				ApplicationId: &applicationId,
+				InstanceIndex: &instanceIndex,
+				CpuPercentage: &cpuPercentage,
+				MemoryBytes:   &memoryBytes,
+			}
+
+			captured := captured(envelope)
+			Expect(captured).To(HaveLen(1))
+			metric := captured[0]
+			Expect(metric["event"]).To(Equal("metric"))
+			Expect(metric["index"]).To(Equal("cf_metrics"))
+			Expect(metric["sourcetype"]).To(Equal("cf:containermetric"))
+
+			fields := metric["fields"].(map[string]interface{})
+			Expect(fields["metric_name:container.cpu_percentage"]).To(Equal(cpuPercentage))
+			Expect(fields["metric_name:container.memory_bytes"]).To(Equal(memoryBytes))
+			Expect(fields["metric_name:container.disk_bytes"]).To(Equal(uint64(0)))
+			Expect(fields["cf_app_id"]).To(Equal(applicationId))
+			Expect(fields["instance_index"]).To(Equal(instanceIndex))
+			Expect(fields["origin"]).To(Equal("gorouter"))
+			Expect(fields["env"]).To(Equal("dev"))
+			Expect(fields).NotTo(HaveKey("cpu_percentage"))
+		})
+
+		It("sends ValueMetric and CounterEvent prefixed with their origin", func() {
+			eventType = events.Envelope_ValueMetric
+			envelope.ValueMetric = &events.ValueMetric{
+				Name:  proto.String("latency"),
+				Value: proto.Float64(12.5),
+				Unit:  proto.String("ms"),
+			}
+
+			captured := captured(envelope)
+			Expect(captured).To(HaveLen(1))
+			fields := captured[0]["fields"].(map[string]interface{})
+			Expect(fields["metric_name:gorouter.latency"]).To(Equal(12.5))
+			Expect(fields["unit"]).To(Equal("ms"))
+			Expect(fields).NotTo(HaveKey("value"))
+		})
+
+		It("sends CounterEvent total and delta", func() {
+			eventType = events.Envelope_CounterEvent
+			envelope.CounterEvent = &events.CounterEvent{
+				Name:  proto.String("requests"),
+				Delta: proto.Uint64(2),
+				Total: proto.Uint64(42),
+			}
+
+			captured := captured(envelope)
+			Expect(captured).To(HaveLen(1))
+			fields := captured[0]["fields"].(map[string]interface{})
+			Expect(fields["metric_name:gorouter.requests"]).To(Equal(uint64(42)))
+			Expect(fields["metric_name:gorouter.requests.delta"]).To(Equal(uint64(2)))
+		})
+
+		It("drops metrics without a valid value", func() {
+			eventType = events.Envelope_ValueMetric
+			envelope.ValueMetric = &events.ValueMetric{
+				Name:  proto.String("ratio"),
+				Value: proto.Float64(math.NaN()),
+			}
+
+			Expect(captured(envelope)).To(BeEmpty())
+		})
+
+		It("keeps other events as JSON events", func() {
+			eventType = events.Envelope_Error
+			envelope.Error = &events.Error{Message: proto.String("boom")}
+
+			captured := captured(envelope)
+			Expect(captured).To(HaveLen(1))
+			Expect(captured[0]["event"]).To(HaveKeyWithValue("event_type", "Error"))
+			Expect(captured[0]).NotTo(HaveKey("index"))
+		})
+	})
+
 	It("Writer error, retry", func() {
 		eventType = events.Envelope_Error
 		eventRouter.Route(envelope)
@@ -597,6 +703,43 @@ var _ = Describe("Splunk", func() {
 		sink.Close()
 	})
 
+	It("retries only the events which were not delivered", func() {
+		var lock sync.Mutex
+		var batches [][]map[string]interface{}
+		writer := &testing.EventWriterMock{
+			PostBatchFn: func(events []map[string]interface{}) error {
+				lock.Lock()
+				defer lock.Unlock()
+				batches = append(batches, events)
+				if len(batches) == 1 {
+					return &eventwriter.PartialWriteError{Err: errors.New("forbidden"), Failed: events[1:]}
+				}
+				return nil
+			},
+		}
+		config.Retries = 2
+		config.BatchSize = 2
+		config.FlushInterval = time.Minute
+		sink = eventsink.NewSplunk([]eventwriter.Writer{writer, writer}, config, rconfig, cache.NewNoCache())
+
+		eventType = events.Envelope_Error
+		eventRouter.Route(envelope)
+		eventRouter.Route(envelope)
+
+		Ω(sink.Open()).ShouldNot(HaveOccurred())
+		sink.Write(memSink.Events[0])
+		sink.Write(memSink.Events[1])
+
+		Eventually(func() int {
+			lock.Lock()
+			defer lock.Unlock()
+			return len(batches)
+		}, 10*time.Second).Should(Equal(2))
+		Expect(batches[0]).To(HaveLen(2))
+		Expect(batches[1]).To(Equal(batches[0][1:]))
+		Ω(sink.Close()).ShouldNot(HaveOccurred())
+	})
+
 	It("Close no error", func() {
 		eventType = events.Envelope_Error
 		eventRouter.Route(envelope)
@@ -709,6 +852,200 @@ var _ = Describe("Splunk", func() {
 		Expect(event["origin"]).To(Equal("splunk_nozzle"))
 	})
 
+	It("applies routing rules", func() {
+		routes, err := fevents.ParseRoutingRules(`[{"match": {"event_type": "Error"}, "index": "errors", "sourcetype": "cf:errors", "source": "platform", "token": "errors-token"}]`)
+		Expect(err).ShouldNot(HaveOccurred())
+		config.Routes = routes
+
+		eventType = events.Envelope_Error
+		eventRouter.Route(envelope)
+		valueMetric := *envelope
+		valueMetric.EventType = events.Envelope_ValueMetric.Enum()
+		eventRouter.Route(&valueMetric)
+
+		sink.Open()
+		sink.Write(memSink.Events[0])
+		sink.Write(memSink.Events[1])
+
+		Eventually(func() []map[string]interface{} {
+			return mockClient.CapturedEvents()
+		}).Should(HaveLen(2))
+
+		routed := mockClient.CapturedEvents()[0]
+		Expect(routed["index"]).To(Equal("errors"))
+		Expect(routed["sourcetype"]).To(Equal("cf:errors"))
+		Expect(routed["source"]).To(Equal("platform"))
+		Expect(routed[eventwriter.TokenKey]).To(Equal("errors-token"))
+
+		unrouted := mockClient.CapturedEvents()[1]
+		Expect(unrouted).NotTo(HaveKey("index"))
+		Expect(unrouted["sourcetype"]).To(Equal("cf:valuemetric"))
+		Expect(unrouted).NotTo(HaveKey(eventwriter.TokenKey))
+	})
+
+	It("reloads settings while running", func() {
+		eventType = events.Envelope_Error
+		eventRouter.Route(envelope)
+		eventRouter.Route(envelope)
+
+		sink.Open()
+		sink.Write(memSink.Events[0])
+		Eventually(func() []map[string]interface{} {
+			return mockClient.CapturedEvents()
+		}).Should(HaveLen(1))
+		Expect(mockClient.CapturedEvents()[0]["fields"]).To(Equal(map[string]interface{}{"env": "dev", "test": "field"}))
+
+		routes, err := fevents.ParseRoutingRules(`[{"match": {"event_type": "Error"}, "index": "errors"}]`)
+		Expect(err).ShouldNot(HaveOccurred())
+		sink.Reload(&eventsink.SplunkSettings{
+			FlushInterval: time.Millisecond,
+			BatchSize:     1,
+			ExtraFields:   map[string]string{"env": "prod"},
+			Routes:        routes,
+		})
+
+		sink.Write(memSink.Events[1])
+		Eventually(func() []map[string]interface{} {
+			return mockClient.CapturedEvents()
+		}).Should(HaveLen(2))
+		reloaded := mockClient.CapturedEvents()[1]
+		Expect(reloaded["fields"]).To(Equal(map[string]interface{}{"env": "prod"}))
+		Expect(reloaded["index"]).To(Equal("errors"))
+	})
+
+	It("redacts log messages", func() {
+		redactor, err := fevents.ParseRedactor("email,jwt:drop-field", "", "")
+		Expect(err).ShouldNot(HaveOccurred())
+		config.Redactor = redactor
+		sink.RedactedEvents = new(utils.IntCounter)
+
+		appID := "8463ec45-543c-4492-9ec6-f52707f7dd2b"
+		messageType := events.LogMessage_OUT
+		eventType = events.Envelope_LogMessage
+		for _, msg := range []string{
+			`login from jane@example.com`,
+			`{"user": "jane@example.com", "token": "eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxMjMifQ.sig"}`,
+			`token eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxMjMifQ.sig`,
+		} {
+			logMessage := *envelope
+			logMessage.LogMessage = &events.LogMessage{
+				Message:     []byte(msg),
+				MessageType: &messageType,
+				Timestamp:   &timestampNano,
+				AppId:       &appID,
+			}
+			eventRouter.Route(&logMessage)
+		}
+
+		sink.Open()
+		for _, e := range memSink.Events {
+			sink.Write(e)
+		}
+
+		Eventually(func() []map[string]interface{} {
+			return mockClient.CapturedEvents()
+		}).Should(HaveLen(3))
+
+		captured := mockClient.CapturedEvents()
+		Expect(captured[0]["event"].(map[string]interface{})["msg"]).To(Equal("login from [REDACTED:email]"))
+		Expect(captured[1]["event"].(map[string]interface{})["msg"]).To(Equal(map[string]interface{}{"user": "[REDACTED:email]"}))
+		Expect(captured[2]["event"]).NotTo(HaveKey("msg"))
+		Expect(sink.RedactedEvents.Value()).To(Equal(uint64(3)))
+	})
+
+	Context("with a spill queue", func() {
+		var (
+			dir       string
+			lock      sync.Mutex
+			failing   bool
+			delivered []map[string]interface{}
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "spill")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			failing = true
+			delivered = nil
+			writer := &testing.EventWriterMock{
+				PostBatchFn: func(events []map[string]interface{}) error {
+					lock.Lock()
+					defer lock.Unlock()
+					if failing {
+						return errors.New("HEC unavailable")
+					}
+					delivered = append(delivered, events...)
+					return nil
+				},
+			}
+
+			config.Retries = 0
+			config.SpillQueueDir = dir
+			config.SpillQueueMaxSize = 1 << 20
+			config.SpillQueueSegmentSize = 1 << 10
+			config.SpillReplayInterval = 10 * time.Millisecond
+			sink = eventsink.NewSplunk([]eventwriter.Writer{writer, writer}, config, rconfig, cache.NewNoCache())
+			sink.SplunkDroppedEvents = new(utils.IntCounter)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("spills batches while HEC is down and replays them in order", func() {
+			eventType = events.Envelope_Error
+			for i := 0; i < 3; i++ {
+				eventRouter.Route(envelope)
+			}
+
+			Ω(sink.Open()).ShouldNot(HaveOccurred())
+			for _, e := range memSink.Events {
+				sink.Write(e)
+			}
+
+			Eventually(func() int {
+				files, _ := os.ReadDir(dir)
+				return len(files)
+			}).ShouldNot(BeZero())
+
+			lock.Lock()
+			failing = false
+			lock.Unlock()
+
+			Eventually(func() int {
+				lock.Lock()
+				defer lock.Unlock()
+				return len(delivered)
+			}).Should(Equal(3))
+			Expect(sink.SplunkDroppedEvents.Value()).To(Equal(uint64(0)))
+			Ω(sink.Close()).ShouldNot(HaveOccurred())
+		})
+
+		It("replays spilled batches while full batches keep the flush timer from firing", func() {
+			config.FlushInterval = time.Hour
+			sink.SpilledBatches = new(utils.IntCounter)
+			sink.ReplayedBatches = new(utils.IntCounter)
+			eventType = events.Envelope_Error
+			eventRouter.Route(envelope)
+
+			Ω(sink.Open()).ShouldNot(HaveOccurred())
+			sink.Write(memSink.Events[0])
+			Eventually(sink.SpilledBatches.Value).Should(Equal(uint64(1)))
+
+			lock.Lock()
+			failing = false
+			lock.Unlock()
+
+			// Every event fills a batch
+			Eventually(func() interface{} {
+				sink.Write(memSink.Events[0])
+				return sink.ReplayedBatches.Value()
+			}).Should(BeNumerically(">=", 1))
+			Ω(sink.Close()).ShouldNot(HaveOccurred())
+		})
+	})
+
 	It("std no error", func() {
 		s := &eventsink.Std{}
 		err := s.Open()
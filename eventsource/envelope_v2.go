@@ -0,0 +1,261 @@
+package eventsource
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+	"github.com/google/uuid"
+)
+
+// v2EnvelopeBatch is the JSON payload of a single server-sent event emitted
+// by the RLP gateway /v2/read endpoint
+type v2EnvelopeBatch struct {
+	Batch []*v2Envelope `json:"batch"`
+}
+
+type v2Envelope struct {
+	Timestamp  jsonInt64         `json:"timestamp"`
+	SourceId   string            `json:"source_id"`
+	InstanceId string            `json:"instance_id"`
+	Tags       map[string]string `json:"tags"`
+
+	Log     *v2Log     `json:"log"`
+	Counter *v2Counter `json:"counter"`
+	Gauge   *v2Gauge   `json:"gauge"`
+	Timer   *v2Timer   `json:"timer"`
+}
+
+type v2Log struct {
+	Payload []byte `json:"payload"`
+	Type    string `json:"type"`
+}
+
+type v2Counter struct {
+	Name  string     `json:"name"`
+	Delta jsonUint64 `json:"delta"`
+	Total jsonUint64 `json:"total"`
+}
+
+type v2Gauge struct {
+	Metrics map[string]*v2GaugeValue `json:"metrics"`
+}
+
+type v2GaugeValue struct {
+	Unit  string  `json:"unit"`
+	Value float64 `json:"value"`
+}
+
+type v2Timer struct {
+	Name  string    `json:"name"`
+	Start jsonInt64 `json:"start"`
+	Stop  jsonInt64 `json:"stop"`
+}
+
+// jsonInt64 and jsonUint64 accept both quoted and unquoted numbers since
+// the gateway encodes 64 bit integers as JSON strings
+type jsonInt64 int64
+
+func (i *jsonInt64) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = jsonInt64(v)
+	return nil
+}
+
+type jsonUint64 uint64
+
+func (i *jsonUint64) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseUint(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = jsonUint64(v)
+	return nil
+}
+
+// tags which are carried on v1 envelope fields instead of the tags map
+var v1EnvelopeTags = map[string]struct{}{
+	"origin":     {},
+	"deployment": {},
+	"job":        {},
+	"index":      {},
+	"ip":         {},
+}
+
+// containerMetricNames are the gauge names emitted by diego cells for
+// application containers. A gauge carrying all of them is a ContainerMetric
+var containerMetricNames = []string{"cpu", "memory", "disk", "memory_quota", "disk_quota"}
+
+// parseV2Batch decodes a server-sent event payload and converts every
+// envelope into its v1 representation
+func parseV2Batch(data []byte) ([]*events.Envelope, error) {
+	var batch v2EnvelopeBatch
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, err
+	}
+
+	var envelopes []*events.Envelope
+	for _, e := range batch.Batch {
+		envelopes = append(envelopes, toV1(e)...)
+	}
+	return envelopes, nil
+}
+
+// toV1 converts a v2 envelope into zero or more v1 envelopes. Gauges which
+// are not container metrics expand into one ValueMetric per measurement.
+// Event envelopes have no v1 equivalent and are dropped
+func toV1(e *v2Envelope) []*events.Envelope {
+	switch {
+	case e.Log != nil:
+		env := newV1Envelope(e, events.Envelope_LogMessage)
+		env.LogMessage = &events.LogMessage{
+			Message:        e.Log.Payload,
+			MessageType:    toV1MessageType(e.Log.Type),
+			Timestamp:      proto.Int64(int64(e.Timestamp)),
+			AppId:          proto.String(e.SourceId),
+			SourceType:     proto.String(e.Tags["source_type"]),
+			SourceInstance: proto.String(e.InstanceId),
+		}
+		return []*events.Envelope{env}
+
+	case e.Counter != nil:
+		env := newV1Envelope(e, events.Envelope_CounterEvent)
+		env.CounterEvent = &events.CounterEvent{
+			Name:  proto.String(e.Counter.Name),
+			Delta: proto.Uint64(uint64(e.Counter.Delta)),
+			Total: proto.Uint64(uint64(e.Counter.Total)),
+		}
+		return []*events.Envelope{env}
+
+	case e.Gauge != nil:
+		if isContainerMetric(e.Gauge) {
+			instanceIndex, _ := strconv.Atoi(e.InstanceId)
+			env := newV1Envelope(e, events.Envelope_ContainerMetric)
+			env.ContainerMetric = &events.ContainerMetric{
+				ApplicationId:    proto.String(e.SourceId),
+				InstanceIndex:    proto.Int32(int32(instanceIndex)),
+				CpuPercentage:    proto.Float64(e.Gauge.Metrics["cpu"].Value),
+				MemoryBytes:      proto.Uint64(uint64(e.Gauge.Metrics["memory"].Value)),
+				DiskBytes:        proto.Uint64(uint64(e.Gauge.Metrics["disk"].Value)),
+				MemoryBytesQuota: proto.Uint64(uint64(e.Gauge.Metrics["memory_quota"].Value)),
+				DiskBytesQuota:   proto.Uint64(uint64(e.Gauge.Metrics["disk_quota"].Value)),
+			}
+			return []*events.Envelope{env}
+		}
+
+		var envs []*events.Envelope
+		for name, metric := range e.Gauge.Metrics {
+			if metric == nil {
+				continue
+			}
+			env := newV1Envelope(e, events.Envelope_ValueMetric)
+			env.ValueMetric = &events.ValueMetric{
+				Name:  proto.String(name),
+				Value: proto.Float64(metric.Value),
+				Unit:  proto.String(metric.Unit),
+			}
+			envs = append(envs, env)
+		}
+		return envs
+
+	case e.Timer != nil && e.Timer.Name == "http":
+		env := newV1Envelope(e, events.Envelope_HttpStartStop)
+		instanceIndex, _ := strconv.Atoi(e.InstanceId)
+		statusCode, _ := strconv.Atoi(e.Tags["status_code"])
+		contentLength, _ := strconv.ParseInt(e.Tags["content_length"], 10, 64)
+		env.HttpStartStop = &events.HttpStartStop{
+			StartTimestamp: proto.Int64(int64(e.Timer.Start)),
+			StopTimestamp:  proto.Int64(int64(e.Timer.Stop)),
+			RequestId:      toV1UUID(e.Tags["request_id"]),
+			PeerType:       toV1PeerType(e.Tags["peer_type"]),
+			Method:         toV1Method(e.Tags["method"]),
+			Uri:            proto.String(e.Tags["uri"]),
+			RemoteAddress:  proto.String(e.Tags["remote_address"]),
+			UserAgent:      proto.String(e.Tags["user_agent"]),
+			StatusCode:     proto.Int32(int32(statusCode)),
+			ContentLength:  proto.Int64(contentLength),
+			ApplicationId:  toV1UUID(e.SourceId),
+			InstanceIndex:  proto.Int32(int32(instanceIndex)),
+			InstanceId:     proto.String(e.Tags["instance_id"]),
+		}
+		if forwarded := e.Tags["forwarded"]; forwarded != "" {
+			env.HttpStartStop.Forwarded = strings.Split(forwarded, "\n")
+		}
+		return []*events.Envelope{env}
+	}
+
+	return nil
+}
+
+func newV1Envelope(e *v2Envelope, eventType events.Envelope_EventType) *events.Envelope {
+	origin := e.Tags["origin"]
+	if origin == "" {
+		origin = e.SourceId
+	}
+
+	tags := make(map[string]string)
+	for k, v := range e.Tags {
+		if _, ok := v1EnvelopeTags[k]; !ok {
+			tags[k] = v
+		}
+	}
+
+	return &events.Envelope{
+		Origin:     proto.String(origin),
+		EventType:  eventType.Enum(),
+		Timestamp:  proto.Int64(int64(e.Timestamp)),
+		Deployment: proto.String(e.Tags["deployment"]),
+		Job:        proto.String(e.Tags["job"]),
+		Index:      proto.String(e.Tags["index"]),
+		Ip:         proto.String(e.Tags["ip"]),
+		Tags:       tags,
+	}
+}
+
+func isContainerMetric(g *v2Gauge) bool {
+	for _, name := range containerMetricNames {
+		if g.Metrics[name] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func toV1MessageType(t string) *events.LogMessage_MessageType {
+	if t == "ERR" {
+		return events.LogMessage_ERR.Enum()
+	}
+	return events.LogMessage_OUT.Enum()
+}
+
+func toV1PeerType(t string) *events.PeerType {
+	if strings.EqualFold(t, "client") {
+		return events.PeerType_Client.Enum()
+	}
+	return events.PeerType_Server.Enum()
+}
+
+func toV1Method(m string) *events.Method {
+	if v, ok := events.Method_value[strings.ToUpper(m)]; ok {
+		return events.Method(v).Enum()
+	}
+	return events.Method_GET.Enum()
+}
+
+// toV1UUID is the inverse of utils.FormatUUID
+func toV1UUID(id string) *events.UUID {
+	u, err := uuid.Parse(id)
+	if err != nil {
+		return nil
+	}
+	return &events.UUID{
+		Low:  proto.Uint64(binary.LittleEndian.Uint64(u[:8])),
+		High: proto.Uint64(binary.LittleEndian.Uint64(u[8:])),
+	}
+}
@@ -0,0 +1,219 @@
+package eventsource
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/monitoring"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+var ErrSourceClosed = errors.New("event source stopped reading after retries")
+
+// SourceFactory creates the source for every connection, so it can pick up
+// a changed endpoint
+type SourceFactory func() (Source, error)
+
+type ReconnectingConfig struct {
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Reconnecting reads from sources created by the factory. When a source
+// gives up it is replaced by a new one after a backoff, doubling with every
+// attempt without events up to MaxBackoff, until Close
+type Reconnecting struct {
+	factory SourceFactory
+	config  *ReconnectingConfig
+
+	lock      sync.Mutex
+	connected bool
+	lastErr   error
+	lastErrAt time.Time
+
+	reconnects utils.Counter
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func NewReconnecting(factory SourceFactory, config *ReconnectingConfig) *Reconnecting {
+	r := &Reconnecting{
+		factory:    factory,
+		config:     config,
+		closing:    make(chan struct{}),
+		reconnects: monitoring.RegisterCounter("firehose.connection.reconnects.count", utils.UintType),
+	}
+
+	monitoring.RegisterFunc("firehose.connection.connected", func() interface{} {
+		return r.Connected()
+	})
+	monitoring.RegisterFunc("firehose.connection.last.error", func() interface{} {
+		if err, _ := r.LastError(); err != nil {
+			return err.Error()
+		}
+		return ""
+	})
+	monitoring.RegisterFunc("firehose.connection.last.error.time", func() interface{} {
+		if _, at := r.LastError(); !at.IsZero() {
+			return at.Unix()
+		}
+		return int64(0)
+	})
+	return r
+}
+
+// Connected reports whether events arrived since the last connection or
+// read error
+func (r *Reconnecting) Connected() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.connected
+}
+
+// LastError returns the last connection or read error and when it occurred
+func (r *Reconnecting) LastError() (error, time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.lastErr, r.lastErrAt
+}
+
+func (r *Reconnecting) Open() error {
+	return nil
+}
+
+func (r *Reconnecting) Close() error {
+	r.closeOnce.Do(func() { close(r.closing) })
+	r.wg.Wait()
+	return nil
+}
+
+// Read streams the events of the current source. The events channel is only
+// closed after Close
+func (r *Reconnecting) Read() (<-chan *events.Envelope, <-chan error) {
+	eventChan := make(chan *events.Envelope)
+	errChan := make(chan error, 1)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(eventChan)
+
+		attempt := 0
+		for {
+			received, err := r.read(eventChan, errChan)
+			if r.isClosing() {
+				return
+			}
+			if received {
+				attempt = 0
+			}
+			r.sendError(errChan, err)
+
+			attempt++
+			select {
+			case <-time.After(r.backoff(attempt)):
+			case <-r.closing:
+				return
+			}
+			r.reconnects.Add(uint64(1))
+		}
+	}()
+
+	return eventChan, errChan
+}
+
+// read creates a source and forwards its events and errors until it gives
+// up. It reports whether any event was received since the source opened,
+// also when read errors followed
+func (r *Reconnecting) read(eventChan chan<- *events.Envelope, errChan chan error) (bool, error) {
+	source, err := r.factory()
+	if err != nil {
+		return false, err
+	}
+	defer source.Close()
+
+	if err := source.Open(); err != nil {
+		return false, err
+	}
+
+	// connected is reset by read errors, received only when the source is
+	// replaced, so a source which keeps delivering resets the backoff
+	received, connected := false, false
+	events, errs := source.Read()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return received, ErrSourceClosed
+			}
+			received = true
+			if !connected {
+				connected = true
+				r.setConnected(true)
+			}
+			select {
+			case eventChan <- event:
+			case <-r.closing:
+				return received, nil
+			}
+
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			// The next event marks the source connected again
+			connected = false
+			r.sendError(errChan, err)
+
+		case <-r.closing:
+			return received, nil
+		}
+	}
+}
+
+func (r *Reconnecting) setConnected(connected bool) {
+	r.lock.Lock()
+	r.connected = connected
+	r.lock.Unlock()
+}
+
+func (r *Reconnecting) sendError(errChan chan error, err error) {
+	r.lock.Lock()
+	r.connected = false
+	r.lastErr = err
+	r.lastErrAt = time.Now()
+	r.lock.Unlock()
+
+	select {
+	case errChan <- err:
+	case <-r.closing:
+	}
+}
+
+func (r *Reconnecting) isClosing() bool {
+	select {
+	case <-r.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff doubles with every attempt. Equal jitter spreads the reconnects of
+// nozzle instances which lost their connection together
+func (r *Reconnecting) backoff(attempt int) time.Duration {
+	backoff := r.config.MinBackoff
+	for i := 1; i < attempt && backoff < r.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > r.config.MaxBackoff {
+		backoff = r.config.MaxBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
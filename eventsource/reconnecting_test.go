@@ -0,0 +1,179 @@
+package eventsource_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsource"
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type stubSource struct {
+	events chan *events.Envelope
+	errs   chan error
+
+	lock   sync.Mutex
+	closed bool
+}
+
+func newStubSource() *stubSource {
+	return &stubSource{
+		events: make(chan *events.Envelope, 10),
+		errs:   make(chan error, 10),
+	}
+}
+
+func (s *stubSource) Open() error {
+	return nil
+}
+
+func (s *stubSource) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *stubSource) Closed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closed
+}
+
+func (s *stubSource) Read() (<-chan *events.Envelope, <-chan error) {
+	return s.events, s.errs
+}
+
+func newEnvelope(origin string) *events.Envelope {
+	return &events.Envelope{Origin: &origin}
+}
+
+var _ = Describe("Reconnecting", func() {
+	var (
+		lock       sync.Mutex
+		sources    []*stubSource
+		factoryErr error
+		source     *Reconnecting
+	)
+
+	factory := func() (Source, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if factoryErr != nil {
+			err := factoryErr
+			factoryErr = nil
+			return nil, err
+		}
+		s := newStubSource()
+		sources = append(sources, s)
+		return s, nil
+	}
+
+	created := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(sources)
+	}
+
+	current := func() *stubSource {
+		lock.Lock()
+		defer lock.Unlock()
+		return sources[len(sources)-1]
+	}
+
+	BeforeEach(func() {
+		sources = nil
+		factoryErr = nil
+		source = NewReconnecting(factory, &ReconnectingConfig{
+			MinBackoff: time.Millisecond,
+			MaxBackoff: 4 * time.Millisecond,
+		})
+	})
+
+	It("forwards events and errors of the source", func() {
+		eventChan, errs := source.Read()
+		Eventually(created).Should(Equal(1))
+		Expect(source.Connected()).To(BeFalse())
+
+		current().events <- newEnvelope("first")
+		var event *events.Envelope
+		Eventually(eventChan).Should(Receive(&event))
+		Expect(event.GetOrigin()).To(Equal("first"))
+		Eventually(source.Connected).Should(BeTrue())
+
+		readErr := errors.New("read failed")
+		current().errs <- readErr
+		Eventually(errs).Should(Receive(Equal(readErr)))
+		Expect(source.Connected()).To(BeFalse())
+		lastErr, at := source.LastError()
+		Expect(lastErr).To(Equal(readErr))
+		Expect(at).NotTo(BeZero())
+
+		Expect(source.Close()).To(Succeed())
+		Expect(current().Closed()).To(BeTrue())
+		Eventually(eventChan).Should(BeClosed())
+	})
+
+	It("replaces a source which gives up", func() {
+		eventChan, errs := source.Read()
+		Eventually(created).Should(Equal(1))
+		first := current()
+		close(first.events)
+
+		Eventually(errs).Should(Receive(Equal(ErrSourceClosed)))
+		Eventually(created).Should(Equal(2))
+		Expect(first.Closed()).To(BeTrue())
+
+		current().events <- newEnvelope("second")
+		Eventually(eventChan).Should(Receive())
+		Eventually(source.Connected).Should(BeTrue())
+
+		Expect(source.Close()).To(Succeed())
+	})
+
+	It("resets the backoff once a source delivered events", func() {
+		source = NewReconnecting(factory, &ReconnectingConfig{
+			MinBackoff: 20 * time.Millisecond,
+			MaxBackoff: 2 * time.Second,
+		})
+		eventChan, errs := source.Read()
+
+		// Every source giving up without events doubles the backoff
+		for i := 1; i <= 4; i++ {
+			Eventually(created).Should(Equal(i))
+			close(current().events)
+			Eventually(errs).Should(Receive(Equal(ErrSourceClosed)))
+		}
+		Eventually(created, time.Second).Should(Equal(5))
+
+		// A read error after events does not count as a source without events
+		current().events <- newEnvelope("fifth")
+		Eventually(eventChan).Should(Receive())
+		current().errs <- errors.New("read failed")
+		Eventually(errs).Should(Receive(MatchError("read failed")))
+		close(current().events)
+		Eventually(errs).Should(Receive(Equal(ErrSourceClosed)))
+
+		start := time.Now()
+		Eventually(created, time.Second, time.Millisecond).Should(Equal(6))
+		Expect(time.Since(start)).To(BeNumerically("<", 100*time.Millisecond))
+
+		Expect(source.Close()).To(Succeed())
+	})
+
+	It("retries when the source can not be created", func() {
+		factoryErr = errors.New("failed to get CF root info")
+		_, errs := source.Read()
+
+		var err error
+		Eventually(errs).Should(Receive(&err))
+		Expect(err).To(MatchError("failed to get CF root info"))
+		Eventually(created).Should(Equal(1))
+
+		Expect(source.Close()).To(Succeed())
+	})
+})
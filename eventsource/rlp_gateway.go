@@ -0,0 +1,267 @@
+package eventsource
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+const (
+	// rlpMaxRetries mirrors the number of consecutive reconnect attempts the
+	// noaa firehose consumer makes before giving up
+	rlpMaxRetries = 5
+	rlpMaxBackoff = time.Minute
+
+	// rlpMaxEventSize is the largest single server-sent event accepted
+	rlpMaxEventSize = 16 * 1024 * 1024
+)
+
+type RLPGatewayConfig struct {
+	KeepAlive time.Duration
+	SkipSSL   bool
+	Endpoint  string
+	ShardID   string
+}
+
+// RLPGateway reads v2 envelopes from the Reverse Log Proxy gateway and
+// converts them to v1 envelopes so the rest of the pipeline is unchanged
+type RLPGateway struct {
+	config      *RLPGatewayConfig
+	tokenClient TokenClient
+	httpClient  *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewRLPGateway(tokenClient TokenClient, config *RLPGatewayConfig) *RLPGateway {
+	tr := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: config.SkipSSL, MinVersion: tls.VersionTLS12},
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: config.KeepAlive,
+		}).DialContext,
+		ResponseHeaderTimeout: 30 * time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RLPGateway{
+		config:      config,
+		tokenClient: tokenClient,
+		httpClient:  &http.Client{Transport: tr},
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+func (r *RLPGateway) Open() error {
+	return nil
+}
+
+func (r *RLPGateway) Close() error {
+	r.cancel()
+	r.wg.Wait()
+	return nil
+}
+
+// Read streams envelopes from the gateway. Like the v1 firehose consumer, it
+// reconnects on failure and closes the events channel after rlpMaxRetries
+// consecutive failed attempts
+func (r *RLPGateway) Read() (<-chan *events.Envelope, <-chan error) {
+	eventChan := make(chan *events.Envelope)
+	errChan := make(chan error, 1)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(eventChan)
+
+		retries := 0
+		for {
+			received, err := r.stream(eventChan)
+			if r.ctx.Err() != nil {
+				return
+			}
+			if received {
+				retries = 0
+			}
+			if err != nil {
+				r.sendError(errChan, err)
+			}
+
+			retries++
+			if retries > rlpMaxRetries {
+				return
+			}
+
+			select {
+			case <-time.After(rlpBackoff(retries)):
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return eventChan, errChan
+}
+
+func (r *RLPGateway) sendError(errChan chan error, err error) {
+	select {
+	case errChan <- err:
+	case <-r.ctx.Done():
+	}
+}
+
+// stream opens one connection to the gateway and forwards envelopes until
+// the connection fails. It reports whether any envelope was received
+func (r *RLPGateway) stream(eventChan chan<- *events.Envelope) (bool, error) {
+	token, err := r.tokenClient.GetToken()
+	if err != nil {
+		return false, err
+	}
+	if token == "" {
+		return false, errors.New("failed to refresh token")
+	}
+
+	// The gateway sends heartbeats, a stream without lines for KeepAlive is
+	// stalled. The idle timer cancels the request to unblock the reader
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
+	idle := newIdleTimer(r.config.KeepAlive, cancel)
+	defer idle.stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.readURL(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("bearer %s", token))
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("Non-ok response code [%d] from RLP gateway: %s", resp.StatusCode, body)
+	}
+
+	received := false
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), rlpMaxEventSize)
+
+	var eventName string
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			// A blank line terminates a server-sent event
+			if eventName == "" && data.Len() > 0 {
+				envelopes, err := parseV2Batch(data.Bytes())
+				if err != nil {
+					return received, fmt.Errorf("failed to parse RLP gateway batch: %w", err)
+				}
+				// Waiting for the nozzle to take the envelopes is not idling
+				idle.stop()
+				for _, envelope := range envelopes {
+					select {
+					case eventChan <- envelope:
+						received = true
+					case <-r.ctx.Done():
+						return received, nil
+					}
+				}
+				idle.reset()
+			} else if eventName == "closing" {
+				return received, errors.New("RLP gateway closed the stream")
+			}
+			eventName = ""
+			data.Reset()
+			continue
+		}
+
+		idle.reset()
+		switch {
+		case bytes.HasPrefix(line, []byte("event:")):
+			eventName = strings.TrimSpace(string(line[len("event:"):]))
+		case bytes.HasPrefix(line, []byte("data:")):
+			data.Write(bytes.TrimSpace(line[len("data:"):]))
+		}
+	}
+
+	if idle.expired() {
+		return received, fmt.Errorf("no data from RLP gateway for %s", r.config.KeepAlive)
+	}
+	if err := scanner.Err(); err != nil {
+		return received, err
+	}
+	return received, io.ErrUnexpectedEOF
+}
+
+func (r *RLPGateway) readURL() string {
+	query := url.Values{}
+	query.Set("shard_id", r.config.ShardID)
+	// Envelope type selectors carry no value
+	selectors := "&log&counter&gauge&timer"
+	return fmt.Sprintf("%s/v2/read?%s%s", strings.TrimRight(r.config.Endpoint, "/"), query.Encode(), selectors)
+}
+
+// idleTimer calls its func when it is not reset within the timeout. A zero
+// timeout disables it
+type idleTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+	fired   atomic.Bool
+}
+
+func newIdleTimer(timeout time.Duration, onIdle func()) *idleTimer {
+	t := &idleTimer{timeout: timeout}
+	if timeout > 0 {
+		t.timer = time.AfterFunc(timeout, func() {
+			t.fired.Store(true)
+			onIdle()
+		})
+	}
+	return t
+}
+
+func (t *idleTimer) reset() {
+	if t.timer != nil {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+func (t *idleTimer) stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+func (t *idleTimer) expired() bool {
+	return t.fired.Load()
+}
+
+func rlpBackoff(attempt int) time.Duration {
+	backoff := time.Second << uint(attempt-1)
+	if backoff > rlpMaxBackoff || backoff <= 0 {
+		return rlpMaxBackoff
+	}
+	return backoff
+}
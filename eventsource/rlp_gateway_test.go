@@ -0,0 +1,192 @@
+package eventsource_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsource"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	"github.com/cloudfoundry/sonde-go/events"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RLPGateway", func() {
+	var (
+		server      *httptest.Server
+		requests    chan *http.Request
+		payload     string
+		tokenClient *testing.TokenClientMock
+		config      *RLPGatewayConfig
+	)
+
+	BeforeEach(func() {
+		requests = make(chan *http.Request, 10)
+		tokenClient = &testing.TokenClientMock{
+			GetTokenFn: func() (string, error) {
+				return "my-token", nil
+			},
+		}
+
+		logPayload := base64.StdEncoding.EncodeToString([]byte("hello world"))
+		payload = "event: heartbeat\ndata: 1580428783\n\n" +
+			`data: {"batch":[` +
+			`{"timestamp":"1467040874046121775","source_id":"f964a41c-76ac-42c1-b2ba-663da3ec22d5","instance_id":"1","tags":{"origin":"rep","deployment":"cf","job":"diego-cell","index":"idx","ip":"10.0.0.1","source_type":"APP/PROC/WEB"},"log":{"payload":"` + logPayload + `","type":"ERR"}},` +
+			`{"timestamp":"1467040874046121775","source_id":"gorouter","tags":{"origin":"gorouter"},"counter":{"name":"requests","delta":"2","total":"42"}},` +
+			`{"timestamp":"1467040874046121775","source_id":"f964a41c-76ac-42c1-b2ba-663da3ec22d5","instance_id":"3","tags":{"origin":"rep"},"gauge":{"metrics":{"cpu":{"unit":"percentage","value":1.5},"memory":{"unit":"bytes","value":10},"disk":{"unit":"bytes","value":20},"memory_quota":{"unit":"bytes","value":30},"disk_quota":{"unit":"bytes","value":40}}}},` +
+			`{"timestamp":"1467040874046121775","source_id":"gorouter","tags":{"origin":"gorouter"},"gauge":{"metrics":{"latency":{"unit":"ms","value":12}}}},` +
+			`{"timestamp":"1467040874046121775","source_id":"f964a41c-76ac-42c1-b2ba-663da3ec22d5","tags":{"origin":"gorouter","method":"POST","status_code":"404","uri":"/foo","peer_type":"Client","request_id":"a6bb4bc3-8a3e-4e4a-6c3c-4c4d8b1a1e2f"},"timer":{"name":"http","start":"100","stop":"2000100"}}` +
+			"]}\n\n"
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- r
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, payload)
+		}))
+
+		config = &RLPGatewayConfig{
+			KeepAlive: 25 * time.Second,
+			SkipSSL:   true,
+			Endpoint:  server.URL,
+			ShardID:   "testing",
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("reads and converts v2 envelopes", func() {
+		r := NewRLPGateway(tokenClient, config)
+		Expect(r.Open()).To(Succeed())
+		eventChan, _ := r.Read()
+
+		var received []*events.Envelope
+		for i := 0; i < 5; i++ {
+			var e *events.Envelope
+			Eventually(eventChan).Should(Receive(&e))
+			received = append(received, e)
+		}
+		Expect(r.Close()).To(Succeed())
+
+		req := <-requests
+		Expect(req.URL.Path).To(Equal("/v2/read"))
+		Expect(req.URL.Query().Get("shard_id")).To(Equal("testing"))
+		Expect(req.URL.Query()).To(HaveKey("log"))
+		Expect(req.Header.Get("Authorization")).To(Equal("bearer my-token"))
+
+		logMsg := received[0]
+		Expect(logMsg.GetEventType()).To(Equal(events.Envelope_LogMessage))
+		Expect(logMsg.GetOrigin()).To(Equal("rep"))
+		Expect(logMsg.GetDeployment()).To(Equal("cf"))
+		Expect(logMsg.GetJob()).To(Equal("diego-cell"))
+		Expect(logMsg.GetIp()).To(Equal("10.0.0.1"))
+		Expect(logMsg.GetTimestamp()).To(Equal(int64(1467040874046121775)))
+		Expect(string(logMsg.GetLogMessage().GetMessage())).To(Equal("hello world"))
+		Expect(logMsg.GetLogMessage().GetMessageType()).To(Equal(events.LogMessage_ERR))
+		Expect(logMsg.GetLogMessage().GetAppId()).To(Equal("f964a41c-76ac-42c1-b2ba-663da3ec22d5"))
+		Expect(logMsg.GetLogMessage().GetSourceType()).To(Equal("APP/PROC/WEB"))
+		Expect(logMsg.GetLogMessage().GetSourceInstance()).To(Equal("1"))
+		Expect(logMsg.GetTags()).To(Equal(map[string]string{"source_type": "APP/PROC/WEB"}))
+
+		counter := received[1]
+		Expect(counter.GetEventType()).To(Equal(events.Envelope_CounterEvent))
+		Expect(counter.GetCounterEvent().GetDelta()).To(Equal(uint64(2)))
+		Expect(counter.GetCounterEvent().GetTotal()).To(Equal(uint64(42)))
+
+		container := received[2]
+		Expect(container.GetEventType()).To(Equal(events.Envelope_ContainerMetric))
+		Expect(container.GetContainerMetric().GetInstanceIndex()).To(Equal(int32(3)))
+		Expect(container.GetContainerMetric().GetCpuPercentage()).To(Equal(1.5))
+		Expect(container.GetContainerMetric().GetDiskBytesQuota()).To(Equal(uint64(40)))
+
+		value := received[3]
+		Expect(value.GetEventType()).To(Equal(events.Envelope_ValueMetric))
+		Expect(value.GetValueMetric().GetName()).To(Equal("latency"))
+		Expect(value.GetValueMetric().GetUnit()).To(Equal("ms"))
+
+		http := received[4]
+		Expect(http.GetEventType()).To(Equal(events.Envelope_HttpStartStop))
+		Expect(http.GetHttpStartStop().GetMethod()).To(Equal(events.Method_POST))
+		Expect(http.GetHttpStartStop().GetStatusCode()).To(Equal(int32(404)))
+		Expect(http.GetHttpStartStop().GetPeerType()).To(Equal(events.PeerType_Client))
+		Expect(utils.FormatUUID(http.GetHttpStartStop().GetRequestId())).To(Equal("a6bb4bc3-8a3e-4e4a-6c3c-4c4d8b1a1e2f"))
+		Expect(utils.FormatUUID(http.GetHttpStartStop().GetApplicationId())).To(Equal("f964a41c-76ac-42c1-b2ba-663da3ec22d5"))
+	})
+
+	It("reports errors from the gateway", func() {
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+
+		r := NewRLPGateway(tokenClient, config)
+		_, errChan := r.Read()
+
+		var err error
+		Eventually(errChan).Should(Receive(&err))
+		Expect(err.Error()).To(ContainSubstring("403"))
+		Expect(r.Close()).To(Succeed())
+	})
+
+	It("reconnects when the stream stalls", func() {
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- r
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "event: heartbeat\ndata: 1580428783\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		})
+		config.KeepAlive = 100 * time.Millisecond
+
+		r := NewRLPGateway(tokenClient, config)
+		_, errChan := r.Read()
+
+		var err error
+		Eventually(errChan).Should(Receive(&err))
+		Expect(err).To(MatchError("no data from RLP gateway for 100ms"))
+		Eventually(requests, 5*time.Second).Should(HaveLen(2))
+		Expect(r.Close()).To(Succeed())
+	})
+
+	It("reconnects when the stream stalls after a batch", func() {
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- r
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, payload)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		})
+		config.KeepAlive = 100 * time.Millisecond
+
+		r := NewRLPGateway(tokenClient, config)
+		eventChan, errChan := r.Read()
+		for i := 0; i < 5; i++ {
+			Eventually(eventChan).Should(Receive())
+		}
+
+		var err error
+		Eventually(errChan).Should(Receive(&err))
+		Expect(err).To(MatchError("no data from RLP gateway for 100ms"))
+		Eventually(requests, 5*time.Second).Should(HaveLen(2))
+		Expect(r.Close()).To(Succeed())
+	})
+
+	It("returns error when no token", func() {
+		tokenClient.GetTokenFn = func() (string, error) {
+			return "", nil
+		}
+
+		r := NewRLPGateway(tokenClient, config)
+		_, errChan := r.Read()
+
+		var err error
+		Eventually(errChan).Should(Receive(&err))
+		Expect(err.Error()).To(Equal("failed to refresh token"))
+		Expect(r.Close()).To(Succeed())
+	})
+})
@@ -0,0 +1,22 @@
+package eventwriter
+
+import (
+	"bytes"
+	"compress/gzip"
+)
+
+// gzipBody compresses a HEC request body at the given gzip level
+func gzipBody(body []byte, level int) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,155 @@
+package eventwriter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+)
+
+const (
+	RoundRobin       = "round-robin"
+	LeastOutstanding = "least-outstanding"
+)
+
+type EndpointPoolConfig struct {
+	Hosts            []string
+	Strategy         string
+	FailureThreshold int           // consecutive failures before an endpoint is ejected
+	EjectDuration    time.Duration // how long an ejected endpoint is skipped
+}
+
+// Endpoint is a single HEC host tracked by an EndpointPool
+type Endpoint struct {
+	Host string
+
+	outstanding         int64
+	consecutiveFailures int
+	ejectedUntil        time.Time
+
+	Requests utils.Counter
+	Failures utils.Counter
+	Ejected  utils.Counter
+}
+
+// Outstanding returns the number of in-flight requests to the endpoint
+func (e *Endpoint) Outstanding() int64 {
+	return atomic.LoadInt64(&e.outstanding)
+}
+
+// MetricName returns the host in a form usable inside a metric name
+func (e *Endpoint) MetricName() string {
+	host := e.Host
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	return strings.NewReplacer(".", "_", ":", "_", "/", "_").Replace(host)
+}
+
+// EndpointPool distributes requests over several HEC endpoints and
+// passively tracks their health. Endpoints which fail FailureThreshold
+// times in a row (connection errors or 5xx) are ejected for EjectDuration
+type EndpointPool struct {
+	config    *EndpointPoolConfig
+	lock      sync.Mutex
+	endpoints []*Endpoint
+	next      int
+}
+
+func NewEndpointPool(config *EndpointPoolConfig) (*EndpointPool, error) {
+	if len(config.Hosts) == 0 {
+		return nil, fmt.Errorf("no HEC endpoints configured")
+	}
+	if config.Strategy != RoundRobin && config.Strategy != LeastOutstanding {
+		return nil, fmt.Errorf("unknown HEC load balancing strategy [%s] - valid strategies: %s, %s", config.Strategy, RoundRobin, LeastOutstanding)
+	}
+
+	pool := &EndpointPool{config: config}
+	for _, host := range config.Hosts {
+		pool.endpoints = append(pool.endpoints, &Endpoint{
+			Host:     host,
+			Requests: &utils.NopCounter{},
+			Failures: &utils.NopCounter{},
+			Ejected:  &utils.NopCounter{},
+		})
+	}
+	return pool, nil
+}
+
+// Endpoints returns all endpoints of the pool
+func (p *EndpointPool) Endpoints() []*Endpoint {
+	return p.endpoints
+}
+
+// Healthy reports whether the endpoint is currently in rotation
+func (p *EndpointPool) Healthy(e *Endpoint) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return !time.Now().Before(e.ejectedUntil)
+}
+
+// Acquire picks the endpoint for the next request. When every endpoint is
+// ejected, the one which is due back first is used rather than failing
+func (p *EndpointPool) Acquire() *Endpoint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+	chosen := -1
+	n := len(p.endpoints)
+	for i := 0; i < n; i++ {
+		idx := (p.next + i) % n
+		e := p.endpoints[idx]
+		if now.Before(e.ejectedUntil) {
+			continue
+		}
+		if chosen < 0 {
+			chosen = idx
+			if p.config.Strategy == RoundRobin {
+				break
+			}
+		} else if e.Outstanding() < p.endpoints[chosen].Outstanding() {
+			chosen = idx
+		}
+	}
+
+	if chosen < 0 {
+		for idx, e := range p.endpoints {
+			if chosen < 0 || e.ejectedUntil.Before(p.endpoints[chosen].ejectedUntil) {
+				chosen = idx
+			}
+		}
+	}
+
+	// continue after the chosen endpoint, so the one following an ejected
+	// endpoint doesn't get its share of requests too
+	p.next = (chosen + 1) % n
+	e := p.endpoints[chosen]
+	atomic.AddInt64(&e.outstanding, 1)
+	e.Requests.Add(1)
+	return e
+}
+
+// Release reports the outcome of a request made to an acquired endpoint
+func (p *EndpointPool) Release(e *Endpoint, failed bool) {
+	atomic.AddInt64(&e.outstanding, -1)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !failed {
+		e.consecutiveFailures = 0
+		return
+	}
+
+	e.Failures.Add(1)
+	e.consecutiveFailures++
+	if e.consecutiveFailures >= p.config.FailureThreshold {
+		e.consecutiveFailures = 0
+		e.ejectedUntil = time.Now().Add(p.config.EjectDuration)
+		e.Ejected.Add(1)
+	}
+}
@@ -0,0 +1,154 @@
+package eventwriter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+)
+
+var _ = Describe("EndpointPool", func() {
+	var config *EndpointPoolConfig
+
+	BeforeEach(func() {
+		config = &EndpointPoolConfig{
+			Hosts:            []string{"https://a:8088", "https://b:8088", "https://c:8088"},
+			Strategy:         RoundRobin,
+			FailureThreshold: 2,
+			EjectDuration:    time.Hour,
+		}
+	})
+
+	It("rejects an unknown strategy", func() {
+		config.Strategy = "random"
+		_, err := NewEndpointPool(config)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects an empty host list", func() {
+		config.Hosts = nil
+		_, err := NewEndpointPool(config)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("distributes requests round-robin", func() {
+		pool, err := NewEndpointPool(config)
+		Expect(err).NotTo(HaveOccurred())
+
+		var hosts []string
+		for i := 0; i < 6; i++ {
+			e := pool.Acquire()
+			hosts = append(hosts, e.Host)
+			pool.Release(e, false)
+		}
+		Expect(hosts).To(Equal([]string{
+			"https://a:8088", "https://b:8088", "https://c:8088",
+			"https://a:8088", "https://b:8088", "https://c:8088",
+		}))
+	})
+
+	It("prefers the endpoint with the fewest outstanding requests", func() {
+		config.Strategy = LeastOutstanding
+		pool, err := NewEndpointPool(config)
+		Expect(err).NotTo(HaveOccurred())
+
+		a := pool.Acquire()
+		b := pool.Acquire()
+		pool.Release(a, false)
+
+		next := pool.Acquire()
+		Expect(next.Host).NotTo(Equal(b.Host))
+		Expect(b.Outstanding()).To(Equal(int64(1)))
+	})
+
+	It("ejects endpoints after consecutive failures", func() {
+		pool, err := NewEndpointPool(config)
+		Expect(err).NotTo(HaveOccurred())
+		a := pool.Endpoints()[0]
+
+		for i := 0; i < 2; i++ {
+			e := pool.Acquire()
+			for e != a {
+				pool.Release(e, false)
+				e = pool.Acquire()
+			}
+			pool.Release(e, true)
+		}
+		Expect(pool.Healthy(a)).To(BeFalse())
+
+		for i := 0; i < 6; i++ {
+			e := pool.Acquire()
+			Expect(e.Host).NotTo(Equal(a.Host))
+			pool.Release(e, false)
+		}
+	})
+
+	It("spreads requests evenly over the endpoints left when one is ejected", func() {
+		config.FailureThreshold = 1
+		pool, err := NewEndpointPool(config)
+		Expect(err).NotTo(HaveOccurred())
+
+		a := pool.Acquire()
+		pool.Release(a, true)
+		Expect(pool.Healthy(a)).To(BeFalse())
+
+		counts := map[string]int{}
+		for i := 0; i < 6; i++ {
+			e := pool.Acquire()
+			counts[e.Host]++
+			pool.Release(e, false)
+		}
+		Expect(counts).To(Equal(map[string]int{"https://b:8088": 3, "https://c:8088": 3}))
+	})
+
+	It("falls back to an ejected endpoint when none is healthy", func() {
+		config.Hosts = []string{"https://a:8088"}
+		config.FailureThreshold = 1
+		pool, err := NewEndpointPool(config)
+		Expect(err).NotTo(HaveOccurred())
+
+		e := pool.Acquire()
+		pool.Release(e, true)
+		Expect(pool.Healthy(e)).To(BeFalse())
+		Expect(pool.Acquire()).To(Equal(e))
+	})
+
+	It("is used by the event writer and ejects failing hosts", func() {
+		var goodRequests, badRequests int
+		good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			goodRequests++
+		}))
+		defer good.Close()
+		bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			badRequests++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer bad.Close()
+
+		config.Hosts = []string{bad.URL, good.URL}
+		config.FailureThreshold = 1
+		pool, err := NewEndpointPool(config)
+		Expect(err).NotTo(HaveOccurred())
+
+		client := NewSplunkEvent(&SplunkConfig{
+			Token:     "token",
+			Endpoints: pool,
+			Logger:    lager.NewLogger("test"),
+		})
+		err, _ = client.Write([]map[string]interface{}{})
+		Expect(err).To(HaveOccurred())
+
+		for i := 0; i < 3; i++ {
+			err, _ = client.Write([]map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(badRequests).To(Equal(1))
+		Expect(goodRequests).To(Equal(3))
+	})
+})
@@ -8,15 +8,21 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"code.cloudfoundry.org/cfhttp"
 	"code.cloudfoundry.org/lager/v3"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	"github.com/google/uuid"
 )
 
 var keepAliveTimer = time.Now()
 
+// TokenKey is a top level event key which routes the event with a HEC token
+// other than SplunkConfig.Token. It is left out of the request body
+const TokenKey = "__hec_token"
+
 type SplunkConfig struct {
 	Host                    string
 	Token                   string
@@ -28,14 +34,54 @@ type SplunkConfig struct {
 	RefreshSplunkConnection bool
 	KeepAliveTimer          time.Duration
 
+	// Indexer acknowledgement. When enabled a batch is only reported as
+	// delivered once Splunk confirms it has been indexed
+	UseAck          bool
+	AckTimeout      time.Duration
+	AckPollInterval time.Duration
+
+	// Gzip request bodies, CompressionLevel is a compress/gzip level
+	Compress         bool
+	CompressionLevel int
+
+	// Endpoints, when set, load balances requests over several HEC hosts
+	// instead of using Host
+	Endpoints *EndpointPool
+
 	Logger lager.Logger
 }
 
+// acquireHost returns the HEC host for the next request together with a func
+// which must be called with the outcome of the request
+func (c *SplunkConfig) acquireHost() (string, func(failed bool)) {
+	if c.Endpoints == nil {
+		return c.Host, func(bool) {}
+	}
+	endpoint := c.Endpoints.Acquire()
+	return endpoint.Host, func(failed bool) {
+		c.Endpoints.Release(endpoint, failed)
+	}
+}
+
 type SplunkEvent struct {
 	httpClient     *http.Client
 	config         *SplunkConfig
+	channel        string
 	BodyBufferSize utils.Counter
 	SentEventCount utils.Counter
+
+	// CompressedBodyBufferSize counts bytes on the wire when compression is enabled
+	CompressedBodyBufferSize utils.Counter
+}
+
+type hecResponse struct {
+	Text  string `json:"text"`
+	Code  int    `json:"code"`
+	AckID *int64 `json:"ackId"`
+}
+
+type hecAckResponse struct {
+	Acks map[string]bool `json:"acks"`
 }
 
 func NewSplunkEvent(config *SplunkConfig) Writer {
@@ -48,15 +94,77 @@ func NewSplunkEvent(config *SplunkConfig) Writer {
 	return &SplunkEvent{
 		httpClient:     httpClient,
 		config:         config,
+		channel:        uuid.New().String(),
 		BodyBufferSize: &utils.NopCounter{},
 		SentEventCount: &utils.NopCounter{},
+
+		CompressedBodyBufferSize: &utils.NopCounter{},
 	}
 }
 
+// Write sends the batch in one request per HEC token. When only some of
+// the requests fail, a PartialWriteError holds the events to write again
+// and the count is the number of events delivered
 func (s *SplunkEvent) Write(events []map[string]interface{}) (error, uint64) {
+	var lastErr error
+	var sent uint64
+	var failed []map[string]interface{}
+	for _, group := range groupByToken(events) {
+		if err := s.write(group.token, group.events); err != nil {
+			lastErr = err
+			failed = append(failed, group.events...)
+			continue
+		}
+		sent += uint64(len(group.events))
+	}
+
+	switch {
+	case lastErr == nil:
+		return nil, sent
+	case sent == 0:
+		return lastErr, uint64(len(events))
+	}
+	return &PartialWriteError{Err: lastErr, Failed: failed}, sent
+}
+
+type tokenGroup struct {
+	token  string
+	events []map[string]interface{}
+}
+
+// groupByToken splits a batch by routed HEC token, keeping the order of
+// events within each token. Events without a routed token come first. The
+// events keep their token, so they are routed the same way when retried
+func groupByToken(events []map[string]interface{}) []*tokenGroup {
+	groups := []*tokenGroup{{}}
+	byToken := map[string]*tokenGroup{"": groups[0]}
+	for _, event := range events {
+		token, _ := event[TokenKey].(string)
+
+		group, ok := byToken[token]
+		if !ok {
+			group = &tokenGroup{token: token}
+			byToken[token] = group
+			groups = append(groups, group)
+		}
+		group.events = append(group.events, event)
+	}
+	if len(groups[0].events) == 0 && len(groups) > 1 {
+		groups = groups[1:]
+	}
+	return groups
+}
+
+func (s *SplunkEvent) write(token string, events []map[string]interface{}) error {
+	if token == "" {
+		token = s.config.Token
+	}
+
 	bodyBuffer := new(bytes.Buffer)
-	count := uint64(len(events))
 	for i, event := range events {
+		if _, ok := event[TokenKey]; ok {
+			event = withoutToken(event)
+		}
 		s.parseEvent(&event)
 
 		eventJson, err := json.Marshal(event)
@@ -76,52 +184,106 @@ func (s *SplunkEvent) Write(events []map[string]interface{}) (error, uint64) {
 
 	if s.config.Debug {
 		bodyString := bodyBuffer.String()
-		return s.dump(bodyString), count
+		return s.dump(bodyString)
 	} else {
 		bodyBytes := bodyBuffer.Bytes()
-		s.SentEventCount.Add(count)
-		return s.send(&bodyBytes), count
+		s.SentEventCount.Add(uint64(len(events)))
+		return s.send(&bodyBytes, token)
 	}
 }
 
+// withoutToken copies the event without its routed HEC token
+func withoutToken(event map[string]interface{}) map[string]interface{} {
+	stripped := make(map[string]interface{}, len(event))
+	for k, v := range event {
+		if k != TokenKey {
+			stripped[k] = v
+		}
+	}
+	return stripped
+}
+
 func (s *SplunkEvent) parseEvent(event *map[string]interface{}) error {
+	// Metric events carry "metric" instead of an event map, their fields
+	// are the measurements and must be kept
+	data, isEvent := (*event)["event"].(map[string]interface{})
+
 	if _, ok := (*event)["index"]; !ok {
-		if (*event)["event"].(map[string]interface{})["info_splunk_index"] != nil {
-			(*event)["index"] = (*event)["event"].(map[string]interface{})["info_splunk_index"]
+		if isEvent && data["info_splunk_index"] != nil {
+			(*event)["index"] = data["info_splunk_index"]
 		} else if s.config.Index != "" {
 			(*event)["index"] = s.config.Index
 		}
 	}
 
 	if len(s.config.Fields) > 0 {
-		(*event)["fields"] = s.config.Fields
+		if fields, ok := (*event)["fields"].(map[string]interface{}); ok && !isEvent {
+			for k, v := range s.config.Fields {
+				fields[k] = v
+			}
+		} else {
+			(*event)["fields"] = s.config.Fields
+		}
 	}
 
 	return nil
 }
 
-func (s *SplunkEvent) send(postBody *[]byte) error {
-	endpoint := fmt.Sprintf("%s/services/collector", s.config.Host)
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(*postBody))
+func (s *SplunkEvent) send(postBody *[]byte, token string) error {
+	host, release := s.config.acquireHost()
+	failed := false
+	defer func() { release(failed) }()
+
+	payload := *postBody
+	if s.config.Compress {
+		compressed, err := gzipBody(payload, s.config.CompressionLevel)
+		if err != nil {
+			return err
+		}
+		payload = compressed
+	}
+
+	endpoint := fmt.Sprintf("%s/services/collector", host)
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(payload))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Connection", "keep-alive")
-	req.Header.Set("Authorization", fmt.Sprintf("Splunk %s", s.config.Token))
+	req.Header.Set("Authorization", fmt.Sprintf("Splunk %s", token))
+	if s.config.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 	//Add app headers for HEC telemetry
 	req.Header.Set("__splunk_app_name", "Splunk Firehose Nozzle")
 	req.Header.Set("__splunk_app_version", s.config.Version)
+	if s.config.UseAck {
+		req.Header.Set("X-Splunk-Request-Channel", s.channel)
+	}
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
+		failed = true
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode > 299 {
+		failed = resp.StatusCode >= http.StatusInternalServerError
 		responseBody, _ := io.ReadAll(resp.Body)
 		return errors.New(fmt.Sprintf("Non-ok response code [%d] from splunk: %s", resp.StatusCode, responseBody))
+	} else if s.config.UseAck {
+		var hecResp hecResponse
+		if err := json.NewDecoder(resp.Body).Decode(&hecResp); err != nil {
+			return fmt.Errorf("unable to parse splunk response: %s", err)
+		}
+		if hecResp.AckID == nil {
+			return errors.New("no ackId in splunk response, indexer acknowledgement may not be enabled for the HEC token")
+		}
+		// Acks are tracked per indexer, so poll the host which took the batch
+		if err := s.waitForAck(host, token, *hecResp.AckID); err != nil {
+			return err
+		}
 	} else {
 		if s.config.RefreshSplunkConnection && time.Now().After(keepAliveTimer) {
 			if s.config.KeepAliveTimer > 0 {
@@ -135,10 +297,60 @@ func (s *SplunkEvent) send(postBody *[]byte) error {
 		}
 	}
 	s.BodyBufferSize.Add(uint64(len(*postBody)))
+	s.CompressedBodyBufferSize.Add(uint64(len(payload)))
 
 	return nil
 }
 
+// waitForAck polls the ack endpoint until the indexer confirms ackID or
+// AckTimeout expires
+func (s *SplunkEvent) waitForAck(host, token string, ackID int64) error {
+	endpoint := fmt.Sprintf("%s/services/collector/ack?channel=%s", host, s.channel)
+	body := []byte(fmt.Sprintf(`{"acks":[%d]}`, ackID))
+	deadline := time.Now().Add(s.config.AckTimeout)
+
+	for {
+		acked, err := s.queryAck(endpoint, token, body, ackID)
+		if err != nil {
+			return err
+		}
+		if acked {
+			return nil
+		}
+		if time.Now().Add(s.config.AckPollInterval).After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for indexer acknowledgement of ackId %d", s.config.AckTimeout, ackID)
+		}
+		time.Sleep(s.config.AckPollInterval)
+	}
+}
+
+func (s *SplunkEvent) queryAck(endpoint, token string, body []byte, ackID int64) (bool, error) {
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Splunk %s", token))
+	req.Header.Set("X-Splunk-Request-Channel", s.channel)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		responseBody, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("Non-ok response code [%d] from splunk ack endpoint: %s", resp.StatusCode, responseBody)
+	}
+
+	var ackResp hecAckResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ackResp); err != nil {
+		return false, fmt.Errorf("unable to parse splunk ack response: %s", err)
+	}
+	return ackResp.Acks[strconv.FormatInt(ackID, 10)], nil
+}
+
 // To dump the event on stdout instead of Splunk, in case of 'debug' mode
 func (s *SplunkEvent) dump(eventString string) error {
 	fmt.Println(string(eventString))
@@ -56,25 +56,43 @@ func (s *splunkMetric) Write(events []map[string]interface{}) (error, uint64) {
 }
 
 func (s *splunkMetric) send(postBody *[]byte) error {
-	endpoint := fmt.Sprintf("%s/services/collector", s.config.Host)
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(*postBody))
+	host, release := s.config.acquireHost()
+	failed := false
+	defer func() { release(failed) }()
+
+	payload := *postBody
+	if s.config.Compress {
+		compressed, err := gzipBody(payload, s.config.CompressionLevel)
+		if err != nil {
+			return err
+		}
+		payload = compressed
+	}
+
+	endpoint := fmt.Sprintf("%s/services/collector", host)
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(payload))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Connection", "keep-alive")
 	req.Header.Set("Authorization", fmt.Sprintf("Splunk %s", s.config.Token))
+	if s.config.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 	//Add app headers for HEC telemetry
 	req.Header.Set("__splunk_app_name", "Splunk Firehose Nozzle")
 	req.Header.Set("__splunk_app_version", s.config.Version)
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
+		failed = true
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode > 299 {
+		failed = resp.StatusCode >= http.StatusInternalServerError
 		responseBody, _ := ioutil.ReadAll(resp.Body)
 		return errors.New(fmt.Sprintf("Non-ok response code [%d] from splunk: %s", resp.StatusCode, responseBody))
 	} else {
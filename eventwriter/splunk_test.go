@@ -1,11 +1,15 @@
 package eventwriter_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"time"
 
 	"code.cloudfoundry.org/lager/v3"
 
@@ -13,6 +17,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
 )
 
 var _ = Describe("Splunk", func() {
@@ -88,6 +93,44 @@ var _ = Describe("Splunk", func() {
 			Expect(contentType).To(Equal("application/json"))
 		})
 
+		It("gzips the body when compression is enabled", func() {
+			config.Compress = true
+			config.CompressionLevel = gzip.BestSpeed
+
+			client := NewSplunkEvent(config).(*SplunkEvent)
+			raw := new(utils.IntCounter)
+			compressed := new(utils.IntCounter)
+			client.BodyBufferSize = raw
+			client.CompressedBodyBufferSize = compressed
+
+			events := []map[string]interface{}{
+				{"event": map[string]interface{}{"greeting": strings.Repeat("hello world ", 100)}},
+			}
+			err, _ := client.Write(events)
+
+			Expect(err).To(BeNil())
+			Expect(capturedRequest.Header.Get("Content-Encoding")).To(Equal("gzip"))
+
+			reader, err := gzip.NewReader(bytes.NewReader(capturedBody))
+			Expect(err).To(BeNil())
+			body, err := io.ReadAll(reader)
+			Expect(err).To(BeNil())
+			Expect(string(body)).To(ContainSubstring("hello world hello world"))
+
+			Expect(raw.Value()).To(Equal(uint64(len(body))))
+			Expect(compressed.Value()).To(Equal(uint64(len(capturedBody))))
+			Expect(compressed.Value()).To(BeNumerically("<", raw.Value()))
+		})
+
+		It("returns error for an invalid compression level", func() {
+			config.Compress = true
+			config.CompressionLevel = 42
+
+			client := NewSplunkEvent(config)
+			err, _ := client.Write([]map[string]interface{}{})
+			Expect(err).NotTo(BeNil())
+		})
+
 		It("sets app name to appName", func() {
 			appName := "Splunk Firehose Nozzle"
 
@@ -227,6 +270,20 @@ var _ = Describe("Splunk", func() {
 
 		})
 
+		It("keeps measurements of metric events", func() {
+			config.Index = "index_cf"
+			config.Fields = map[string]string{"foo": "bar"}
+
+			client := NewSplunkEvent(config)
+			events := []map[string]interface{}{
+				{"event": "metric", "fields": map[string]interface{}{"metric_name:cpu": 1.5}},
+			}
+			err, _ := client.Write(events)
+
+			Expect(err).To(BeNil())
+			Expect(string(capturedBody)).To(Equal(`{"event":"metric","fields":{"foo":"bar","metric_name:cpu":1.5},"index":"index_cf"}`))
+		})
+
 		It("Writes to correct endpoint", func() {
 			client := NewSplunkEvent(config)
 			events := []map[string]interface{}{}
@@ -246,6 +303,59 @@ var _ = Describe("Splunk", func() {
 		})
 	})
 
+	It("sends routed events with their own token", func() {
+		var auths, bodies []string
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			body, _ := io.ReadAll(request.Body)
+			auths = append(auths, request.Header.Get("Authorization"))
+			bodies = append(bodies, string(body))
+			writer.Write([]byte("{}"))
+		}))
+		defer server.Close()
+		config.Host = server.URL
+
+		client := NewSplunkEvent(config)
+		events := []map[string]interface{}{
+			{"event": map[string]interface{}{"n": 1}, TokenKey: "other-token"},
+			{"event": map[string]interface{}{"n": 2}},
+			{"event": map[string]interface{}{"n": 3}, TokenKey: "other-token"},
+		}
+		err, sentCount := client.Write(events)
+
+		Expect(err).To(BeNil())
+		Expect(sentCount).To(Equal(uint64(3)))
+		Expect(auths).To(Equal([]string{"Splunk token", "Splunk other-token"}))
+		Expect(bodies[0]).To(Equal(`{"event":{"n":2}}`))
+		Expect(bodies[1]).To(Equal("{\"event\":{\"n\":1}}\n\n{\"event\":{\"n\":3}}"))
+	})
+
+	It("reports the events of failed token groups", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if request.Header.Get("Authorization") == "Splunk other-token" {
+				writer.WriteHeader(http.StatusForbidden)
+				return
+			}
+			writer.Write([]byte("{}"))
+		}))
+		defer server.Close()
+		config.Host = server.URL
+
+		client := NewSplunkEvent(config)
+		events := []map[string]interface{}{
+			{"event": map[string]interface{}{"n": 1}, TokenKey: "other-token"},
+			{"event": map[string]interface{}{"n": 2}},
+		}
+		err, sentCount := client.Write(events)
+
+		var partial *PartialWriteError
+		Expect(errors.As(err, &partial)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("403"))
+		Expect(sentCount).To(Equal(uint64(1)))
+		Expect(partial.Failed).To(HaveLen(1))
+		Expect(partial.Failed[0]).To(HaveKeyWithValue(TokenKey, "other-token"))
+		Expect(events[0]).To(HaveKeyWithValue(TokenKey, "other-token"))
+	})
+
 	It("returns error on bad splunk host", func() {
 		config.Host = ":"
 		client := NewSplunkEvent(config)
@@ -280,4 +390,62 @@ var _ = Describe("Splunk", func() {
 		Expect(err).NotTo(BeNil())
 		Expect(err.Error()).To(ContainSubstring("foo"))
 	})
+
+	Context("indexer acknowledgement", func() {
+		var (
+			ackRequests int
+			ackAfter    int
+			channels    []string
+		)
+
+		BeforeEach(func() {
+			ackRequests = 0
+			ackAfter = 2
+			channels = nil
+			testServer = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				channels = append(channels, request.Header.Get("X-Splunk-Request-Channel"))
+				if request.URL.Path == "/services/collector/ack" {
+					Expect(request.URL.Query().Get("channel")).To(Equal(channels[0]))
+					body, _ := io.ReadAll(request.Body)
+					Expect(string(body)).To(Equal(`{"acks":[7]}`))
+					ackRequests++
+					fmt.Fprintf(writer, `{"acks":{"7":%t}}`, ackRequests >= ackAfter)
+					return
+				}
+				writer.Write([]byte(`{"text":"Success","code":0,"ackId":7}`))
+			}))
+
+			config.Host = testServer.URL
+			config.UseAck = true
+			config.AckTimeout = time.Second
+			config.AckPollInterval = 10 * time.Millisecond
+		})
+
+		AfterEach(func() {
+			testServer.Close()
+		})
+
+		It("waits until the batch is acknowledged", func() {
+			client := NewSplunkEvent(config)
+			err, _ := client.Write([]map[string]interface{}{})
+
+			Expect(err).To(BeNil())
+			Expect(ackRequests).To(Equal(2))
+			Expect(channels[0]).NotTo(BeEmpty())
+			for _, channel := range channels {
+				Expect(channel).To(Equal(channels[0]))
+			}
+		})
+
+		It("returns error when acknowledgement times out", func() {
+			ackAfter = 1000
+			config.AckTimeout = 50 * time.Millisecond
+
+			client := NewSplunkEvent(config)
+			err, _ := client.Write([]map[string]interface{}{})
+
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("waiting for indexer acknowledgement"))
+		})
+	})
 })
@@ -3,3 +3,18 @@ package eventwriter
 type Writer interface {
 	Write([]map[string]interface{}) (error, uint64)
 }
+
+// PartialWriteError is returned by Write when only some events of the batch
+// were delivered. Only the Failed events need to be written again
+type PartialWriteError struct {
+	Err    error
+	Failed []map[string]interface{}
+}
+
+func (e *PartialWriteError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PartialWriteError) Unwrap() error {
+	return e.Err
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"code.cloudfoundry.org/lager/v3"
 	"flag"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"os"
 	"os/signal"
@@ -21,6 +22,16 @@ var (
 
 func main() {
 	lagerflags.AddFlags(flag.CommandLine)
+	config := splunknozzle.NewConfigFromCmdFlags(version, branch, commit, buildos)
+	if err := config.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		os.Exit(1)
+	}
+	if config.Command == splunknozzle.CommandValidateConfig {
+		fmt.Println("Configuration is valid")
+		return
+	}
+
 	logger := lager.NewLogger("splunk-nozzle-logger")
 	logger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.INFO))
 	logrus.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true}) // disable the `time` field, it is already included in the cf logging
@@ -28,8 +39,13 @@ func main() {
 
 	shutdownChan := make(chan os.Signal, 2)
 	signal.Notify(shutdownChan, syscall.SIGINT, syscall.SIGTERM)
+	reloadChan := make(chan os.Signal, 1)
+	if config.ConfigFile != "" {
+		signal.Notify(reloadChan, syscall.SIGHUP)
+	} else {
+		logger.Info("No config file given, configuration reload on SIGHUP is unavailable")
+	}
 
-	config := splunknozzle.NewConfigFromCmdFlags(version, branch, commit, buildos)
 	if config.MemoryBallastSize > 0 {
 		ballast := make([]byte, config.MemoryBallastSize<<20)
 		_ = ballast
@@ -40,7 +56,7 @@ func main() {
 	}
 
 	splunkNozzle := splunknozzle.NewSplunkFirehoseNozzle(config, logger)
-	err := splunkNozzle.Run(shutdownChan)
+	err := splunkNozzle.Run(shutdownChan, reloadChan)
 	if err != nil {
 		logger.Error("Failed to run splunk-firehose-nozzle", err)
 	}
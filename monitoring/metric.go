@@ -25,6 +25,11 @@ type Metrics struct {
 	writer                    eventwriter.Writer
 	selectedMonitoringMetrics *utils.Set
 	tickerMutex               sync.Mutex
+
+	// Everything registered, so the selection can be changed at runtime
+	lock        sync.Mutex
+	allFuncs    map[string]MonitorFunc
+	allCounters map[string][]utils.Counter
 }
 
 func NewMetricsMonitor(logger lager.Logger, interval time.Duration, writer eventwriter.Writer, filter string) Monitor {
@@ -39,27 +44,65 @@ func NewMetricsMonitor(logger lager.Logger, interval time.Duration, writer event
 		interval:                  interval,
 		writer:                    writer,
 		selectedMonitoringMetrics: setValuesForSet(filter),
+		allFuncs:                  make(map[string]MonitorFunc),
+		allCounters:               make(map[string][]utils.Counter),
 	}
 	return monitor.(*Metrics)
 }
 
+// SetSelectedMetrics changes which of the registered metrics are sent to
+// Splunk, if the metrics monitor is running
+func SetSelectedMetrics(filter string) {
+	if m, ok := monitor.(*Metrics); ok {
+		m.SetSelectedMetrics(filter)
+	}
+}
+
+func (m *Metrics) SetSelectedMetrics(filter string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.selectedMonitoringMetrics = setValuesForSet(filter)
+	m.CallerFuncs = make(map[string]MonitorFunc)
+	for id, mFunc := range m.allFuncs {
+		if m.selectedMonitoringMetrics.Contains(id) {
+			m.CallerFuncs[id] = mFunc
+		}
+	}
+	m.Counters = make(map[string][]utils.Counter)
+	for id, counters := range m.allCounters {
+		if m.selectedMonitoringMetrics.Contains(id) {
+			m.Counters[id] = counters
+		}
+	}
+}
+
 func (m *Metrics) RegisterFunc(id string, mFunc MonitorFunc) {
+	if m.interval <= 0*time.Second {
+		return
+	}
 
-	if m.selectedMonitoringMetrics.Contains(id) && m.interval > 0*time.Second {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.allFuncs[id] = mFunc
+	if m.selectedMonitoringMetrics.Contains(id) {
 		m.CallerFuncs[id] = mFunc
 	}
 }
 
 func (m *Metrics) RegisterCounter(id string, varType utils.CounterType) utils.Counter {
+	if m.interval <= 0*time.Second || varType != utils.UintType {
+		return &utils.NopCounter{}
+	}
 
-	if m.selectedMonitoringMetrics.Contains(id) && m.interval > 0*time.Second {
-		if varType == utils.UintType {
-			ctr := new(utils.IntCounter)
-			m.Counters[id] = append(m.Counters[id], ctr)
-			return ctr
-		}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	ctr := new(utils.IntCounter)
+	m.allCounters[id] = append(m.allCounters[id], ctr)
+	if m.selectedMonitoringMetrics.Contains(id) {
+		m.Counters[id] = m.allCounters[id]
 	}
-	return &utils.NopCounter{}
+	return ctr
 }
 
 func (m *Metrics) extractFunc(metricEvent map[string]interface{}) {
@@ -88,12 +131,14 @@ func (m *Metrics) Start() {
 		m.ticker = ticker
 		m.tickerMutex.Unlock()
 
-		metricEvent := make(map[string]interface{})
 		for {
 			select {
 			case <-ticker.C:
+				metricEvent := make(map[string]interface{})
+				m.lock.Lock()
 				m.extractFunc(metricEvent)
 				m.extractCounter(metricEvent)
+				m.lock.Unlock()
 				finalMetricEvent := prepareBatch(metricEvent)
 				events := []map[string]interface{}{
 					finalMetricEvent,
@@ -69,6 +69,21 @@ var _ = Describe("Monitoring", func() {
 
 	})
 
+	It("changes the selected metrics", func() {
+		metrics := monitor.(*Metrics)
+		monitor.RegisterCounter("c", utils.UintType)
+
+		SetSelectedMetrics("a,c")
+		Expect(metrics.CallerFuncs).To(HaveKey("a"))
+		Expect(metrics.Counters).To(HaveKey("c"))
+		Expect(metrics.Counters).NotTo(HaveKey("b"))
+
+		SetSelectedMetrics("b")
+		Expect(metrics.CallerFuncs).To(BeEmpty())
+		Expect(metrics.Counters).To(HaveKey("b"))
+		Expect(metrics.Counters).NotTo(HaveKey("c"))
+	})
+
 })
 
 var _ = Describe("Parsing of Selected Metrics", func() {
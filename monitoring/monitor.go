@@ -19,8 +19,9 @@ var (
 
 func RegisterFunc(id string, callerFunc MonitorFunc) {
 	monitor.RegisterFunc(id, callerFunc)
+	registry.trackFunc(id, callerFunc)
 }
 
 func RegisterCounter(id string, varType utils.CounterType) utils.Counter {
-	return monitor.RegisterCounter(id, varType)
+	return registry.trackCounter(id, varType, monitor.RegisterCounter(id, varType))
 }
@@ -0,0 +1,215 @@
+package monitoring
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+)
+
+// HealthCheck returns nil when the checked component is healthy
+type HealthCheck func() error
+
+// Registry keeps every registered counter and func for the HTTP exporter,
+// independent of SelectedMonitoringMetrics and StatusMonitorInterval, and
+// the health checks behind /healthz and /readyz
+type Registry struct {
+	lock      sync.Mutex
+	export    bool
+	counters  map[string][]utils.Counter
+	funcs     map[string]MonitorFunc
+	liveness  map[string]HealthCheck
+	readiness map[string]HealthCheck
+}
+
+var registry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:  make(map[string][]utils.Counter),
+		funcs:     make(map[string]MonitorFunc),
+		liveness:  make(map[string]HealthCheck),
+		readiness: make(map[string]HealthCheck),
+	}
+}
+
+// EnableExport makes counters and funcs registered from now on available to
+// the HTTP exporter
+func EnableExport() {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.export = true
+}
+
+// RegisterLivenessCheck adds a check to both /healthz and /readyz
+func RegisterLivenessCheck(name string, check HealthCheck) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.liveness[name] = check
+}
+
+// RegisterReadinessCheck adds a check to /readyz
+func RegisterReadinessCheck(name string, check HealthCheck) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.readiness[name] = check
+}
+
+// trackCounter records the counter returned by the monitor. A NopCounter is
+// swapped for a real counter so unselected metrics are still exported
+func (r *Registry) trackCounter(id string, varType utils.CounterType, ctr utils.Counter) utils.Counter {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.export {
+		return ctr
+	}
+	if _, nop := ctr.(*utils.NopCounter); nop {
+		if varType != utils.UintType {
+			return ctr
+		}
+		ctr = new(utils.IntCounter)
+	}
+	r.counters[id] = append(r.counters[id], ctr)
+	return ctr
+}
+
+func (r *Registry) trackFunc(id string, f MonitorFunc) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.export {
+		r.funcs[id] = f
+	}
+}
+
+// check runs the liveness checks, and the readiness checks too if ready is
+// set. It returns the result of each check and whether all passed
+func (r *Registry) check(ready bool) (map[string]string, bool) {
+	r.lock.Lock()
+	checks := make(map[string]HealthCheck, len(r.liveness)+len(r.readiness))
+	for name, check := range r.liveness {
+		checks[name] = check
+	}
+	if ready {
+		for name, check := range r.readiness {
+			checks[name] = check
+		}
+	}
+	r.lock.Unlock()
+
+	results := make(map[string]string, len(checks))
+	healthy := true
+	for name, check := range checks {
+		if err := check(); err != nil {
+			results[name] = err.Error()
+			healthy = false
+		} else {
+			results[name] = "ok"
+		}
+	}
+	return results, healthy
+}
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// promName converts a metric id such as splunk.events.sent.count to a valid
+// Prometheus metric name
+func promName(id string) string {
+	name := invalidMetricChars.ReplaceAllString(id, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// WritePrometheus writes all exported metrics in Prometheus text format.
+// Counters of the same id are summed, funcs with non-numeric values are
+// skipped. Ids mapping to the name of a metric already written, such as a_b
+// after a.b or a func with the id of a counter, are skipped too, as
+// Prometheus rejects a scrape with duplicate metrics
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.lock.Lock()
+	counters := make(map[string][]utils.Counter, len(r.counters))
+	for id, list := range r.counters {
+		counters[id] = list
+	}
+	funcs := make(map[string]MonitorFunc, len(r.funcs))
+	for id, f := range r.funcs {
+		funcs[id] = f
+	}
+	r.lock.Unlock()
+
+	ids := make([]string, 0, len(counters))
+	for id := range counters {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	written := make(map[string]bool, len(counters)+len(funcs))
+	for _, id := range ids {
+		name := promName(id)
+		if written[name] {
+			continue
+		}
+		written[name] = true
+
+		var sum uint64
+		for _, ctr := range counters[id] {
+			if v, ok := ctr.Value().(uint64); ok {
+				sum += v
+			}
+		}
+		if _, err := fmt.Fprintf(w, "# TYPE %s counter\n%s %d\n", name, name, sum); err != nil {
+			return err
+		}
+	}
+
+	ids = ids[:0]
+	for id := range funcs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		name := promName(id)
+		if written[name] {
+			continue
+		}
+		value, ok := toFloat(funcs[id]())
+		if !ok {
+			continue
+		}
+		written[name] = true
+		if _, err := fmt.Fprintf(w, "# TYPE %s gauge\n%s %g\n", name, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,95 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const serverShutdownTimeout = 5 * time.Second
+
+// Server exposes the exported metrics in Prometheus text format on
+// /metrics, liveness on /healthz and readiness on /readyz
+type Server struct {
+	logger   lager.Logger
+	server   *http.Server
+	listener net.Listener
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func NewServer(addr string, logger lager.Logger) *Server {
+	s := &Server{logger: logger}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.metrics)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		s.health(w, false)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		s.health(w, true)
+	})
+
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Start listens on the configured address and serves in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("Metrics server exited", err)
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return s.server.Addr
+	}
+	return s.listener.Addr().String()
+}
+
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := registry.WritePrometheus(w); err != nil {
+		s.logger.Error("Failed to write metrics", err)
+	}
+}
+
+func (s *Server) health(w http.ResponseWriter, ready bool) {
+	checks, healthy := registry.check(ready)
+	resp := healthResponse{Status: "ok", Checks: checks}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		resp.Status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,103 @@
+package monitoring_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/lager/v3"
+	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/monitoring"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		server    *Server
+		firehose  error
+		hec       error
+		get       func(path string) (int, string)
+		getHealth func(path string) (int, map[string]interface{})
+	)
+
+	BeforeEach(func() {
+		firehose, hec = nil, nil
+		NewNoMonitor()
+		EnableExport()
+		RegisterLivenessCheck("test.firehose", func() error { return firehose })
+		RegisterReadinessCheck("test.hec", func() error { return hec })
+
+		server = NewServer("127.0.0.1:0", lager.NewLogger("test"))
+		Expect(server.Start()).To(Succeed())
+
+		get = func(path string) (int, string) {
+			resp, err := http.Get(fmt.Sprintf("http://%s%s", server.Addr(), path))
+			Expect(err).ShouldNot(HaveOccurred())
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			return resp.StatusCode, string(body)
+		}
+		getHealth = func(path string) (int, map[string]interface{}) {
+			code, body := get(path)
+			var health map[string]interface{}
+			Expect(json.Unmarshal([]byte(body), &health)).To(Succeed())
+			return code, health
+		}
+	})
+
+	AfterEach(func() {
+		server.Stop()
+	})
+
+	It("exports every registered counter and func", func() {
+		RegisterCounter("test.events.sent.count", utils.UintType).Add(uint64(3))
+		RegisterCounter("test.events.sent.count", utils.UintType).Add(uint64(4))
+		RegisterFunc("test.queue.percentage", func() interface{} { return 12.5 })
+		RegisterFunc("test.not.a.number", func() interface{} { return "x" })
+
+		code, body := get("/metrics")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring("# TYPE test_events_sent_count counter\ntest_events_sent_count 7\n"))
+		Expect(body).To(ContainSubstring("# TYPE test_queue_percentage gauge\ntest_queue_percentage 12.5\n"))
+		Expect(body).NotTo(ContainSubstring("test_not_a_number"))
+	})
+
+	It("skips metrics whose names collide", func() {
+		RegisterCounter("test.dup.count", utils.UintType).Add(uint64(1))
+		RegisterCounter("test_dup_count", utils.UintType).Add(uint64(2))
+		RegisterFunc("test.dup.count", func() interface{} { return 3 })
+		RegisterFunc("test.dup.gauge", func() interface{} { return 4 })
+		RegisterFunc("test_dup_gauge", func() interface{} { return 5 })
+
+		code, body := get("/metrics")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(strings.Count(body, "# TYPE test_dup_count ")).To(Equal(1))
+		Expect(body).To(ContainSubstring("# TYPE test_dup_count counter\ntest_dup_count 1\n"))
+		Expect(strings.Count(body, "# TYPE test_dup_gauge ")).To(Equal(1))
+		Expect(body).To(ContainSubstring("# TYPE test_dup_gauge gauge\ntest_dup_gauge 4\n"))
+	})
+
+	It("reports liveness and readiness", func() {
+		code, health := getHealth("/healthz")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(health["checks"]).To(HaveKeyWithValue("test.firehose", "ok"))
+		Expect(health["checks"]).NotTo(HaveKey("test.hec"))
+
+		hec = errors.New("connection refused")
+		code, _ = getHealth("/healthz")
+		Expect(code).To(Equal(http.StatusOK))
+
+		code, health = getHealth("/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(health["status"]).To(Equal("unavailable"))
+		Expect(health["checks"]).To(HaveKeyWithValue("test.hec", "connection refused"))
+
+		firehose = errors.New("disconnected")
+		code, _ = getHealth("/healthz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+	})
+})
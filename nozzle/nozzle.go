@@ -1,6 +1,9 @@
 package nozzle
 
 import (
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"code.cloudfoundry.org/lager/v3"
@@ -25,16 +28,48 @@ type Nozzle struct {
 
 	closing chan struct{}
 	closed  chan struct{}
+
+	healthLock sync.Mutex
+	started    bool
+	stopped    bool
+	lastEvent  time.Time
+	lastErr    error
+	lastErrAt  time.Time
 }
 
 func New(eventSource eventsource.Source, eventRouter eventrouter.Router, config *Config) *Nozzle {
-	return &Nozzle{
+	n := &Nozzle{
 		eventRouter: eventRouter,
 		eventSource: eventSource,
 		config:      config,
 		closing:     make(chan struct{}, 1),
 		closed:      make(chan struct{}, 1),
 	}
+	monitoring.RegisterLivenessCheck("firehose", n.Health)
+	return n
+}
+
+// Health reports an error while the event source is not connected: before
+// Start, after it stopped, and after a read error until events flow again
+func (f *Nozzle) Health() error {
+	f.healthLock.Lock()
+	defer f.healthLock.Unlock()
+
+	switch {
+	case !f.started:
+		return errors.New("not started")
+	case f.stopped:
+		return errors.New("stopped reading events")
+	case f.lastErr != nil && f.lastErrAt.After(f.lastEvent):
+		return fmt.Errorf("no events since error at %s: %s", f.lastErrAt.Format(time.RFC3339), f.lastErr)
+	}
+	return nil
+}
+
+func (f *Nozzle) setHealth(update func()) {
+	f.healthLock.Lock()
+	update()
+	f.healthLock.Unlock()
 }
 
 func (f *Nozzle) Start() error {
@@ -44,6 +79,8 @@ func (f *Nozzle) Start() error {
 	}
 
 	defer close(f.closed)
+	f.setHealth(func() { f.started = true })
+	defer f.setHealth(func() { f.stopped = true })
 
 	receivedCount := monitoring.RegisterCounter("firehose.events.received.count", utils.UintType)
 
@@ -57,11 +94,17 @@ func (f *Nozzle) Start() error {
 				return lastErr
 			}
 			receivedCount.Add(uint64(1))
+			f.setHealth(func() { f.lastEvent = time.Now() })
 			if err := f.eventRouter.Route(event); err != nil {
 				f.config.Logger.Error("Failed to route event", err)
 			}
 
 		case lastErr = <-errs:
+			err := lastErr
+			f.setHealth(func() {
+				f.lastErr = err
+				f.lastErrAt = time.Now()
+			})
 			f.handleError(lastErr)
 
 		case <-f.closing:
@@ -66,6 +66,16 @@ var _ = Describe("Nozzle", func() {
 			time.Sleep(time.Second)
 			nozzle.Close()
 		})
+
+		It("reports health", func() {
+			Expect(nozzle.Health()).To(MatchError("not started"))
+
+			go nozzle.Start()
+			Eventually(nozzle.Health).Should(Succeed())
+
+			eventSource.Close()
+			Eventually(nozzle.Health).Should(MatchError("stopped reading events"))
+		})
 	})
 
 	prepare := func(closeErr int, statusMonitorInterval time.Duration) func() {
@@ -2,16 +2,40 @@ package splunknozzle
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventwriter"
 
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
+const (
+	EventSourceFirehose   = "firehose"
+	EventSourceRLPGateway = "rlp-gateway"
+)
+
+const (
+	CommandRun            = "run"
+	CommandValidateConfig = "validate-config"
+)
+
 type Config struct {
+	// Command is the selected subcommand, run by default
+	Command string `json:"-"`
+	// args are the command line arguments, parsed again on reload
+	args []string
+
 	ApiEndpoint  string `json:"api-endpoint"`
 	User         string `json:"-"`
 	Password     string `json:"-"`
@@ -23,6 +47,10 @@ type Config struct {
 	SplunkIndex        string `json:"splunk-index"`
 	SplunkLoggingIndex string `json:"splunk-logging-index"`
 
+	HecLoadBalancing            string        `json:"hec-load-balancing"`
+	HecEndpointFailureThreshold int           `json:"hec-endpoint-failure-threshold"`
+	HecEndpointEjectDuration    time.Duration `json:"hec-endpoint-eject-duration"`
+
 	JobHost string `json:"job-host"`
 
 	SkipSSLCF      bool          `json:"skip-ssl-cf"`
@@ -30,17 +58,52 @@ type Config struct {
 	SubscriptionID string        `json:"firehose-subscription-id"`
 	KeepAlive      time.Duration `json:"keep-alive"`
 
-	AddAppInfo         string        `json:"add-app-info"`
-	IgnoreMissingApps  bool          `json:"ignore-missing-apps"`
-	MissingAppCacheTTL time.Duration `json:"missing-app-cache-ttl"`
-	AppCacheTTL        time.Duration `json:"app-cache-ttl"`
-	OrgSpaceCacheTTL   time.Duration `json:"org-space-cache-ttl"`
-	AppLimits          int           `json:"app-limits"`
-	AddTags            bool          `json:"add-tags"`
+	EventSource          string        `json:"event-source"`
+	RLPGatewayEndpoint   string        `json:"rlp-gateway-endpoint"`
+	EventSourceReconnect bool          `json:"event-source-reconnect"`
+	ReconnectMaxBackoff  time.Duration `json:"reconnect-max-backoff"`
+
+	AddAppInfo                string        `json:"add-app-info"`
+	IgnoreMissingApps         bool          `json:"ignore-missing-apps"`
+	MissingAppCacheTTL        time.Duration `json:"missing-app-cache-ttl"`
+	MissingAppBackoff         time.Duration `json:"missing-app-backoff"`
+	MissingAppMaxBackoff      time.Duration `json:"missing-app-max-backoff"`
+	AppCacheTTL               time.Duration `json:"app-cache-ttl"`
+	OrgSpaceCacheTTL          time.Duration `json:"org-space-cache-ttl"`
+	AppLimits                 int           `json:"app-limits"`
+	CAPIRequestRate           float64       `json:"capi-request-rate"`
+	AsyncAppLookup            bool          `json:"async-app-lookup"`
+	AppLookupWait             time.Duration `json:"app-lookup-wait"`
+	AppLookupConcurrency      int           `json:"app-lookup-concurrency"`
+	CAPIBreakerFailures       int           `json:"capi-breaker-failures"`
+	CAPIBreakerCooldown       time.Duration `json:"capi-breaker-cooldown"`
+	AppCacheIncremental       bool          `json:"app-cache-incremental"`
+	AppCacheReconcileInterval time.Duration `json:"app-cache-reconcile-interval"`
+	AuditEventPollInterval    time.Duration `json:"audit-event-poll-interval"`
+	AddTags                   bool          `json:"add-tags"`
+
+	MetadataIncludePrefixes string `json:"metadata-include-prefixes"`
+	MetadataExcludePrefixes string `json:"metadata-exclude-prefixes"`
+
+	BoltDBPath    string `json:"boltdb-path"`
+	AppCacheStore string `json:"app-cache-store"`
+	AppCacheSize  int    `json:"app-cache-size"`
+	WantedEvents  string `json:"wanted-events"`
+	IncludeFilter string `json:"include-filter"`
+	ExcludeFilter string `json:"exclude-filter"`
+	ExtraFields   string `json:"extra-fields"`
+	RoutingRules  string `json:"routing-rules"`
 
-	BoltDBPath   string `json:"boltdb-path"`
-	WantedEvents string `json:"wanted-events"`
-	ExtraFields  string `json:"extra-fields"`
+	ConfigFile          string        `json:"config-file"`
+	ConfigWatchInterval time.Duration `json:"config-watch-interval"`
+
+	RedactionDetectors string `json:"redaction-detectors"`
+	RedactionPatterns  string `json:"redaction-patterns"`
+	RedactionHashKey   string `json:"-"`
+
+	MultilineStartPattern string        `json:"multiline-start-pattern"`
+	MultilineMaxWait      time.Duration `json:"multiline-max-wait"`
+	MultilineMaxLines     int           `json:"multiline-max-lines"`
 
 	FlushInterval           time.Duration `json:"flush-interval"`
 	QueueSize               int           `json:"queue-size"`
@@ -49,6 +112,26 @@ type Config struct {
 	HecWorkers              int           `json:"hec-workers"`
 	RefreshSplunkConnection bool          `json:"refresh-splunk-connection"`
 	KeepAliveTimer          time.Duration `json:"keep-alive-timer"`
+	HecCompression          bool          `json:"hec-compression"`
+	HecCompressionLevel     int           `json:"hec-compression-level"`
+	HecEnableAck            bool          `json:"hec-enable-ack"`
+	HecAckTimeout           time.Duration `json:"hec-ack-timeout"`
+	HecAckPollInterval      time.Duration `json:"hec-ack-poll-interval"`
+
+	SpillQueueDir            string        `json:"spill-queue-dir"`
+	SpillQueueMaxSize        int           `json:"spill-queue-max-size"`
+	SpillQueueSegmentSize    int           `json:"spill-queue-segment-size"`
+	SpillQueueReplayInterval time.Duration `json:"spill-queue-replay-interval"`
+
+	RateLimitAppEPS          float64       `json:"rate-limit-app-eps"`
+	RateLimitAppBurst        float64       `json:"rate-limit-app-burst"`
+	RateLimitSpaceEPS        float64       `json:"rate-limit-space-eps"`
+	RateLimitSpaceBurst      float64       `json:"rate-limit-space-burst"`
+	RateLimitOrgEPS          float64       `json:"rate-limit-org-eps"`
+	RateLimitOrgBurst        float64       `json:"rate-limit-org-burst"`
+	RateLimitOverflow        string        `json:"rate-limit-overflow"`
+	RateLimitSampleRate      int           `json:"rate-limit-sample-rate"`
+	RateLimitSummaryInterval time.Duration `json:"rate-limit-summary-interval"`
 
 	Version string `json:"version"`
 	Branch  string `json:"branch"`
@@ -60,12 +143,61 @@ type Config struct {
 	StatusMonitorInterval     time.Duration `json:"mem-queue-monitor-interval"`
 	SelectedMonitoringMetrics string        `json:"selected-monitoring-metrics"`
 	SplunkMetricIndex         string        `json:"splunk-metric-index"`
+	NativeMetrics             bool          `json:"native-metrics"`
+	NativeMetricsIndex        string        `json:"native-metrics-index"`
 	MemoryBallastSize         int           `json:"memory-ballast-size"`
+	MetricsListenAddress      string        `json:"metrics-listen-address"`
+	AdminListenAddress        string        `json:"admin-listen-address"`
+	AdminToken                string        `json:"-"`
 	UseEnvVarForSplunkIndex   bool          `json:"use-env-var-for-splunk-index"`
 	UseLabelsForSplunkIndex   bool          `json:"use-labels-for-splunk-index"`
 }
 
+// NewConfigFromCmdFlags parses the command line, environment and config file.
+// It exits when they can not be parsed
 func NewConfigFromCmdFlags(version, branch, commit, buildos string) *Config {
+	c, err := ParseConfig(os.Args[1:], version, branch, commit, buildos)
+	kingpin.FatalIfError(err, "")
+	return c
+}
+
+// ParseConfig parses args and the environment, with the settings of the
+// config file as defaults. Flags take precedence over environment variables,
+// which take precedence over the config file
+func ParseConfig(args []string, version, branch, commit, buildos string) (*Config, error) {
+	c, app := newConfigApp(version, branch, commit, buildos)
+	command, err := app.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.ConfigFile != "" {
+		context, err := app.ParseContext(args)
+		if err != nil {
+			return nil, err
+		}
+		fileArgs, err := loadConfigFile(app, c.ConfigFile, context)
+		if err != nil {
+			return nil, err
+		}
+
+		path := c.ConfigFile
+		c, app = newConfigApp(version, branch, commit, buildos)
+		if command, err = app.Parse(append(fileArgs, args...)); err != nil {
+			return nil, fmt.Errorf("config file %s: %s", path, err)
+		}
+	}
+
+	c.Command = command
+	c.args = args
+	c.ApiEndpoint = strings.TrimSpace(c.ApiEndpoint)
+	c.SplunkHost = strings.Join(c.SplunkHosts(), ",")
+	c.RLPGatewayEndpoint = strings.TrimRight(strings.TrimSpace(c.RLPGatewayEndpoint), "/")
+	return c, nil
+}
+
+// newConfigApp defines the commands and flags of the nozzle, bound to a new Config
+func newConfigApp(version, branch, commit, buildos string) (*Config, *kingpin.Application) {
 	c := &Config{}
 
 	c.Version = version
@@ -73,102 +205,337 @@ func NewConfigFromCmdFlags(version, branch, commit, buildos string) *Config {
 	c.Commit = commit
 	c.BuildOS = buildos
 
-	kingpin.Version(version)
-	kingpin.Flag("api-endpoint", "API endpoint address").
-		OverrideDefaultFromEnvar("API_ENDPOINT").Required().StringVar(&c.ApiEndpoint)
-	kingpin.Flag("user", "Admin user.").
+	app := kingpin.New(filepath.Base(os.Args[0]), "Forwards Cloud Foundry firehose events to Splunk HTTP Event Collector")
+	app.Version(version)
+	app.Command(CommandRun, "Run the nozzle").Default()
+	app.Command(CommandValidateConfig, "Validate the configuration and exit")
+
+	app.Flag("api-endpoint", "API endpoint address").
+		OverrideDefaultFromEnvar("API_ENDPOINT").StringVar(&c.ApiEndpoint)
+	app.Flag("user", "Admin user.").
 		OverrideDefaultFromEnvar("API_USER").StringVar(&c.User)
-	kingpin.Flag("password", "Admin password.").
+	app.Flag("password", "Admin password.").
 		OverrideDefaultFromEnvar("API_PASSWORD").StringVar(&c.Password)
-	kingpin.Flag("client-id", "Client ID.").
-		OverrideDefaultFromEnvar("CLIENT_ID").Required().StringVar(&c.ClientID)
-	kingpin.Flag("client-secret", "Client secret.").
-		OverrideDefaultFromEnvar("CLIENT_SECRET").Required().StringVar(&c.ClientSecret)
-
-	kingpin.Flag("splunk-host", "Splunk HTTP event collector host").
-		OverrideDefaultFromEnvar("SPLUNK_HOST").Required().StringVar(&c.SplunkHost)
-	kingpin.Flag("splunk-token", "Splunk HTTP event collector token").
-		OverrideDefaultFromEnvar("SPLUNK_TOKEN").Required().StringVar(&c.SplunkToken)
-	kingpin.Flag("splunk-index", "Splunk index").
-		OverrideDefaultFromEnvar("SPLUNK_INDEX").Required().StringVar(&c.SplunkIndex)
-	kingpin.Flag("splunk-logging-index", "Splunk logging index").
+	app.Flag("client-id", "Client ID.").
+		OverrideDefaultFromEnvar("CLIENT_ID").StringVar(&c.ClientID)
+	app.Flag("client-secret", "Client secret.").
+		OverrideDefaultFromEnvar("CLIENT_SECRET").StringVar(&c.ClientSecret)
+
+	app.Flag("splunk-host", "Splunk HTTP event collector host. A comma separated list load balances over several hosts").
+		OverrideDefaultFromEnvar("SPLUNK_HOST").StringVar(&c.SplunkHost)
+	app.Flag("splunk-token", "Splunk HTTP event collector token").
+		OverrideDefaultFromEnvar("SPLUNK_TOKEN").StringVar(&c.SplunkToken)
+	app.Flag("splunk-index", "Splunk index").
+		OverrideDefaultFromEnvar("SPLUNK_INDEX").StringVar(&c.SplunkIndex)
+	app.Flag("splunk-logging-index", "Splunk logging index").
 		OverrideDefaultFromEnvar("SPLUNK_LOGGING_INDEX").StringVar(&c.SplunkLoggingIndex)
+	app.Flag("hec-load-balancing", "How requests are distributed when several Splunk hosts are configured. Valid options are round-robin and least-outstanding").
+		OverrideDefaultFromEnvar("HEC_LOAD_BALANCING").Default(eventwriter.RoundRobin).EnumVar(&c.HecLoadBalancing, eventwriter.RoundRobin, eventwriter.LeastOutstanding)
+	app.Flag("hec-endpoint-failure-threshold", "Consecutive connection errors or 5xx responses after which a Splunk host is temporarily taken out of rotation").
+		OverrideDefaultFromEnvar("HEC_ENDPOINT_FAILURE_THRESHOLD").Default("3").IntVar(&c.HecEndpointFailureThreshold)
+	app.Flag("hec-endpoint-eject-duration", "How long a failing Splunk host is kept out of rotation").
+		OverrideDefaultFromEnvar("HEC_ENDPOINT_EJECT_DURATION").Default("30s").DurationVar(&c.HecEndpointEjectDuration)
 
-	kingpin.Flag("job-host", "Job host to tag nozzle's own log events").
+	app.Flag("job-host", "Job host to tag nozzle's own log events").
 		OverrideDefaultFromEnvar("JOB_HOST").Default("").StringVar(&c.JobHost)
 
-	kingpin.Flag("skip-ssl-validation-cf", "Skip cert validation (for dev environments").
+	app.Flag("skip-ssl-validation-cf", "Skip cert validation (for dev environments").
 		OverrideDefaultFromEnvar("SKIP_SSL_VALIDATION_CF").Default("false").BoolVar(&c.SkipSSLCF)
-	kingpin.Flag("skip-ssl-validation-splunk", "Skip cert validation (for dev environments").
+	app.Flag("skip-ssl-validation-splunk", "Skip cert validation (for dev environments").
 		OverrideDefaultFromEnvar("SKIP_SSL_VALIDATION_SPLUNK").Default("false").BoolVar(&c.SkipSSLSplunk)
-	kingpin.Flag("firehose-subscription-id", "Id for the subscription.").
+	app.Flag("firehose-subscription-id", "Id for the subscription.").
 		OverrideDefaultFromEnvar("FIREHOSE_SUBSCRIPTION_ID").Default("splunk-firehose").StringVar(&c.SubscriptionID)
-	kingpin.Flag("firehose-keep-alive", "Keep Alive duration for the firehose consumer").
+	app.Flag("firehose-keep-alive", "Keep Alive duration for the firehose consumer").
 		OverrideDefaultFromEnvar("FIREHOSE_KEEP_ALIVE").Default("25s").DurationVar(&c.KeepAlive)
+	app.Flag("event-source", "Where to read events from. Valid options are firehose (v1 websocket firehose) and rlp-gateway (Loggregator V2 Reverse Log Proxy gateway)").
+		OverrideDefaultFromEnvar("EVENT_SOURCE").Default(EventSourceFirehose).EnumVar(&c.EventSource, EventSourceFirehose, EventSourceRLPGateway)
+	app.Flag("rlp-gateway-endpoint", "RLP gateway address. Defaults to the log_stream link advertised by the Cloud Controller").
+		OverrideDefaultFromEnvar("RLP_GATEWAY_ENDPOINT").Default("").StringVar(&c.RLPGatewayEndpoint)
+	app.Flag("event-source-reconnect", "Reconnect to the event source indefinitely, fetching its endpoint from the Cloud Controller again, instead of exiting when it gives up").
+		OverrideDefaultFromEnvar("EVENT_SOURCE_RECONNECT").Default("true").BoolVar(&c.EventSourceReconnect)
+	app.Flag("reconnect-max-backoff", "Longest wait between attempts to reconnect to the event source").
+		OverrideDefaultFromEnvar("RECONNECT_MAX_BACKOFF").Default("1m").DurationVar(&c.ReconnectMaxBackoff)
 
-	kingpin.Flag("add-app-info", fmt.Sprintf("Comma separated list of app metadata to enrich event. Valid options are %s", events.AuthorizedMetadata())).
+	app.Flag("add-app-info", fmt.Sprintf("Comma separated list of app metadata to enrich event. Valid options are %s", events.AuthorizedMetadata())).
 		OverrideDefaultFromEnvar("ADD_APP_INFO").Default("").StringVar(&c.AddAppInfo)
-	kingpin.Flag("ignore-missing-app", "If app is missing, stop repeatedly querying app info from Cloud Foundry foundation").
+	app.Flag("ignore-missing-app", "If app is missing, stop repeatedly querying app info from Cloud Foundry foundation").
 		OverrideDefaultFromEnvar("IGNORE_MISSING_APP").Default("true").BoolVar(&c.IgnoreMissingApps)
-	kingpin.Flag("missing-app-cache-invalidate-ttl", "How frequently the missing app info cache invalidates").
+	app.Flag("missing-app-cache-invalidate-ttl", "How frequently the missing app info cache invalidates").
 		OverrideDefaultFromEnvar("MISSING_APP_CACHE_INVALIDATE_TTL").Default("0s").DurationVar(&c.MissingAppCacheTTL)
-	kingpin.Flag("app-cache-invalidate-ttl", "How frequently the app info local cache invalidates").
+	app.Flag("missing-app-backoff", "How long a missing app is ignored before it is looked up again, doubling with every failed lookup. 0s ignores it until the missing app info cache invalidates").
+		OverrideDefaultFromEnvar("MISSING_APP_BACKOFF").Default("0s").DurationVar(&c.MissingAppBackoff)
+	app.Flag("missing-app-max-backoff", "Longest time a missing app is ignored before it is looked up again").
+		OverrideDefaultFromEnvar("MISSING_APP_MAX_BACKOFF").Default("1h").DurationVar(&c.MissingAppMaxBackoff)
+	app.Flag("app-cache-invalidate-ttl", "How frequently the app info local cache invalidates").
 		OverrideDefaultFromEnvar("APP_CACHE_INVALIDATE_TTL").Default("0s").DurationVar(&c.AppCacheTTL)
-	kingpin.Flag("org-space-cache-invalidate-ttl", "How frequently the org and space cache invalidates").
+	app.Flag("org-space-cache-invalidate-ttl", "How frequently the org and space cache invalidates").
 		OverrideDefaultFromEnvar("ORG_SPACE_CACHE_INVALIDATE_TTL").Default("72h").DurationVar(&c.OrgSpaceCacheTTL)
-	kingpin.Flag("app-limits", "Restrict to APP_LIMITS most updated apps per request when populating the app metadata cache").
+	app.Flag("app-limits", "Restrict to APP_LIMITS most recently updated apps when populating the app metadata cache. 0 loads all apps").
 		OverrideDefaultFromEnvar("APP_LIMITS").Default("0").IntVar(&c.AppLimits)
-	kingpin.Flag("add-tags", "Add additional tags from envelope. (Default: false)").
+	app.Flag("capi-request-rate", "Maximum Cloud Controller requests per second made by the app metadata cache. 0 is unlimited").
+		OverrideDefaultFromEnvar("CAPI_REQUEST_RATE").Default("0").Float64Var(&c.CAPIRequestRate)
+	app.Flag("async-app-lookup", "Fetch apps missing from the app metadata cache in the background, instead of blocking the event pipeline").
+		OverrideDefaultFromEnvar("ASYNC_APP_LOOKUP").Default("false").BoolVar(&c.AsyncAppLookup)
+	app.Flag("app-lookup-wait", "How long an event waits for the background lookup of its app before it is sent without app metadata and with cf_app_metadata_pending").
+		OverrideDefaultFromEnvar("APP_LOOKUP_WAIT").Default("100ms").DurationVar(&c.AppLookupWait)
+	app.Flag("app-lookup-concurrency", "Maximum apps looked up in the background at once. Events of other missing apps are sent without app metadata meanwhile").
+		OverrideDefaultFromEnvar("APP_LOOKUP_CONCURRENCY").Default("8").IntVar(&c.AppLookupConcurrency)
+	app.Flag("capi-breaker-failures", "Consecutive failed Cloud Controller requests after which the app metadata cache suspends requests. 0 disables it").
+		OverrideDefaultFromEnvar("CAPI_BREAKER_FAILURES").Default("0").IntVar(&c.CAPIBreakerFailures)
+	app.Flag("capi-breaker-cooldown", "How long the app metadata cache suspends Cloud Controller requests after repeated failures").
+		OverrideDefaultFromEnvar("CAPI_BREAKER_COOLDOWN").Default("30s").DurationVar(&c.CAPIBreakerCooldown)
+	app.Flag("app-cache-incremental", "Only fetch apps created or updated since the last sync when the app info local cache invalidates").
+		OverrideDefaultFromEnvar("APP_CACHE_INCREMENTAL").Default("false").BoolVar(&c.AppCacheIncremental)
+	app.Flag("app-cache-reconcile-interval", "How frequently the incremental app info local cache does a full refresh, dropping deleted apps. 0s never does").
+		OverrideDefaultFromEnvar("APP_CACHE_RECONCILE_INTERVAL").Default("1h").DurationVar(&c.AppCacheReconcileInterval)
+	app.Flag("audit-event-poll-interval", "How frequently to poll Cloud Controller audit events to invalidate changed apps, spaces and orgs in the app info cache. 0s disables it").
+		OverrideDefaultFromEnvar("AUDIT_EVENT_POLL_INTERVAL").Default("0s").DurationVar(&c.AuditEventPollInterval)
+	app.Flag("add-tags", "Add additional tags from envelope. (Default: false)").
 		OverrideDefaultFromEnvar("ADD_TAGS").Default("false").BoolVar(&c.AddTags)
+	app.Flag("metadata-include-prefixes", "Comma separated label and annotation key prefixes added to events with AppLabels, AppAnnotations, SpaceLabels, SpaceAnnotations, OrgLabels or OrgAnnotations in add-app-info. Empty adds all keys").
+		OverrideDefaultFromEnvar("METADATA_INCLUDE_PREFIXES").Default("").StringVar(&c.MetadataIncludePrefixes)
+	app.Flag("metadata-exclude-prefixes", "Comma separated label and annotation key prefixes never added to events").
+		OverrideDefaultFromEnvar("METADATA_EXCLUDE_PREFIXES").Default("").StringVar(&c.MetadataExcludePrefixes)
 
-	kingpin.Flag("boltdb-path", "Bolt Database path ").
+	app.Flag("boltdb-path", "Bolt Database path ").
 		Default("cache.db").OverrideDefaultFromEnvar("BOLTDB_PATH").StringVar(&c.BoltDBPath)
-	kingpin.Flag("events", fmt.Sprintf("Comma separated list of events you would like. Valid options are %s", events.AuthorizedEvents())).
+	app.Flag("app-cache-store", fmt.Sprintf("Where the app info cache is persisted between restarts. Valid options are %s and %s, which keeps it in memory only", cache.StoreBoltdb, cache.StoreNone)).
+		OverrideDefaultFromEnvar("APP_CACHE_STORE").Default(cache.StoreBoltdb).EnumVar(&c.AppCacheStore, cache.StoreBoltdb, cache.StoreNone)
+	app.Flag("app-cache-size", "Maximum number of most recently used apps kept in the in-memory app info cache. 0 is unbounded").
+		OverrideDefaultFromEnvar("APP_CACHE_SIZE").Default("0").IntVar(&c.AppCacheSize)
+	app.Flag("events", fmt.Sprintf("Comma separated list of events you would like. Valid options are %s", events.AuthorizedEvents())).
 		OverrideDefaultFromEnvar("EVENTS").Default("ValueMetric,CounterEvent,ContainerMetric").StringVar(&c.WantedEvents)
-	kingpin.Flag("extra-fields", "Extra fields you want to annotate your events with, example: '--extra-fields=env:dev,something:other ").
+	app.Flag("include-filter", "Filter expression events must match to be forwarded, example: 'origin == \"gorouter\" && status_code >= 400'").
+		OverrideDefaultFromEnvar("INCLUDE_FILTER").Default("").StringVar(&c.IncludeFilter)
+	app.Flag("exclude-filter", "Filter expression for events to drop, example: 'uri =~ /health/'").
+		OverrideDefaultFromEnvar("EXCLUDE_FILTER").Default("").StringVar(&c.ExcludeFilter)
+	app.Flag("extra-fields", "Extra fields you want to annotate your events with, example: '--extra-fields=env:dev,something:other ").
 		OverrideDefaultFromEnvar("EXTRA_FIELDS").Default("").StringVar(&c.ExtraFields)
+	app.Flag("routing-rules", "JSON array of rules assigning index, sourcetype, source and HEC token to matching events. The first matching rule wins").
+		OverrideDefaultFromEnvar("ROUTING_RULES").Default("").StringVar(&c.RoutingRules)
+	app.Flag("config-file", "YAML or JSON file with settings keyed by flag name. Flags and environment variables take precedence. events, extra-fields, routing-rules, include-filter, exclude-filter, hec-batch-size, flush-interval and selected-monitoring-metrics are reloaded on SIGHUP").
+		OverrideDefaultFromEnvar("CONFIG_FILE").Default("").StringVar(&c.ConfigFile)
+	app.Flag("config-watch-interval", "How often the config file is checked for changes, which are reloaded like on SIGHUP. 0 disables watching").
+		OverrideDefaultFromEnvar("CONFIG_WATCH_INTERVAL").Default("0s").DurationVar(&c.ConfigWatchInterval)
+	app.Flag("redaction-detectors", "Comma separated built-in detectors redacted from messages, with an optional action, example: 'credit_card,email:hash,jwt:drop-field'. Detectors: credit_card, email, bearer, aws_key, jwt. Actions: mask (default), hash, drop-field").
+		OverrideDefaultFromEnvar("REDACTION_DETECTORS").Default("").StringVar(&c.RedactionDetectors)
+	app.Flag("redaction-patterns", "JSON array of custom redaction rules with name, pattern and action").
+		OverrideDefaultFromEnvar("REDACTION_PATTERNS").Default("").StringVar(&c.RedactionPatterns)
+	app.Flag("redaction-hash-key", "Secret key for HMAC-SHA256 hashes of redacted values. Plain SHA-256 is used when empty").
+		OverrideDefaultFromEnvar("REDACTION_HASH_KEY").Default("").StringVar(&c.RedactionHashKey)
+	app.Flag("multiline-start-pattern", "Regex matching the first line of a log event. Other lines are joined to the previous event of the same app instance. Empty disables multiline reassembly").
+		OverrideDefaultFromEnvar("MULTILINE_START_PATTERN").Default("").StringVar(&c.MultilineStartPattern)
+	app.Flag("multiline-max-wait", "How long the first line of a multiline event waits for continuation lines").
+		OverrideDefaultFromEnvar("MULTILINE_MAX_WAIT").Default("1s").DurationVar(&c.MultilineMaxWait)
+	app.Flag("multiline-max-lines", "Maximum number of lines joined into one event, 0 is unlimited").
+		OverrideDefaultFromEnvar("MULTILINE_MAX_LINES").Default("500").IntVar(&c.MultilineMaxLines)
 
-	kingpin.Flag("flush-interval", "Every interval flushes to Splunk Http Event Collector server").
+	app.Flag("flush-interval", "Every interval flushes to Splunk Http Event Collector server").
 		OverrideDefaultFromEnvar("FLUSH_INTERVAL").Default("5s").DurationVar(&c.FlushInterval)
-	kingpin.Flag("consumer-queue-size", "Consumer queue buffer size").
+	app.Flag("consumer-queue-size", "Consumer queue buffer size").
 		OverrideDefaultFromEnvar("CONSUMER_QUEUE_SIZE").Default("10000").IntVar(&c.QueueSize)
-	kingpin.Flag("hec-batch-size", "Batchsize of the events pushing to HEC").
+	app.Flag("hec-batch-size", "Batchsize of the events pushing to HEC").
 		OverrideDefaultFromEnvar("HEC_BATCH_SIZE").Default("100").IntVar(&c.BatchSize)
-	kingpin.Flag("hec-retries", "Number of retries before dropping events").
+	app.Flag("hec-retries", "Number of retries before dropping events").
 		OverrideDefaultFromEnvar("HEC_RETRIES").Default("5").IntVar(&c.Retries)
-	kingpin.Flag("hec-workers", "How many workers (concurrency) when post data to HEC").
+	app.Flag("hec-workers", "How many workers (concurrency) when post data to HEC").
 		OverrideDefaultFromEnvar("HEC_WORKERS").Default("8").IntVar(&c.HecWorkers)
-	kingpin.Flag("refresh-splunk-connection", "Periodically refresh connection to Splunk").
+	app.Flag("refresh-splunk-connection", "Periodically refresh connection to Splunk").
 		OverrideDefaultFromEnvar("REFRESH_SPLUNK_CONNECTION").Default("false").BoolVar(&c.RefreshSplunkConnection)
-	kingpin.Flag("keep-alive-timer", "Interval used to close and refresh connection to Splunk").
+	app.Flag("keep-alive-timer", "Interval used to close and refresh connection to Splunk").
 		OverrideDefaultFromEnvar("KEEP_ALIVE_TIMER").Default("30s").DurationVar(&c.KeepAliveTimer)
+	app.Flag("hec-compression", "Gzip compress request bodies sent to Splunk HEC").
+		OverrideDefaultFromEnvar("HEC_COMPRESSION").Default("false").BoolVar(&c.HecCompression)
+	app.Flag("hec-compression-level", "Gzip compression level, from 1 (fastest) to 9 (best compression)").
+		OverrideDefaultFromEnvar("HEC_COMPRESSION_LEVEL").Default("6").IntVar(&c.HecCompressionLevel)
+	app.Flag("hec-enable-ack", "Wait for indexer acknowledgement before considering a batch delivered. The HEC token must have indexer acknowledgement enabled").
+		OverrideDefaultFromEnvar("HEC_ENABLE_ACK").Default("false").BoolVar(&c.HecEnableAck)
+	app.Flag("hec-ack-timeout", "How long to wait for indexer acknowledgement before retrying a batch").
+		OverrideDefaultFromEnvar("HEC_ACK_TIMEOUT").Default("60s").DurationVar(&c.HecAckTimeout)
+	app.Flag("hec-ack-poll-interval", "Interval between indexer acknowledgement status queries").
+		OverrideDefaultFromEnvar("HEC_ACK_POLL_INTERVAL").Default("1s").DurationVar(&c.HecAckPollInterval)
+	app.Flag("spill-queue-dir", "Directory of the on-disk queue which holds batches while Splunk is unavailable. Empty disables the spill queue").
+		OverrideDefaultFromEnvar("SPILL_QUEUE_DIR").Default("").StringVar(&c.SpillQueueDir)
+	app.Flag("spill-queue-max-size", "Maximum size in MB of the on-disk spill queue. Batches are dropped when it is full").
+		OverrideDefaultFromEnvar("SPILL_QUEUE_MAX_SIZE").Default("1024").IntVar(&c.SpillQueueMaxSize)
+	app.Flag("spill-queue-segment-size", "Size in MB of each spill queue segment file").
+		OverrideDefaultFromEnvar("SPILL_QUEUE_SEGMENT_SIZE").Default("64").IntVar(&c.SpillQueueSegmentSize)
+	app.Flag("spill-queue-replay-interval", "How long to wait after a failed replay of spilled batches to Splunk before trying again").
+		OverrideDefaultFromEnvar("SPILL_QUEUE_REPLAY_INTERVAL").Default("5s").DurationVar(&c.SpillQueueReplayInterval)
+	app.Flag("rate-limit-app-eps", "Maximum LogMessage events per second forwarded per app. 0 disables the limit").
+		OverrideDefaultFromEnvar("RATE_LIMIT_APP_EPS").Default("0").Float64Var(&c.RateLimitAppEPS)
+	app.Flag("rate-limit-app-burst", "LogMessage events per app allowed above the rate in a burst. Defaults to the rate").
+		OverrideDefaultFromEnvar("RATE_LIMIT_APP_BURST").Default("0").Float64Var(&c.RateLimitAppBurst)
+	app.Flag("rate-limit-space-eps", "Maximum LogMessage events per second forwarded per space. 0 disables the limit").
+		OverrideDefaultFromEnvar("RATE_LIMIT_SPACE_EPS").Default("0").Float64Var(&c.RateLimitSpaceEPS)
+	app.Flag("rate-limit-space-burst", "LogMessage events per space allowed above the rate in a burst. Defaults to the rate").
+		OverrideDefaultFromEnvar("RATE_LIMIT_SPACE_BURST").Default("0").Float64Var(&c.RateLimitSpaceBurst)
+	app.Flag("rate-limit-org-eps", "Maximum LogMessage events per second forwarded per org. 0 disables the limit").
+		OverrideDefaultFromEnvar("RATE_LIMIT_ORG_EPS").Default("0").Float64Var(&c.RateLimitOrgEPS)
+	app.Flag("rate-limit-org-burst", "LogMessage events per org allowed above the rate in a burst. Defaults to the rate").
+		OverrideDefaultFromEnvar("RATE_LIMIT_ORG_BURST").Default("0").Float64Var(&c.RateLimitOrgBurst)
+	app.Flag("rate-limit-overflow", "What to do with events over the rate limit: drop or sample").
+		OverrideDefaultFromEnvar("RATE_LIMIT_OVERFLOW").Default(eventsink.RateLimitOverflowDrop).EnumVar(&c.RateLimitOverflow, eventsink.RateLimitOverflowDrop, eventsink.RateLimitOverflowSample)
+	app.Flag("rate-limit-sample-rate", "With sample overflow, forward 1 in this many events over the rate limit").
+		OverrideDefaultFromEnvar("RATE_LIMIT_SAMPLE_RATE").Default("10").IntVar(&c.RateLimitSampleRate)
+	app.Flag("rate-limit-summary-interval", "How often a summary of rate limited apps is logged. 0 disables the summary").
+		OverrideDefaultFromEnvar("RATE_LIMIT_SUMMARY_INTERVAL").Default("1m").DurationVar(&c.RateLimitSummaryInterval)
 
-	kingpin.Flag("enable-event-tracing", "Enable event trace logging: Adds splunk trace logging fields to events. uuid, firehose-subscription-id, nozzle event counter").
+	app.Flag("enable-event-tracing", "Enable event trace logging: Adds splunk trace logging fields to events. uuid, firehose-subscription-id, nozzle event counter").
 		OverrideDefaultFromEnvar("ENABLE_EVENT_TRACING").Default("false").BoolVar(&c.TraceLogging)
-	kingpin.Flag("debug", "Enable debug mode: forward to standard out instead of splunk").
+	app.Flag("debug", "Enable debug mode: forward to standard out instead of splunk").
 		OverrideDefaultFromEnvar("DEBUG").Default("false").BoolVar(&c.Debug)
-	kingpin.Flag("status-monitor-interval", "Print information for monitoring at every interval").
+	app.Flag("status-monitor-interval", "Print information for monitoring at every interval").
 		OverrideDefaultFromEnvar("STATUS_MONITOR_INTERVAL").Default("0s").DurationVar(&c.StatusMonitorInterval)
-	kingpin.Flag("selected-monitoring-metrics", "Comma separated list of metrics that user want to visualize").
+	app.Flag("selected-monitoring-metrics", "Comma separated list of metrics that user want to visualize").
 		OverrideDefaultFromEnvar("SELECTED_MONITORING_METRICS").Default("nozzle.queue.percentage,splunk.events.dropped.count,splunk.events.sent.count,firehose.events.dropped.count,firehose.events.received.count,splunk.events.throughput,nozzle.usage.ram,nozzle.usage.cpu,nozzle.cache.memory.hit,nozzle.cache.memory.miss,nozzle.cache.remote.hit,nozzle.cache.remote.miss,nozzle.cache.boltdb.hit,nozzle.cache.boltdb.miss").StringVar(&c.SelectedMonitoringMetrics)
-	kingpin.Flag("splunk-metric-index", "Splunk metric index").
+	app.Flag("splunk-metric-index", "Splunk metric index").
 		OverrideDefaultFromEnvar("SPLUNK_METRIC_INDEX").StringVar(&c.SplunkMetricIndex)
-	kingpin.Flag("memory-ballast-size", "Size of ballast in MB").
+	app.Flag("native-metrics", "Send ValueMetric, CounterEvent and ContainerMetric events in Splunk HEC metrics format").
+		OverrideDefaultFromEnvar("NATIVE_METRICS").Default("false").BoolVar(&c.NativeMetrics)
+	app.Flag("native-metrics-index", "Splunk metrics index for native metrics. Defaults to the Splunk metric index").
+		OverrideDefaultFromEnvar("NATIVE_METRICS_INDEX").Default("").StringVar(&c.NativeMetricsIndex)
+	app.Flag("memory-ballast-size", "Size of ballast in MB").
 		OverrideDefaultFromEnvar("MEMORY_BALLAST_SIZE").Default("0").IntVar(&c.MemoryBallastSize)
-	kingpin.Flag("use-env-var-for-splunk-index", "Use environmental variable SPLUNK_INDEX to read custom index from apps").
+	app.Flag("metrics-listen-address", "Address of the HTTP server exposing Prometheus metrics on /metrics and health on /healthz and /readyz, example: ':9090'. Empty disables the server").
+		OverrideDefaultFromEnvar("METRICS_LISTEN_ADDRESS").Default("").StringVar(&c.MetricsListenAddress)
+	app.Flag("admin-listen-address", "Address of the HTTP server administering the app info cache, example: '127.0.0.1:9091'. Empty disables the server").
+		OverrideDefaultFromEnvar("ADMIN_LISTEN_ADDRESS").Default("").StringVar(&c.AdminListenAddress)
+	app.Flag("admin-token", "Bearer token required by the admin server").
+		OverrideDefaultFromEnvar("ADMIN_TOKEN").Default("").StringVar(&c.AdminToken)
+	app.Flag("use-env-var-for-splunk-index", "Use environmental variable SPLUNK_INDEX to read custom index from apps").
 		OverrideDefaultFromEnvar("USE_ENV_VAR_FOR_SPLUNK_INDEX").Default("true").BoolVar(&c.UseEnvVarForSplunkIndex)
-	kingpin.Flag("use-labels-for-splunk-index", "Use CF Labels to read SPLUNK_INDEX from apps (takes priority over env var when possible)").
+	app.Flag("use-labels-for-splunk-index", "Use CF Labels to read SPLUNK_INDEX from apps (takes priority over env var when possible)").
 		OverrideDefaultFromEnvar("USE_LABELS_FOR_SPLUNK_INDEX").Default("false").BoolVar(&c.UseLabelsForSplunkIndex)
 
-	kingpin.Parse()
-	c.ApiEndpoint = strings.TrimSpace(c.ApiEndpoint)
-	c.SplunkHost = strings.TrimRight(strings.TrimSpace(c.SplunkHost), "/")
-	return c
+	return c, app
+}
+
+// Validate checks that required settings are set, values are in range and
+// expressions parse. All problems are reported, one per line
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(name string, ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", name, fmt.Sprintf(format, args...)))
+		}
+	}
+	parses := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", name, err))
+		}
+	}
+
+	check("api-endpoint", c.ApiEndpoint != "", "is required")
+	check("client-id", c.ClientID != "", "is required")
+	check("client-secret", c.ClientSecret != "", "is required")
+	check("splunk-host", len(c.SplunkHosts()) > 0, "is required")
+	check("splunk-token", c.SplunkToken != "", "is required")
+	check("splunk-index", c.SplunkIndex != "", "is required")
+
+	check("hec-batch-size", c.BatchSize >= 1, "must be at least 1, got %d", c.BatchSize)
+	check("hec-workers", c.HecWorkers >= 1, "must be at least 1, got %d", c.HecWorkers)
+	check("hec-endpoint-failure-threshold", c.HecEndpointFailureThreshold >= 1, "must be at least 1, got %d", c.HecEndpointFailureThreshold)
+	check("hec-retries", c.Retries >= 0, "must not be negative, got %d", c.Retries)
+	check("consumer-queue-size", c.QueueSize >= 1, "must be at least 1, got %d", c.QueueSize)
+	check("flush-interval", c.FlushInterval > 0, "must be positive, got %s", c.FlushInterval)
+	check("hec-compression-level", c.HecCompressionLevel >= 1 && c.HecCompressionLevel <= 9, "must be between 1 and 9, got %d", c.HecCompressionLevel)
+	check("multiline-max-lines", c.MultilineMaxLines >= 0, "must not be negative, got %d", c.MultilineMaxLines)
+	check("capi-request-rate", c.CAPIRequestRate >= 0, "must not be negative, got %g", c.CAPIRequestRate)
+	check("app-limits", c.AppLimits >= 0, "must not be negative, got %d", c.AppLimits)
+	check("missing-app-backoff", c.MissingAppBackoff >= 0, "must not be negative, got %s", c.MissingAppBackoff)
+	check("missing-app-max-backoff", c.MissingAppMaxBackoff >= 0, "must not be negative, got %s", c.MissingAppMaxBackoff)
+	check("app-lookup-wait", c.AppLookupWait >= 0, "must not be negative, got %s", c.AppLookupWait)
+	check("app-lookup-concurrency", c.AppLookupConcurrency >= 1, "must be at least 1, got %d", c.AppLookupConcurrency)
+	check("capi-breaker-failures", c.CAPIBreakerFailures >= 0, "must not be negative, got %d", c.CAPIBreakerFailures)
+	check("capi-breaker-cooldown", c.CAPIBreakerCooldown >= 0, "must not be negative, got %s", c.CAPIBreakerCooldown)
+	check("app-cache-reconcile-interval", c.AppCacheReconcileInterval >= 0, "must not be negative, got %s", c.AppCacheReconcileInterval)
+	check("app-cache-size", c.AppCacheSize >= 0, "must not be negative, got %d", c.AppCacheSize)
+	check("audit-event-poll-interval", c.AuditEventPollInterval >= 0, "must not be negative, got %s", c.AuditEventPollInterval)
+	check("reconnect-max-backoff", c.ReconnectMaxBackoff > 0, "must be positive, got %s", c.ReconnectMaxBackoff)
+	if c.AdminListenAddress != "" {
+		check("admin-token", c.AdminToken != "", "is required with admin-listen-address")
+		check("add-app-info", c.AddAppInfo != "", "is required with admin-listen-address")
+	}
+	check("rate-limit-sample-rate", c.RateLimitSampleRate >= 1, "must be at least 1, got %d", c.RateLimitSampleRate)
+	// Space and org limits take the space and org of an app from the app cache
+	check("rate-limit-space-eps", c.RateLimitSpaceEPS == 0 || c.AddAppInfo != "", "requires add-app-info")
+	check("rate-limit-org-eps", c.RateLimitOrgEPS == 0 || c.AddAppInfo != "", "requires add-app-info")
+	for name, rate := range map[string]float64{
+		"rate-limit-app-eps":     c.RateLimitAppEPS,
+		"rate-limit-app-burst":   c.RateLimitAppBurst,
+		"rate-limit-space-eps":   c.RateLimitSpaceEPS,
+		"rate-limit-space-burst": c.RateLimitSpaceBurst,
+		"rate-limit-org-eps":     c.RateLimitOrgEPS,
+		"rate-limit-org-burst":   c.RateLimitOrgBurst,
+	} {
+		check(name, rate >= 0, "must not be negative, got %g", rate)
+	}
+
+	_, err := events.ParseSelectedEvents(c.WantedEvents)
+	parses("events", err)
+	_, err = events.ParseExtraFields(c.ExtraFields)
+	parses("extra-fields", err)
+	_, err = events.ParseRoutingRules(c.RoutingRules)
+	parses("routing-rules", err)
+	// Without add-app-info there is no app cache to take app metadata from
+	for name, expr := range map[string]string{"include-filter": c.IncludeFilter, "exclude-filter": c.ExcludeFilter} {
+		if expr == "" {
+			continue
+		}
+		filter, err := eventrouter.ParseFilter(expr)
+		parses(name, err)
+		if err == nil {
+			check(name, c.AddAppInfo != "" || !filter.ReferencesAppData(), "references app metadata, which requires add-app-info")
+		}
+	}
+	_, err = events.ParseRedactor(c.RedactionDetectors, "", "")
+	parses("redaction-detectors", err)
+	_, err = events.ParseRedactor("", c.RedactionPatterns, "")
+	parses("redaction-patterns", err)
+	if c.MultilineStartPattern != "" {
+		_, err = regexp.Compile(c.MultilineStartPattern)
+		parses("multiline-start-pattern", err)
+	}
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
 }
 
+// SplunkHosts returns the configured HEC hosts
+func (c *Config) SplunkHosts() []string {
+	var hosts []string
+	for _, host := range strings.Split(c.SplunkHost, ",") {
+		host = strings.TrimRight(strings.TrimSpace(host), "/")
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// ToMap returns the config for logging, without secrets
 func (c *Config) ToMap() map[string]interface{} {
 	data, _ := json.Marshal(c)
 	var r map[string]interface{}
 	json.Unmarshal(data, &r)
+	if c.RoutingRules != "" {
+		r["routing-rules"] = redactRoutingTokens(c.RoutingRules)
+	}
 	return r
 }
+
+// redactRoutingTokens hides the HEC tokens of routing rules. Rules which do
+// not parse may hold tokens too and are not shown
+func redactRoutingTokens(rules string) interface{} {
+	var parsed []map[string]interface{}
+	if err := json.Unmarshal([]byte(rules), &parsed); err != nil {
+		return "(invalid)"
+	}
+	for _, rule := range parsed {
+		if _, ok := rule["token"]; ok {
+			rule["token"] = "(redacted)"
+		}
+	}
+	return parsed
+}
@@ -1,6 +1,7 @@
 package splunknozzle_test
 
 import (
+	"encoding/json"
 	"os"
 	"time"
 
@@ -45,6 +46,10 @@ var _ = Describe("Config", func() {
 
 			os.Setenv("FIREHOSE_SUBSCRIPTION_ID", "my-nozzle")
 			os.Setenv("FIREHOSE_KEEP_ALIVE", "42s")
+			os.Setenv("EVENT_SOURCE", "rlp-gateway")
+			os.Setenv("RLP_GATEWAY_ENDPOINT", "https://log-stream.bosh-lite.com/")
+			os.Setenv("EVENT_SOURCE_RECONNECT", "false")
+			os.Setenv("RECONNECT_MAX_BACKOFF", "5m")
 
 			os.Setenv("ADD_APP_INFO", "AppName")
 			os.Setenv("IGNORE_MISSING_APP", "true")
@@ -55,6 +60,8 @@ var _ = Describe("Config", func() {
 			os.Setenv("BOLTDB_PATH", "foo.db")
 			os.Setenv("EVENTS", "LogMessage")
 			os.Setenv("EXTRA_FIELDS", "foo:bar")
+			os.Setenv("EXCLUDE_FILTER", "uri =~ /health/")
+			os.Setenv("REDACTION_DETECTORS", "credit_card,email:hash")
 			os.Setenv("ADD_TAGS", "true")
 
 			os.Setenv("FLUSH_INTERVAL", "43s")
@@ -66,6 +73,7 @@ var _ = Describe("Config", func() {
 			os.Setenv("DEBUG", "true")
 			os.Setenv("DROP_WARN_THRESHOLD", "100")
 			os.Setenv("MEMORY_BALLAST_SIZE", "512")
+			os.Setenv("METRICS_LISTEN_ADDRESS", ":9090")
 
 			c := NewConfigFromCmdFlags(version, branch, commit, buildos)
 
@@ -86,6 +94,10 @@ var _ = Describe("Config", func() {
 
 			Expect(c.SubscriptionID).To(Equal("my-nozzle"))
 			Expect(c.KeepAlive).To(Equal(42 * time.Second))
+			Expect(c.EventSource).To(Equal("rlp-gateway"))
+			Expect(c.RLPGatewayEndpoint).To(Equal("https://log-stream.bosh-lite.com"))
+			Expect(c.EventSourceReconnect).To(BeFalse())
+			Expect(c.ReconnectMaxBackoff).To(Equal(5 * time.Minute))
 
 			Expect(c.AddAppInfo).To(Equal("AppName"))
 			Expect(c.IgnoreMissingApps).To(BeTrue())
@@ -96,6 +108,10 @@ var _ = Describe("Config", func() {
 			Expect(c.BoltDBPath).To(Equal("foo.db"))
 			Expect(c.WantedEvents).To(Equal("LogMessage"))
 			Expect(c.ExtraFields).To(Equal("foo:bar"))
+			Expect(c.IncludeFilter).To(Equal(""))
+			Expect(c.ExcludeFilter).To(Equal("uri =~ /health/"))
+			Expect(c.RedactionDetectors).To(Equal("credit_card,email:hash"))
+			Expect(c.RedactionPatterns).To(Equal(""))
 			Expect(c.AddTags).To(BeTrue())
 
 			Expect(c.FlushInterval).To(Equal(43 * time.Second))
@@ -112,6 +128,53 @@ var _ = Describe("Config", func() {
 			Expect(c.TraceLogging).To(BeTrue())
 			Expect(c.Debug).To(BeTrue())
 			Expect(c.MemoryBallastSize).To(Equal(512))
+			Expect(c.MetricsListenAddress).To(Equal(":9090"))
+		})
+
+		It("parses a list of Splunk hosts", func() {
+			os.Setenv("SPLUNK_HOST", " https://hf1.example.com:8088/, https://hf2.example.com:8088 ,")
+			os.Setenv("HEC_LOAD_BALANCING", "least-outstanding")
+
+			c := NewConfigFromCmdFlags(version, branch, commit, buildos)
+
+			Expect(c.SplunkHosts()).To(Equal([]string{"https://hf1.example.com:8088", "https://hf2.example.com:8088"}))
+			Expect(c.SplunkHost).To(Equal("https://hf1.example.com:8088,https://hf2.example.com:8088"))
+			Expect(c.HecLoadBalancing).To(Equal("least-outstanding"))
+		})
+
+		It("parses rate limits", func() {
+			os.Setenv("RATE_LIMIT_APP_EPS", "100")
+			os.Setenv("RATE_LIMIT_APP_BURST", "500")
+			os.Setenv("RATE_LIMIT_ORG_EPS", "2500.5")
+			os.Setenv("RATE_LIMIT_OVERFLOW", "sample")
+			os.Setenv("RATE_LIMIT_SAMPLE_RATE", "100")
+			os.Setenv("RATE_LIMIT_SUMMARY_INTERVAL", "30s")
+
+			c := NewConfigFromCmdFlags(version, branch, commit, buildos)
+
+			Expect(c.RateLimitAppEPS).To(Equal(float64(100)))
+			Expect(c.RateLimitAppBurst).To(Equal(float64(500)))
+			Expect(c.RateLimitSpaceEPS).To(Equal(float64(0)))
+			Expect(c.RateLimitOrgEPS).To(Equal(2500.5))
+			Expect(c.RateLimitOverflow).To(Equal("sample"))
+			Expect(c.RateLimitSampleRate).To(Equal(100))
+			Expect(c.RateLimitSummaryInterval).To(Equal(30 * time.Second))
+		})
+
+		It("hides secrets from the logged config", func() {
+			os.Setenv("SPLUNK_TOKEN", "splunk-secret")
+			os.Setenv("REDACTION_HASH_KEY", "hash-secret")
+			os.Setenv("ROUTING_RULES", `[{"match": {"event_type": "Error"}, "index": "errors", "token": "route-secret"}]`)
+			c := NewConfigFromCmdFlags(version, branch, commit, buildos)
+
+			data, err := json.Marshal(c.ToMap())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).NotTo(ContainSubstring("splunk-secret"))
+			Expect(string(data)).NotTo(ContainSubstring("route-secret"))
+			Expect(string(data)).NotTo(ContainSubstring("hash-secret"))
+			Expect(c.ToMap()["routing-rules"]).To(Equal([]map[string]interface{}{
+				{"match": map[string]interface{}{"event_type": "Error"}, "index": "errors", "token": "(redacted)"},
+			}))
 		})
 
 		It("check defaults", func() {
@@ -123,6 +186,10 @@ var _ = Describe("Config", func() {
 			Expect(c.SkipSSLCF).To(BeFalse())
 			Expect(c.SubscriptionID).To(Equal("splunk-firehose"))
 			Expect(c.KeepAlive).To(Equal(25 * time.Second))
+			Expect(c.EventSource).To(Equal("firehose"))
+			Expect(c.RLPGatewayEndpoint).To(Equal(""))
+			Expect(c.EventSourceReconnect).To(BeTrue())
+			Expect(c.ReconnectMaxBackoff).To(Equal(time.Minute))
 
 			Expect(c.AddAppInfo).To(Equal(""))
 			Expect(c.IgnoreMissingApps).To(BeTrue())
@@ -140,10 +207,32 @@ var _ = Describe("Config", func() {
 			Expect(c.BatchSize).To(Equal(100))
 			Expect(c.Retries).To(Equal(5))
 			Expect(c.HecWorkers).To(Equal(8))
+			Expect(c.HecLoadBalancing).To(Equal("round-robin"))
+			Expect(c.HecEndpointFailureThreshold).To(Equal(3))
+			Expect(c.HecEndpointEjectDuration).To(Equal(30 * time.Second))
+			Expect(c.HecCompression).To(BeFalse())
+			Expect(c.HecCompressionLevel).To(Equal(6))
+			Expect(c.HecEnableAck).To(BeFalse())
+			Expect(c.HecAckTimeout).To(Equal(60 * time.Second))
+			Expect(c.HecAckPollInterval).To(Equal(time.Second))
+			Expect(c.SpillQueueDir).To(Equal(""))
+			Expect(c.SpillQueueMaxSize).To(Equal(1024))
+			Expect(c.SpillQueueSegmentSize).To(Equal(64))
+			Expect(c.SpillQueueReplayInterval).To(Equal(5 * time.Second))
+			Expect(c.RateLimitAppEPS).To(Equal(float64(0)))
+			Expect(c.RateLimitSpaceEPS).To(Equal(float64(0)))
+			Expect(c.RateLimitOrgEPS).To(Equal(float64(0)))
+			Expect(c.RateLimitOverflow).To(Equal("drop"))
+			Expect(c.RateLimitSampleRate).To(Equal(10))
+			Expect(c.RateLimitSummaryInterval).To(Equal(time.Minute))
+			Expect(c.MultilineStartPattern).To(Equal(""))
+			Expect(c.MultilineMaxWait).To(Equal(time.Second))
+			Expect(c.MultilineMaxLines).To(Equal(500))
 
 			Expect(c.TraceLogging).To(BeFalse())
 			Expect(c.Debug).To(BeFalse())
 			Expect(c.MemoryBallastSize).To(Equal(0))
+			Expect(c.MetricsListenAddress).To(Equal(""))
 		})
 	})
 
@@ -191,6 +280,8 @@ var _ = Describe("Config", func() {
 				"--debug",
 				"--splunk-metric-index=metric",
 				"--memory-ballast-size=512",
+				"--multiline-start-pattern=^\\S",
+				"--multiline-max-wait=250ms",
 			}
 			os.Args = args
 		})
@@ -235,6 +326,8 @@ var _ = Describe("Config", func() {
 			Expect(c.Debug).To(BeTrue())
 			Expect(c.TraceLogging).To(BeTrue())
 			Expect(c.MemoryBallastSize).To(Equal(512))
+			Expect(c.MultilineStartPattern).To(Equal(`^\S`))
+			Expect(c.MultilineMaxWait).To(Equal(250 * time.Millisecond))
 
 			Expect(c.Version).To(Equal(version))
 			Expect(c.Branch).To(Equal(branch))
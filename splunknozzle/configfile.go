@@ -0,0 +1,144 @@
+package splunknozzle
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v3"
+)
+
+// settings which are not allowed in the config file
+var notFileSettings = map[string]bool{
+	"help":        true,
+	"version":     true,
+	"config-file": true,
+}
+
+// loadConfigFile reads the YAML or JSON config file and returns its settings
+// as command line flags of app, which must not be parsed again. Settings are keyed by flag name. Lists are
+// joined with commas, maps of scalars become key:value pairs and anything
+// else nested is passed as JSON. Settings given on the command line in
+// context or by environment variable are skipped, as they take precedence
+func loadConfigFile(app *kingpin.Application, path string, context *kingpin.ParseContext) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("config file %s: %s", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config file %s line %d: expected a map of settings", path, root.Line)
+	}
+
+	given := make(map[string]bool)
+	for _, element := range context.Elements {
+		if flag, ok := element.Clause.(*kingpin.FlagClause); ok {
+			given[flag.Model().Name] = true
+		}
+	}
+
+	var args []string
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		name := key.Value
+
+		flag := app.GetFlag(name)
+		if flag == nil || notFileSettings[name] {
+			return nil, fmt.Errorf("config file %s line %d: unknown setting %q", path, key.Line, name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("config file %s line %d: duplicate setting %q", path, key.Line, name)
+		}
+		seen[name] = true
+
+		if given[name] || flag.HasEnvarValue() {
+			continue
+		}
+
+		if flag.Model().IsBoolFlag() {
+			var enabled bool
+			if value.Kind != yaml.ScalarNode || value.Decode(&enabled) != nil {
+				return nil, fmt.Errorf("config file %s line %d: %s must be true or false", path, value.Line, name)
+			}
+			if enabled {
+				args = append(args, "--"+name)
+			} else {
+				args = append(args, "--no-"+name)
+			}
+			continue
+		}
+
+		// Setting the value of the throwaway app reports errors with their line
+		arg, err := settingValue(value)
+		if err == nil {
+			err = flag.Model().Value.Set(arg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("config file %s line %d: invalid %s: %s", path, value.Line, name, err)
+		}
+		args = append(args, fmt.Sprintf("--%s=%s", name, arg))
+	}
+	return args, nil
+}
+
+// settingValue converts a config file value to its flag representation
+func settingValue(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return "", nil
+		}
+		return node.Value, nil
+
+	case yaml.SequenceNode:
+		if scalars(node.Content) {
+			var items []string
+			for _, item := range node.Content {
+				items = append(items, item.Value)
+			}
+			return strings.Join(items, ","), nil
+		}
+
+	case yaml.MappingNode:
+		if scalars(node.Content) {
+			var pairs []string
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				pairs = append(pairs, node.Content[i].Value+":"+node.Content[i+1].Value)
+			}
+			return strings.Join(pairs, ","), nil
+		}
+
+	case yaml.AliasNode:
+		return settingValue(node.Alias)
+	}
+
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func scalars(nodes []*yaml.Node) bool {
+	for _, node := range nodes {
+		if node.Kind != yaml.ScalarNode {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package splunknozzle_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/splunknozzle"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config file", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	parse := func(content string, args ...string) (*Config, error) {
+		Expect(os.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return ParseConfig(append([]string{"--config-file=" + path}, args...), "1.0", "develop", "f1c3178", "Linux")
+	}
+
+	BeforeEach(func() {
+		os.Clearenv()
+
+		var err error
+		dir, err = os.MkdirTemp("", "config")
+		Expect(err).ShouldNot(HaveOccurred())
+		path = filepath.Join(dir, "nozzle.yml")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("parses nested YAML settings", func() {
+		c, err := parse(`
+api-endpoint: https://api.example.com
+splunk-host:
+  - https://splunk1.example.com:8088
+  - https://splunk2.example.com:8088
+events: [LogMessage, HttpStartStop]
+extra-fields:
+  env: prod
+  team: payments
+routing-rules:
+  - match: {org: sales}
+    index: sales
+skip-ssl-validation-cf: true
+ignore-missing-app: false
+hec-batch-size: 500
+flush-interval: 2s
+`)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(c.ApiEndpoint).To(Equal("https://api.example.com"))
+		Expect(c.SplunkHosts()).To(Equal([]string{"https://splunk1.example.com:8088", "https://splunk2.example.com:8088"}))
+		Expect(c.WantedEvents).To(Equal("LogMessage,HttpStartStop"))
+		Expect(c.ExtraFields).To(Equal("env:prod,team:payments"))
+		Expect(c.RoutingRules).To(MatchJSON(`[{"match": {"org": "sales"}, "index": "sales"}]`))
+		Expect(c.SkipSSLCF).To(BeTrue())
+		Expect(c.IgnoreMissingApps).To(BeFalse())
+		Expect(c.BatchSize).To(Equal(500))
+		Expect(c.FlushInterval).To(Equal(2 * time.Second))
+		Expect(c.HecWorkers).To(Equal(8))
+		Expect(c.Command).To(Equal(CommandRun))
+	})
+
+	It("parses JSON", func() {
+		c, err := parse(`{"events": ["LogMessage"], "hec-workers": 4, "redaction-patterns": [{"name": "ssn", "pattern": "\\d{3}-\\d{2}-\\d{4}"}]}`)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(c.WantedEvents).To(Equal("LogMessage"))
+		Expect(c.HecWorkers).To(Equal(4))
+		Expect(c.RedactionPatterns).To(MatchJSON(`[{"name": "ssn", "pattern": "\\d{3}-\\d{2}-\\d{4}"}]`))
+	})
+
+	It("gives flags and environment variables precedence", func() {
+		os.Setenv("HEC_WORKERS", "2")
+
+		c, err := parse("hec-workers: 4\nhec-retries: 7\nhec-batch-size: 50\n", "--hec-batch-size=10")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(c.HecWorkers).To(Equal(2))
+		Expect(c.Retries).To(Equal(7))
+		Expect(c.BatchSize).To(Equal(10))
+	})
+
+	It("reports invalid settings with their line", func() {
+		_, err := parse("events: [LogMessage]\nhec-wokers: 4\n")
+		Expect(err).To(MatchError(ContainSubstring(`line 2: unknown setting "hec-wokers"`)))
+
+		_, err = parse("debug: maybe\n")
+		Expect(err).To(MatchError(ContainSubstring("line 1: debug must be true or false")))
+
+		_, err = parse("hec-workers: many\n")
+		Expect(err).To(MatchError(ContainSubstring("hec-workers")))
+
+		_, err = parse("- events\n")
+		Expect(err).To(MatchError(ContainSubstring("expected a map of settings")))
+
+		_, err = parse("config-file: other.yml\n")
+		Expect(err).To(MatchError(ContainSubstring(`unknown setting "config-file"`)))
+	})
+
+	It("selects the validate-config command", func() {
+		c, err := parse("", "validate-config")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(c.Command).To(Equal(CommandValidateConfig))
+	})
+
+	It("validates the configuration", func() {
+		c, err := parse(`
+api-endpoint: https://api.example.com
+client-id: nozzle
+client-secret: secret
+splunk-host: https://splunk.example.com:8088
+splunk-token: token
+splunk-index: main
+`)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(c.Validate()).To(Succeed())
+
+		c, err = parse(`
+api-endpoint: https://api.example.com
+hec-batch-size: 0
+hec-compression-level: 12
+hec-endpoint-failure-threshold: 0
+events: [LogMessage, Bogus]
+routing-rules: not json
+multiline-start-pattern: "(["
+include-filter: 'org_name == "sales"'
+rate-limit-org-eps: 100
+`)
+		Expect(err).ShouldNot(HaveOccurred())
+		err = c.Validate()
+		Expect(err).To(HaveOccurred())
+		for _, problem := range []string{
+			"client-id: is required",
+			"client-secret: is required",
+			"splunk-host: is required",
+			"splunk-token: is required",
+			"splunk-index: is required",
+			"hec-batch-size: must be at least 1, got 0",
+			"hec-compression-level: must be between 1 and 9, got 12",
+			"hec-endpoint-failure-threshold: must be at least 1, got 0",
+			"events: rejected event name [Bogus]",
+			"routing-rules:",
+			"multiline-start-pattern:",
+			"include-filter: references app metadata, which requires add-app-info",
+			"rate-limit-org-eps: requires add-app-info",
+		} {
+			Expect(err.Error()).To(ContainSubstring(problem))
+		}
+	})
+})
@@ -10,6 +10,7 @@ import (
 	"github.com/cloudfoundry/go-cfclient/v3/resource"
 
 	"code.cloudfoundry.org/lager/v3"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/admin"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
 	"github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
@@ -30,6 +31,8 @@ import (
 type SplunkFirehoseNozzle struct {
 	config *Config
 	logger lager.Logger
+
+	endpoints *eventwriter.EndpointPool
 }
 
 type NozzleCfClient client.Client // NozzleCfClient is a wrapper around cfclient.Client
@@ -52,8 +55,17 @@ func (ncc NozzleCfClient) AppByGuid(appGUID string) (*resource.App, error) {
 	return ncc.Applications.Get(cfContext, appGUID)
 }
 
-func (ncc NozzleCfClient) ListApps() ([]*resource.App, error) {
-	return ncc.Applications.ListAll(cfContext, &client.AppListOptions{ListOptions: &client.ListOptions{PerPage: 500}})
+// ListAppsPage lists apps updated at or after updatedSince, all apps when zero
+func (ncc NozzleCfClient) ListAppsPage(page, perPage int, updatedSince time.Time) (*cache.AppPage, error) {
+	opts := &client.AppListOptions{ListOptions: &client.ListOptions{Page: page, PerPage: perPage, OrderBy: "-updated_at"}}
+	if !updatedSince.IsZero() {
+		opts.UpdatedAts.AfterOrEqualTo(updatedSince)
+	}
+	apps, spaces, orgs, pager, err := ncc.Applications.ListIncludeSpacesAndOrganizations(cfContext, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &cache.AppPage{Apps: apps, Spaces: spaces, Orgs: orgs, HasNext: pager.HasNextPage()}, nil
 }
 
 func (ncc NozzleCfClient) GetSpaceByGuid(spaceGUID string) (*resource.Space, error) {
@@ -68,6 +80,24 @@ func (ncc NozzleCfClient) GetAppEnvVars(appGUID string) (map[string]*string, err
 	return ncc.Applications.GetEnvironmentVariables(cfContext, appGUID)
 }
 
+func (ncc NozzleCfClient) GetAppProcesses(appGUID string) ([]*resource.Process, error) {
+	return ncc.Processes.ListForAppAll(cfContext, appGUID, nil)
+}
+
+func (ncc NozzleCfClient) GetCurrentDroplet(appGUID string) (*resource.Droplet, error) {
+	return ncc.Droplets.GetCurrentForApp(cfContext, appGUID)
+}
+
+// ListAuditEvents lists the audit events of the types created at or after
+// createdSince, oldest first
+func (ncc NozzleCfClient) ListAuditEvents(types []string, createdSince time.Time) ([]*resource.AuditEvent, error) {
+	opts := client.NewAuditEventListOptions()
+	opts.OrderBy = "created_at"
+	opts.Types.EqualTo(types...)
+	opts.CreateAts.AfterOrEqualTo(createdSince)
+	return ncc.AuditEvents.ListAll(cfContext, opts)
+}
+
 // create new function of type *SplunkFirehoseNozzle
 func NewSplunkFirehoseNozzle(config *Config, logger lager.Logger) *SplunkFirehoseNozzle {
 	return &SplunkFirehoseNozzle{
@@ -78,17 +108,37 @@ func NewSplunkFirehoseNozzle(config *Config, logger lager.Logger) *SplunkFirehos
 
 // EventRouter creates EventRouter object and setup routes for interested events
 func (s *SplunkFirehoseNozzle) EventRouter(cache cache.Cache, eventSink eventsink.Sink) (eventrouter.Router, error) {
-	LowerAddAppInfo := strings.ToLower(s.config.AddAppInfo)
-	config := &eventrouter.Config{
-		SelectedEvents: s.config.WantedEvents,
+	return eventrouter.New(cache, eventSink, s.routerConfig(s.config))
+}
+
+func (s *SplunkFirehoseNozzle) routerConfig(c *Config) *eventrouter.Config {
+	LowerAddAppInfo := strings.ToLower(c.AddAppInfo)
+	return &eventrouter.Config{
+		SelectedEvents: c.WantedEvents,
 		AddAppName:     strings.Contains(LowerAddAppInfo, "appname"),
 		AddOrgName:     strings.Contains(LowerAddAppInfo, "orgname"),
 		AddOrgGuid:     strings.Contains(LowerAddAppInfo, "orgguid"),
 		AddSpaceName:   strings.Contains(LowerAddAppInfo, "spacename"),
 		AddSpaceGuid:   strings.Contains(LowerAddAppInfo, "spaceguid"),
-		AddTags:        s.config.AddTags,
+		AddTags:        c.AddTags,
+		IncludeFilter:  c.IncludeFilter,
+		ExcludeFilter:  c.ExcludeFilter,
+
+		AddAppLabels:        strings.Contains(LowerAddAppInfo, "applabels"),
+		AddAppAnnotations:   strings.Contains(LowerAddAppInfo, "appannotations"),
+		AddSpaceLabels:      strings.Contains(LowerAddAppInfo, "spacelabels"),
+		AddSpaceAnnotations: strings.Contains(LowerAddAppInfo, "spaceannotations"),
+		AddOrgLabels:        strings.Contains(LowerAddAppInfo, "orglabels"),
+		AddOrgAnnotations:   strings.Contains(LowerAddAppInfo, "organnotations"),
+		AddAppState:         strings.Contains(LowerAddAppInfo, "appstate"),
+		AddStack:            strings.Contains(LowerAddAppInfo, "stack"),
+		AddBuildpacks:       strings.Contains(LowerAddAppInfo, "buildpacks"),
+		AddDropletGuid:      strings.Contains(LowerAddAppInfo, "dropletguid"),
+		AddProcessTypes:     strings.Contains(LowerAddAppInfo, "processtypes"),
+		AddInstanceCount:    strings.Contains(LowerAddAppInfo, "instancecount"),
+		MetadataInclude:     events.ParsePrefixes(c.MetadataIncludePrefixes),
+		MetadataExclude:     events.ParsePrefixes(c.MetadataExcludePrefixes),
 	}
-	return eventrouter.New(cache, eventSink, config)
 }
 
 // CFClient creates a client object which can talk to Cloud Foundry
@@ -117,14 +167,32 @@ func (s *SplunkFirehoseNozzle) PCFClient() (*NozzleCfClient, error) {
 // AppCache creates in-memory cache or boltDB cache
 func (s *SplunkFirehoseNozzle) AppCache(client cache.AppClient) (cache.Cache, error) {
 	if s.config.AddAppInfo != "" {
+		LowerAddAppInfo := strings.ToLower(s.config.AddAppInfo)
+		store, err := cache.NewStore(s.config.AppCacheStore, s.config.BoltDBPath)
+		if err != nil {
+			return nil, err
+		}
 		c := cache.BoltdbConfig{
 			Path:                    s.config.BoltDBPath,
 			IgnoreMissingApps:       s.config.IgnoreMissingApps,
 			MissingAppCacheTTL:      s.config.MissingAppCacheTTL,
+			MissingAppBackoff:       s.config.MissingAppBackoff,
+			MissingAppMaxBackoff:    s.config.MissingAppMaxBackoff,
 			AppCacheTTL:             s.config.AppCacheTTL,
 			OrgSpaceCacheTTL:        s.config.OrgSpaceCacheTTL,
+			AppLimits:               s.config.AppLimits,
+			RequestRate:             s.config.CAPIRequestRate,
+			BreakerFailures:         s.config.CAPIBreakerFailures,
+			BreakerCooldown:         s.config.CAPIBreakerCooldown,
+			IncrementalRefresh:      s.config.AppCacheIncremental,
+			ReconcileInterval:       s.config.AppCacheReconcileInterval,
+			AuditEventInterval:      s.config.AuditEventPollInterval,
+			MaxApps:                 s.config.AppCacheSize,
+			Store:                   store,
 			UseEnvVarForSplunkIndex: s.config.UseEnvVarForSplunkIndex,
 			UseLabelsForSplunkIndex: s.config.UseLabelsForSplunkIndex,
+			FetchProcesses:          strings.Contains(LowerAddAppInfo, "processtypes") || strings.Contains(LowerAddAppInfo, "instancecount"),
+			FetchDroplet:            strings.Contains(LowerAddAppInfo, "dropletguid") || strings.Contains(LowerAddAppInfo, "buildpacks"),
 			Logger:                  s.logger,
 		}
 		return cache.NewBoltdb(client, &c)
@@ -133,11 +201,70 @@ func (s *SplunkFirehoseNozzle) AppCache(client cache.AppClient) (cache.Cache, er
 	return cache.NewNoCache(), nil
 }
 
+// EndpointPool returns the pool shared by all writers when several Splunk
+// hosts are configured, nil otherwise
+func (s *SplunkFirehoseNozzle) EndpointPool() (*eventwriter.EndpointPool, error) {
+	hosts := s.config.SplunkHosts()
+	if len(hosts) < 2 || s.endpoints != nil {
+		return s.endpoints, nil
+	}
+
+	pool, err := eventwriter.NewEndpointPool(&eventwriter.EndpointPoolConfig{
+		Hosts:            hosts,
+		Strategy:         s.config.HecLoadBalancing,
+		FailureThreshold: s.config.HecEndpointFailureThreshold,
+		EjectDuration:    s.config.HecEndpointEjectDuration,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.endpoints = pool
+	return pool, nil
+}
+
+func (s *SplunkFirehoseNozzle) registerEndpointMetrics(pool *eventwriter.EndpointPool) {
+	monitoring.RegisterFunc("splunk.endpoints.healthy", func() interface{} {
+		healthy := 0
+		for _, e := range pool.Endpoints() {
+			if pool.Healthy(e) {
+				healthy++
+			}
+		}
+		return healthy
+	})
+
+	for _, e := range pool.Endpoints() {
+		endpoint := e
+		prefix := "splunk.endpoint." + endpoint.MetricName()
+		endpoint.Requests = monitoring.RegisterCounter(prefix+".requests.count", utils.UintType)
+		endpoint.Failures = monitoring.RegisterCounter(prefix+".errors.count", utils.UintType)
+		endpoint.Ejected = monitoring.RegisterCounter(prefix+".ejected.count", utils.UintType)
+		monitoring.RegisterFunc(prefix+".outstanding", func() interface{} {
+			return endpoint.Outstanding()
+		})
+		monitoring.RegisterFunc(prefix+".healthy", func() interface{} {
+			if pool.Healthy(endpoint) {
+				return 1
+			}
+			return 0
+		})
+	}
+}
+
 // EventSink creates std sink or Splunk sink
 func (s *SplunkFirehoseNozzle) EventSink(cache cache.Cache) (eventsink.Sink, error) {
+	endpoints, err := s.EndpointPool()
+	if err != nil {
+		s.logger.Error("Error at configuring Splunk hosts", err)
+		return nil, err
+	}
+	if endpoints != nil {
+		s.registerEndpointMetrics(endpoints)
+	}
 
 	// EventWriter for writing events
 	writerConfig := &eventwriter.SplunkConfig{
+		Endpoints:               endpoints,
 		Host:                    s.config.SplunkHost,
 		Token:                   s.config.SplunkToken,
 		Index:                   s.config.SplunkIndex,
@@ -147,13 +274,28 @@ func (s *SplunkFirehoseNozzle) EventSink(cache cache.Cache) (eventsink.Sink, err
 		Version:                 s.config.Version,
 		RefreshSplunkConnection: s.config.RefreshSplunkConnection,
 		KeepAliveTimer:          s.config.KeepAliveTimer,
+		Compress:                s.config.HecCompression,
+		CompressionLevel:        s.config.HecCompressionLevel,
+		UseAck:                  s.config.HecEnableAck,
+		AckTimeout:              s.config.HecAckTimeout,
+		AckPollInterval:         s.config.HecAckPollInterval,
 	}
 
+	// The last writer sends the nozzle's own logs, which must not block
+	// waiting for indexer acknowledgement
+	logWriterConfig := *writerConfig
+	logWriterConfig.UseAck = false
+
 	var writers []eventwriter.Writer
 	for i := 0; i < s.config.HecWorkers+1; i++ {
-		splunkWriter := eventwriter.NewSplunkEvent(writerConfig).(*eventwriter.SplunkEvent)
+		config := writerConfig
+		if i == s.config.HecWorkers {
+			config = &logWriterConfig
+		}
+		splunkWriter := eventwriter.NewSplunkEvent(config).(*eventwriter.SplunkEvent)
 		splunkWriter.SentEventCount = monitoring.RegisterCounter("splunk.events.sent.count", utils.UintType)
 		splunkWriter.BodyBufferSize = monitoring.RegisterCounter("splunk.events.throughput", utils.UintType)
+		splunkWriter.CompressedBodyBufferSize = monitoring.RegisterCounter("splunk.events.throughput.compressed", utils.UintType)
 		writers = append(writers, splunkWriter)
 	}
 
@@ -163,23 +305,67 @@ func (s *SplunkFirehoseNozzle) EventSink(cache cache.Cache) (eventsink.Sink, err
 		return nil, err
 	}
 
+	routes, err := events.ParseRoutingRules(s.config.RoutingRules)
+	if err != nil {
+		s.logger.Error("Error at parsing routing rules", err)
+		return nil, err
+	}
+
+	redactor, err := events.ParseRedactor(s.config.RedactionDetectors, s.config.RedactionPatterns, s.config.RedactionHashKey)
+	if err != nil {
+		s.logger.Error("Error at parsing redaction rules", err)
+		return nil, err
+	}
+	if redactor.Len() == 0 {
+		redactor = nil
+	}
+
+	metricsIndex := s.config.NativeMetricsIndex
+	if metricsIndex == "" {
+		metricsIndex = s.config.SplunkMetricIndex
+	}
+
 	nozzleUUID := uuid.New().String()
 
 	sinkConfig := &eventsink.SplunkConfig{
-		FlushInterval:           s.config.FlushInterval,
-		QueueSize:               s.config.QueueSize,
-		BatchSize:               s.config.BatchSize,
-		Retries:                 s.config.Retries,
-		Hostname:                s.config.JobHost,
-		SubscriptionID:          s.config.SubscriptionID,
-		TraceLogging:            s.config.TraceLogging,
-		ExtraFields:             parsedExtraFields,
+		FlushInterval:  s.config.FlushInterval,
+		QueueSize:      s.config.QueueSize,
+		BatchSize:      s.config.BatchSize,
+		Retries:        s.config.Retries,
+		Hostname:       s.config.JobHost,
+		SubscriptionID: s.config.SubscriptionID,
+		TraceLogging:   s.config.TraceLogging,
+		ExtraFields:    parsedExtraFields,
+		Routes:         routes,
+		Redactor:       redactor,
+		Multiline: &eventsink.MultilineConfig{
+			StartPattern: s.config.MultilineStartPattern,
+			MaxWait:      s.config.MultilineMaxWait,
+			MaxLines:     s.config.MultilineMaxLines,
+		},
+		NativeMetrics:           s.config.NativeMetrics,
+		MetricsIndex:            metricsIndex,
 		UUID:                    nozzleUUID,
 		Logger:                  s.logger,
 		LoggingIndex:            s.config.SplunkLoggingIndex,
 		StatusMonitorInterval:   s.config.StatusMonitorInterval,
 		RefreshSplunkConnection: s.config.RefreshSplunkConnection,
 		KeepAliveTimer:          s.config.KeepAliveTimer,
+		SpillQueueDir:           s.config.SpillQueueDir,
+		SpillQueueMaxSize:       int64(s.config.SpillQueueMaxSize) << 20,
+		SpillQueueSegmentSize:   int64(s.config.SpillQueueSegmentSize) << 20,
+		SpillReplayInterval:     s.config.SpillQueueReplayInterval,
+		RateLimit: &eventsink.RateLimitConfig{
+			AppRate:         s.config.RateLimitAppEPS,
+			AppBurst:        s.config.RateLimitAppBurst,
+			SpaceRate:       s.config.RateLimitSpaceEPS,
+			SpaceBurst:      s.config.RateLimitSpaceBurst,
+			OrgRate:         s.config.RateLimitOrgEPS,
+			OrgBurst:        s.config.RateLimitOrgBurst,
+			Overflow:        s.config.RateLimitOverflow,
+			SampleRate:      s.config.RateLimitSampleRate,
+			SummaryInterval: s.config.RateLimitSummaryInterval,
+		},
 	}
 
 	LowerAddAppInfo := strings.ToLower(s.config.AddAppInfo)
@@ -191,6 +377,21 @@ func (s *SplunkFirehoseNozzle) EventSink(cache cache.Cache) (eventsink.Sink, err
 		AddSpaceName:   strings.Contains(LowerAddAppInfo, "spacename"),
 		AddSpaceGuid:   strings.Contains(LowerAddAppInfo, "spaceguid"),
 		AddTags:        s.config.AddTags,
+
+		AddAppLabels:        strings.Contains(LowerAddAppInfo, "applabels"),
+		AddAppAnnotations:   strings.Contains(LowerAddAppInfo, "appannotations"),
+		AddSpaceLabels:      strings.Contains(LowerAddAppInfo, "spacelabels"),
+		AddSpaceAnnotations: strings.Contains(LowerAddAppInfo, "spaceannotations"),
+		AddOrgLabels:        strings.Contains(LowerAddAppInfo, "orglabels"),
+		AddOrgAnnotations:   strings.Contains(LowerAddAppInfo, "organnotations"),
+		AddAppState:         strings.Contains(LowerAddAppInfo, "appstate"),
+		AddStack:            strings.Contains(LowerAddAppInfo, "stack"),
+		AddBuildpacks:       strings.Contains(LowerAddAppInfo, "buildpacks"),
+		AddDropletGuid:      strings.Contains(LowerAddAppInfo, "dropletguid"),
+		AddProcessTypes:     strings.Contains(LowerAddAppInfo, "processtypes"),
+		AddInstanceCount:    strings.Contains(LowerAddAppInfo, "instancecount"),
+		MetadataInclude:     events.ParsePrefixes(s.config.MetadataIncludePrefixes),
+		MetadataExclude:     events.ParsePrefixes(s.config.MetadataExcludePrefixes),
 	}
 
 	splunkSink := eventsink.NewSplunk(writers, sinkConfig, parseConfig, cache)
@@ -208,15 +409,23 @@ func (s *SplunkFirehoseNozzle) EventSink(cache cache.Cache) (eventsink.Sink, err
 }
 
 func (s *SplunkFirehoseNozzle) Metric() monitoring.Monitor {
+	endpoints, err := s.EndpointPool()
+	if err != nil {
+		s.logger.Error("Error at configuring Splunk hosts", err)
+	}
 
 	writerConfig := &eventwriter.SplunkConfig{
-		Host:    s.config.SplunkHost,
-		Token:   s.config.SplunkToken,
-		Index:   s.config.SplunkMetricIndex,
-		SkipSSL: s.config.SkipSSLSplunk,
-		Debug:   s.config.Debug,
-		Logger:  s.logger,
-		Version: s.config.Version,
+		Endpoints: endpoints,
+		Host:      s.config.SplunkHost,
+		Token:     s.config.SplunkToken,
+		Index:     s.config.SplunkMetricIndex,
+		SkipSSL:   s.config.SkipSSLSplunk,
+		Debug:     s.config.Debug,
+		Logger:    s.logger,
+		Version:   s.config.Version,
+
+		Compress:         s.config.HecCompression,
+		CompressionLevel: s.config.HecCompressionLevel,
 	}
 	if s.config.StatusMonitorInterval > 0*time.Second && s.config.SelectedMonitoringMetrics != "" {
 		splunkWriter := eventwriter.NewSplunkMetric(writerConfig)
@@ -228,12 +437,67 @@ func (s *SplunkFirehoseNozzle) Metric() monitoring.Monitor {
 }
 
 // EventSource creates eventsource.Source object which can read events from
-func (s *SplunkFirehoseNozzle) EventSource(pcfClient *NozzleCfClient) (*eventsource.Firehose, error) {
+// either the v1 firehose or the RLP gateway. Unless disabled, a source which
+// gives up is replaced by a new one, for the endpoint advertised by the
+// Cloud Controller at that time
+func (s *SplunkFirehoseNozzle) EventSource(pcfClient *NozzleCfClient) (eventsource.Source, error) {
+	source, endpoint, err := s.newEventSource(pcfClient)
+	if err != nil || !s.config.EventSourceReconnect {
+		return source, err
+	}
+
+	factory := func() (eventsource.Source, error) {
+		if source != nil {
+			first := source
+			source = nil
+			return first, nil
+		}
+
+		next, nextEndpoint, err := s.newEventSource(pcfClient)
+		if err != nil {
+			return nil, err
+		}
+		if nextEndpoint != endpoint {
+			s.logger.Info("Event source endpoint changed", lager.Data{"from": endpoint, "to": nextEndpoint})
+			endpoint = nextEndpoint
+		}
+		s.logger.Info("Reconnecting to event source", lager.Data{"endpoint": endpoint})
+		return next, nil
+	}
+
+	reconnectConfig := &eventsource.ReconnectingConfig{
+		MinBackoff: time.Second,
+		MaxBackoff: s.config.ReconnectMaxBackoff,
+	}
+	return eventsource.NewReconnecting(factory, reconnectConfig), nil
+}
+
+// newEventSource creates the configured source for the endpoint currently
+// advertised by the Cloud Controller and returns it with the endpoint
+func (s *SplunkFirehoseNozzle) newEventSource(pcfClient *NozzleCfClient) (eventsource.Source, string, error) {
 	root, err := pcfClient.Root.Get(context.Background())
 	if err != nil {
 		fmt.Printf("Root: %v, err: %s\n", root, err)
-		return nil, fmt.Errorf("failed to get CF root info: %w", err)
+		return nil, "", fmt.Errorf("failed to get CF root info: %w", err)
+	}
+
+	if s.config.EventSource == EventSourceRLPGateway {
+		endpoint := s.config.RLPGatewayEndpoint
+		if endpoint == "" {
+			endpoint = root.Links.LogStream.Href
+		}
+		if endpoint == "" {
+			return nil, "", fmt.Errorf("no RLP gateway endpoint configured or advertised by %s", s.config.ApiEndpoint)
+		}
+		rlpConfig := &eventsource.RLPGatewayConfig{
+			KeepAlive: s.config.KeepAlive,
+			SkipSSL:   s.config.SkipSSLCF,
+			Endpoint:  endpoint,
+			ShardID:   s.config.SubscriptionID,
+		}
+		return eventsource.NewRLPGateway(*pcfClient, rlpConfig), endpoint, nil
 	}
+
 	firehoseConfig := &eventsource.FirehoseConfig{
 		KeepAlive:      s.config.KeepAlive,
 		SkipSSL:        s.config.SkipSSLCF,
@@ -241,7 +505,7 @@ func (s *SplunkFirehoseNozzle) EventSource(pcfClient *NozzleCfClient) (*eventsou
 		SubscriptionID: s.config.SubscriptionID,
 	}
 
-	return eventsource.NewFirehose(*pcfClient, firehoseConfig), nil
+	return eventsource.NewFirehose(*pcfClient, firehoseConfig), firehoseConfig.Endpoint, nil
 }
 
 // Nozzle creates a Nozzle object which glues the event source and event router
@@ -255,8 +519,19 @@ func (s *SplunkFirehoseNozzle) Nozzle(eventSource eventsource.Source, eventRoute
 }
 
 // Run creates all necessary objects, reading events from CF firehose and sending to target Splunk index
-// It runs forever until something goes wrong
-func (s *SplunkFirehoseNozzle) Run(shutdownChan chan os.Signal) error {
+// It runs forever until something goes wrong. The config file is reloaded
+// whenever reloadChan receives a signal
+func (s *SplunkFirehoseNozzle) Run(shutdownChan chan os.Signal, reloadChan chan os.Signal) error {
+	if s.config.MetricsListenAddress != "" {
+		// Must be enabled before anything registers metrics
+		monitoring.EnableExport()
+		server := monitoring.NewServer(s.config.MetricsListenAddress, s.logger)
+		if err := server.Start(); err != nil {
+			s.logger.Error("Failed to start metrics server", err)
+			return err
+		}
+		defer server.Stop()
+	}
 
 	metric := s.Metric()
 
@@ -295,6 +570,24 @@ func (s *SplunkFirehoseNozzle) Run(shutdownChan chan os.Signal) error {
 	}
 	defer appCache.Close()
 
+	if s.config.AdminListenAddress != "" {
+		adminCache, ok := appCache.(admin.Cache)
+		if !ok {
+			return fmt.Errorf("admin server requires the app cache, enable add-app-info")
+		}
+		server := admin.NewServer(s.config.AdminListenAddress, s.config.AdminToken, adminCache, s.logger)
+		if err := server.Start(); err != nil {
+			s.logger.Error("Failed to start admin server", err)
+			return err
+		}
+		defer server.Stop()
+	}
+
+	// Lookups of apps missing from the cache no longer block the workers
+	if s.config.AsyncAppLookup && s.config.AddAppInfo != "" {
+		appCache = cache.NewAsync(appCache, s.config.AppLookupWait, s.config.AppLookupConcurrency)
+	}
+
 	eventSink, err := s.EventSink(appCache)
 	if err != nil {
 		s.logger.Error("Failed to create event sink", nil)
@@ -327,6 +620,12 @@ func (s *SplunkFirehoseNozzle) Run(shutdownChan chan os.Signal) error {
 
 	go metric.Start()
 
+	if s.config.ConfigFile != "" {
+		done := make(chan struct{})
+		defer close(done)
+		go s.watchConfig(reloadChan, done, eventRouter, eventSink)
+	}
+
 	<-shutdownChan
 
 	s.logger.Info("Splunk Nozzle is going to exit gracefully")
@@ -143,7 +143,7 @@ var _ = Describe("SplunkFirehoseNozzle", func() {
 
 	It("Run without cloudcontroller, error out", func() {
 		shutdownChan := make(chan os.Signal, 2)
-		err := noz.Run(shutdownChan)
+		err := noz.Run(shutdownChan, nil)
 		Ω(err).Should(HaveOccurred())
 	})
 
@@ -166,7 +166,7 @@ var _ = Describe("SplunkFirehoseNozzle", func() {
 			time.Sleep(time.Second)
 			shutdownChan <- os.Interrupt
 		}()
-		err := noz.Run(shutdownChan)
+		err := noz.Run(shutdownChan, nil)
 		Ω(err).ShouldNot(HaveOccurred())
 	})
 })
@@ -0,0 +1,113 @@
+package splunknozzle
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventrouter"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/events"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/eventsink"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/monitoring"
+)
+
+// Reload parses the command line, environment and config file again, and
+// applies the reloadable settings to the running event router, event sink and
+// metrics monitor. Nothing is changed when the configuration is invalid
+func (s *SplunkFirehoseNozzle) Reload(router eventrouter.Router, sink eventsink.Sink) error {
+	loaded, err := ParseConfig(s.config.args, s.config.Version, s.config.Branch, s.config.Commit, s.config.BuildOS)
+	if err != nil {
+		return err
+	}
+	if err := loaded.Validate(); err != nil {
+		return err
+	}
+
+	config := *s.config
+	config.WantedEvents = loaded.WantedEvents
+	config.ExtraFields = loaded.ExtraFields
+	config.RoutingRules = loaded.RoutingRules
+	config.IncludeFilter = loaded.IncludeFilter
+	config.ExcludeFilter = loaded.ExcludeFilter
+	config.BatchSize = loaded.BatchSize
+	config.FlushInterval = loaded.FlushInterval
+	config.SelectedMonitoringMetrics = loaded.SelectedMonitoringMetrics
+
+	extraFields, err := events.ParseExtraFields(config.ExtraFields)
+	if err != nil {
+		return err
+	}
+	routes, err := events.ParseRoutingRules(config.RoutingRules)
+	if err != nil {
+		return err
+	}
+
+	if r, ok := router.(eventrouter.Reloader); ok {
+		if err := r.Reload(s.routerConfig(&config)); err != nil {
+			return err
+		}
+	}
+	if splunk, ok := sink.(*eventsink.Splunk); ok {
+		splunk.Reload(&eventsink.SplunkSettings{
+			FlushInterval: config.FlushInterval,
+			BatchSize:     config.BatchSize,
+			ExtraFields:   extraFields,
+			Routes:        routes,
+		})
+	}
+	monitoring.SetSelectedMetrics(config.SelectedMonitoringMetrics)
+
+	s.logger.Info("Reloaded configuration", lager.Data{
+		"config-file":                 config.ConfigFile,
+		"events":                      config.WantedEvents,
+		"extra-fields":                config.ExtraFields,
+		"routing-rules":               routes.Len(),
+		"include-filter":              config.IncludeFilter,
+		"exclude-filter":              config.ExcludeFilter,
+		"hec-batch-size":              config.BatchSize,
+		"flush-interval":              config.FlushInterval.String(),
+		"selected-monitoring-metrics": config.SelectedMonitoringMetrics,
+	})
+	return nil
+}
+
+// watchConfig reloads the config file on SIGHUP, and when it changes if a
+// watch interval is set, until done is closed
+func (s *SplunkFirehoseNozzle) watchConfig(reloadChan <-chan os.Signal, done <-chan struct{}, router eventrouter.Router, sink eventsink.Sink) {
+	var changed <-chan time.Time
+	if s.config.ConfigWatchInterval > 0 {
+		ticker := time.NewTicker(s.config.ConfigWatchInterval)
+		defer ticker.Stop()
+		changed = ticker.C
+	}
+	lastMod := configFileVersion(s.config.ConfigFile)
+
+	for {
+		select {
+		case <-reloadChan:
+		case <-changed:
+			mod := configFileVersion(s.config.ConfigFile)
+			if mod == lastMod {
+				continue
+			}
+			lastMod = mod
+		case <-done:
+			return
+		}
+
+		if err := s.Reload(router, sink); err != nil {
+			s.logger.Error("Failed to reload configuration, keeping the current one", err, lager.Data{"config-file": s.config.ConfigFile})
+		}
+	}
+}
+
+// configFileVersion identifies the content of the file by modification time
+// and size. It also changes when a mounted config map is swapped
+func configFileVersion(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+}
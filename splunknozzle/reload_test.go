@@ -0,0 +1,104 @@
+package splunknozzle_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+	. "github.com/cloudfoundry-community/splunk-firehose-nozzle/splunknozzle"
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/testing"
+	"github.com/cloudfoundry/sonde-go/events"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config reload", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	writeConfig := func(content string) {
+		Expect(os.WriteFile(path, []byte(content), 0600)).To(Succeed())
+	}
+
+	load := func(args ...string) *Config {
+		args = append([]string{
+			"--api-endpoint=http://localhost:9911",
+			"--client-id=admin",
+			"--client-secret=admin",
+			"--splunk-host=localhost:8088",
+			"--splunk-token=token",
+			"--splunk-index=main",
+			"--config-file=" + path,
+		}, args...)
+		c, err := ParseConfig(args, "1.0", "develop", "f1c3178", "Linux")
+		Expect(err).ShouldNot(HaveOccurred())
+		return c
+	}
+
+	BeforeEach(func() {
+		os.Clearenv()
+
+		var err error
+		dir, err = os.MkdirTemp("", "config")
+		Expect(err).ShouldNot(HaveOccurred())
+		path = filepath.Join(dir, "nozzle.yml")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("reloads the running event router", func() {
+		writeConfig("events: [LogMessage]")
+		noz := NewSplunkFirehoseNozzle(load(), lager.NewLogger("test"))
+		sink := testing.NewMemorySinkMock()
+		router, err := noz.EventRouter(cache.NewNoCache(), sink)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		origin := "rep"
+		envelope := &events.Envelope{
+			Origin:    &origin,
+			EventType: events.Envelope_Error.Enum(),
+			Error:     &events.Error{},
+		}
+		router.Route(envelope)
+		Expect(sink.Events).To(BeEmpty())
+
+		writeConfig("events: [LogMessage, Error]")
+		Expect(noz.Reload(router, sink)).To(Succeed())
+		router.Route(envelope)
+		Expect(sink.Events).To(HaveLen(1))
+
+		writeConfig("events: [Bogus]")
+		Expect(noz.Reload(router, sink)).NotTo(Succeed())
+		router.Route(envelope)
+		Expect(sink.Events).To(HaveLen(2))
+
+		writeConfig("unknown: true")
+		Expect(noz.Reload(router, sink)).NotTo(Succeed())
+		router.Route(envelope)
+		Expect(sink.Events).To(HaveLen(3))
+	})
+
+	It("keeps settings given as flags on reload", func() {
+		writeConfig("events: [LogMessage]")
+		noz := NewSplunkFirehoseNozzle(load("--events=LogMessage"), lager.NewLogger("test"))
+		sink := testing.NewMemorySinkMock()
+		router, err := noz.EventRouter(cache.NewNoCache(), sink)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		writeConfig("events: [LogMessage, Error]")
+		Expect(noz.Reload(router, sink)).To(Succeed())
+
+		origin := "rep"
+		router.Route(&events.Envelope{
+			Origin:    &origin,
+			EventType: events.Envelope_Error.Enum(),
+			Error:     &events.Error{},
+		})
+		Expect(sink.Events).To(BeEmpty())
+	})
+})
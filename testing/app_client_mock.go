@@ -4,14 +4,22 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
 	"github.com/cloudfoundry/go-cfclient/v3/resource"
 )
 
 type AppClientMock struct {
 	lock                    sync.RWMutex
 	apps                    map[string]*resource.App
+	names                   map[string]string
+	err                     error
+	delay                   time.Duration
+	auditEvents             []*resource.AuditEvent
 	n                       int
 	listAppsCallCount       int
 	appByGUIDCallCount      int
@@ -22,16 +30,25 @@ type AppClientMock struct {
 func NewAppClientMock(n int) *AppClientMock {
 	apps := getApps(n)
 	return &AppClientMock{
-		apps: apps,
-		n:    n,
+		apps:  apps,
+		names: make(map[string]string),
+		n:     n,
 	}
 }
 
 func (m *AppClientMock) AppByGuid(guid string) (*resource.App, error) {
 	m.lock.RLock()
-	defer m.lock.RUnlock()
+	delay := m.delay
+	m.lock.RUnlock()
+	time.Sleep(delay)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
 	m.appByGUIDCallCount++
+	if m.err != nil {
+		return nil, m.err
+	}
 
 	app, ok := m.apps[guid]
 	if ok {
@@ -41,8 +58,8 @@ func (m *AppClientMock) AppByGuid(guid string) (*resource.App, error) {
 }
 
 func (m *AppClientMock) ListApps() ([]*resource.App, error) {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
 	m.listAppsCallCount++
 
@@ -53,6 +70,60 @@ func (m *AppClientMock) ListApps() ([]*resource.App, error) {
 	return apps, nil
 }
 
+// ListAppsPage pages through the apps updated at or after updatedSince, all
+// apps when zero, ordered by GUID, with their spaces and orgs included
+func (m *AppClientMock) ListAppsPage(page, perPage int, updatedSince time.Time) (*cache.AppPage, error) {
+	m.lock.Lock()
+	m.listAppsCallCount++
+	guids := make([]string, 0, len(m.apps))
+	for guid, app := range m.apps {
+		if !app.UpdatedAt.Before(updatedSince) {
+			guids = append(guids, guid)
+		}
+	}
+	m.lock.Unlock()
+	sort.Strings(guids)
+
+	start := (page - 1) * perPage
+	if start > len(guids) {
+		start = len(guids)
+	}
+	end := start + perPage
+	if end > len(guids) {
+		end = len(guids)
+	}
+
+	result := &cache.AppPage{HasNext: end < len(guids)}
+	spaces := make(map[string]bool)
+	orgs := make(map[string]bool)
+	for _, guid := range guids[start:end] {
+		m.lock.RLock()
+		app, ok := m.apps[guid]
+		m.lock.RUnlock()
+		if !ok {
+			continue
+		}
+		result.Apps = append(result.Apps, app)
+
+		spaceGUID := app.Relationships.Space.Data.GUID
+		if spaces[spaceGUID] {
+			continue
+		}
+		spaces[spaceGUID] = true
+		m.lock.RLock()
+		space := m.space(spaceGUID)
+		result.Spaces = append(result.Spaces, space)
+
+		orgGUID := space.Relationships.Organization.Data.GUID
+		if !orgs[orgGUID] {
+			orgs[orgGUID] = true
+			result.Orgs = append(result.Orgs, m.org(orgGUID))
+		}
+		m.lock.RUnlock()
+	}
+	return result, nil
+}
+
 func (m *AppClientMock) ListAppsByQueryWithLimits(query url.Values, totalPages int) ([]*resource.App, error) {
 	return m.ListApps()
 }
@@ -62,15 +133,19 @@ func (m *AppClientMock) GetSpaceByGuid(spaceGUID string) (*resource.Space, error
 	defer m.lock.Unlock()
 
 	m.getSpaceByGUIDCallCount++
+	return m.space(spaceGUID), nil
+}
 
+func (m *AppClientMock) space(spaceGUID string) *resource.Space {
 	var id int
 	fmt.Sscanf(spaceGUID, "cf_space_id_%d", &id)
 
 	return &resource.Space{
 		Resource:      resource.Resource{GUID: spaceGUID},
-		Name:          fmt.Sprintf("cf_space_name_%d", id),
+		Name:          m.name(spaceGUID, fmt.Sprintf("cf_space_name_%d", id)),
 		Relationships: &resource.SpaceRelationships{Organization: &resource.ToOneRelationship{Data: &resource.Relationship{GUID: fmt.Sprintf("cf_org_id_%d", id)}}},
-	}, nil
+		Metadata:      metadata("tier", fmt.Sprintf("tier_%d", id)),
+	}
 }
 
 func (m *AppClientMock) GetOrgByGuid(orgGUID string) (*resource.Organization, error) {
@@ -78,25 +153,97 @@ func (m *AppClientMock) GetOrgByGuid(orgGUID string) (*resource.Organization, er
 	defer m.lock.Unlock()
 
 	m.getOrgByGUIDCallCount++
+	return m.org(orgGUID), nil
+}
 
+func (m *AppClientMock) org(orgGUID string) *resource.Organization {
 	var id int
 	fmt.Sscanf(orgGUID, "cf_org_id_%d", &id)
 
 	return &resource.Organization{
-		Name:     fmt.Sprintf("cf_org_name_%d", id),
-		Resource: resource.Resource{GUID: orgGUID}}, nil
+		Name:     m.name(orgGUID, fmt.Sprintf("cf_org_name_%d", id)),
+		Resource: resource.Resource{GUID: orgGUID},
+		Metadata: metadata("cost-center", fmt.Sprintf("cc_%d", id))}
+}
+
+func (m *AppClientMock) name(guid, name string) string {
+	if renamed, ok := m.names[guid]; ok {
+		return renamed
+	}
+	return name
+}
+
+// Rename renames the space or org and records its audit event
+func (m *AppClientMock) Rename(guid, name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.names[guid] = name
+	targetType := "space"
+	if strings.HasPrefix(guid, "cf_org_id_") {
+		targetType = "organization"
+	}
+	m.addAuditEvent(fmt.Sprintf("audit.%s.update", targetType), targetType, guid)
+}
+
+// ListAuditEvents lists the audit events of the types created at or after
+// createdSince, oldest first
+func (m *AppClientMock) ListAuditEvents(types []string, createdSince time.Time) ([]*resource.AuditEvent, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var events []*resource.AuditEvent
+	for _, event := range m.auditEvents {
+		if !event.CreatedAt.Before(createdSince) && contains(types, event.Type) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (m *AppClientMock) addAuditEvent(eventType, targetType, targetGUID string) {
+	event := &resource.AuditEvent{
+		Type:     eventType,
+		Target:   resource.AuditEventRelatedObject{GUID: targetGUID, Type: targetType},
+		Resource: resource.Resource{GUID: fmt.Sprintf("audit_event_%d", len(m.auditEvents)), CreatedAt: time.Now()},
+	}
+	m.auditEvents = append(m.auditEvents, event)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 func (m *AppClientMock) GetAppEnvVars(appGUID string) (map[string]*string, error) {
 	return make(map[string]*string), nil
 }
 
+func (m *AppClientMock) GetAppProcesses(appGUID string) ([]*resource.Process, error) {
+	return []*resource.Process{
+		{Type: "web", Instances: 2},
+		{Type: "worker", Instances: 1},
+	}, nil
+}
+
+func (m *AppClientMock) GetCurrentDroplet(appGUID string) (*resource.Droplet, error) {
+	return &resource.Droplet{
+		Resource:   resource.Resource{GUID: "droplet_" + appGUID},
+		Stack:      "cflinuxfs4",
+		Buildpacks: []resource.DetectedBuildpack{{Name: "go_buildpack", Version: "1.10.2"}},
+	}, nil
+}
+
 func (m *AppClientMock) CreateApp(appID, spaceID string) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
 	app := resource.App{
-		Resource:      resource.Resource{GUID: appID},
+		Resource:      resource.Resource{GUID: appID, UpdatedAt: time.Now()},
 		Name:          appID,
 		Relationships: resource.AppRelationships{Space: resource.ToOneRelationship{Data: &resource.Relationship{GUID: spaceID}}},
 		Metadata:      &resource.Metadata{Labels: make(map[string]*string)},
@@ -105,26 +252,65 @@ func (m *AppClientMock) CreateApp(appID, spaceID string) {
 	m.apps[appID] = &app
 }
 
+// RenameApp renames the app, updating its update time
+func (m *AppClientMock) RenameApp(appID, name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	app := *m.apps[appID]
+	app.Name = name
+	app.UpdatedAt = time.Now()
+	m.apps[appID] = &app
+	m.addAuditEvent("audit.app.update", "app", appID)
+}
+
+// Delay makes app lookups take d
+func (m *AppClientMock) Delay(d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.delay = d
+}
+
+// Fail makes app lookups fail with err, or succeed again when nil
+func (m *AppClientMock) Fail(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.err = err
+}
+
 func (m *AppClientMock) DeleteApp(appID string) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	delete(m.apps, appID)
+	m.addAuditEvent("audit.app.delete-request", "app", appID)
 }
 
 func getApps(n int) map[string]*resource.App {
 	apps := make(map[string]*resource.App, n)
+	updatedAt := time.Now()
 	for i := 0; i < n; i++ {
 		app := resource.App{
-			Resource:      resource.Resource{GUID: fmt.Sprintf("cf_app_id_%d", i)},
+			Resource:      resource.Resource{GUID: fmt.Sprintf("cf_app_id_%d", i), UpdatedAt: updatedAt.Add(-time.Duration(i) * time.Minute)},
 			Name:          fmt.Sprintf("cf_app_name_%d", i),
+			State:         "STARTED",
+			Lifecycle:     resource.Lifecycle{Type: "buildpack", BuildpackData: resource.BuildpackLifecycle{Buildpacks: []string{"go_buildpack"}, Stack: "cflinuxfs3"}},
 			Relationships: resource.AppRelationships{Space: resource.ToOneRelationship{Data: &resource.Relationship{GUID: fmt.Sprintf("cf_space_id_%d", i%50)}}},
-			Metadata:      &resource.Metadata{Labels: make(map[string]*string)},
+			Metadata:      metadata("team", fmt.Sprintf("team_%d", i)),
 		}
 		apps[app.GUID] = &app
 	}
 	return apps
 }
 
+// metadata has one label and an owner annotation
+func metadata(label, value string) *resource.Metadata {
+	owner := "owner@example.com"
+	return &resource.Metadata{
+		Labels:      map[string]*string{label: &value},
+		Annotations: map[string]*string{"owner": &owner},
+	}
+}
+
 func (m *AppClientMock) ListAppsCallCount() int {
 	m.lock.Lock()
 	defer m.lock.Unlock()
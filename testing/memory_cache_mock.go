@@ -6,6 +6,7 @@ import (
 
 type MemoryCacheMock struct {
 	ignoreApp bool
+	err       error
 }
 
 func NewMemoryCacheMock() *MemoryCacheMock {
@@ -25,6 +26,10 @@ func (c *MemoryCacheMock) GetAllApps() (map[string]*cache.App, error) {
 }
 
 func (c *MemoryCacheMock) GetApp(appGuid string) (*cache.App, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
 	app := &cache.App{
 		Name:       "testing-app",
 		Guid:       "f964a41c-76ac-42c1-b2ba-663da3ec22d5",
@@ -33,6 +38,20 @@ func (c *MemoryCacheMock) GetApp(appGuid string) (*cache.App, error) {
 		OrgName:    "testing-org",
 		OrgGuid:    "f964a41c-76ac-42c1-b2ba-663da3ec22d7",
 		IgnoredApp: c.ignoreApp,
+
+		Labels:           map[string]string{"team": "payments", "example.com/tier": "gold"},
+		Annotations:      map[string]string{"owner": "payments@example.com"},
+		SpaceLabels:      map[string]string{"env": "prod"},
+		SpaceAnnotations: map[string]string{"contact": "ops@example.com"},
+		OrgLabels:        map[string]string{"cost-center": "cc-42"},
+		OrgAnnotations:   map[string]string{"owner": "finance@example.com"},
+
+		State:         "STARTED",
+		Stack:         "cflinuxfs4",
+		Buildpacks:    []string{"java_buildpack@4.77.0"},
+		DropletGuid:   "9ad4a4b0-1c8e-4bfe-a1b6-0f37a5cdb0f1",
+		ProcessTypes:  []string{"web", "worker"},
+		InstanceCount: 3,
 	}
 
 	return app, nil
@@ -41,3 +60,14 @@ func (c *MemoryCacheMock) GetApp(appGuid string) (*cache.App, error) {
 func (c *MemoryCacheMock) SetIgnoreApp(ignore bool) {
 	c.ignoreApp = ignore
 }
+
+// SetErr makes app lookups fail with err
+func (c *MemoryCacheMock) SetErr(err error) {
+	c.err = err
+}
+
+// GetCachedApp has every app in memory unless lookups fail
+func (c *MemoryCacheMock) GetCachedApp(appGuid string) (*cache.App, bool) {
+	app, err := c.GetApp(appGuid)
+	return app, err == nil
+}
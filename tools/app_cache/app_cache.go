@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/cache"
+	easyjson "github.com/mailru/easyjson"
+	bolt "go.etcd.io/bbolt"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+// compactTxMaxSize bounds the size of each transaction while compacting
+const compactTxMaxSize = 64 * 1024 * 1024
+
+func main() {
+	app := kingpin.New("app_cache", "Inspect and maintain the app info cache file of a stopped nozzle")
+	boltdbPath := app.Flag("boltdb-path", "Bolt Database path").
+		Default("cache.db").Envar("BOLTDB_PATH").String()
+
+	list := app.Command("list", "List the cached apps")
+
+	get := app.Command("get", "Print a cached app as JSON")
+	getGuid := get.Arg("guid", "App GUID").Required().String()
+
+	del := app.Command("delete", "Delete cached apps")
+	delGuids := del.Arg("guid", "App GUIDs").Required().Strings()
+
+	export := app.Command("export", "Export the cached apps as a JSON object keyed by GUID")
+	exportPath := export.Flag("output", "Output file, stdout when empty").Short('o').String()
+
+	imp := app.Command("import", "Import apps exported as a JSON object keyed by GUID")
+	importPath := imp.Arg("file", "Input file, - for stdin").Required().String()
+	importReplace := imp.Flag("replace", "Remove the cached apps missing from the input").Bool()
+
+	compact := app.Command("compact", "Copy the cache into a new file without free pages")
+	compactPath := compact.Arg("output", "Compacted file, replacing the cache file when empty").String()
+
+	stale := app.Command("stale", "Report cached apps missing from a current export, e.g. from dump_app_info or the admin API")
+	stalePath := stale.Arg("current", "Export of the current apps").Required().String()
+
+	verify := app.Command("verify", "Check that every cached record is a valid app")
+
+	var err error
+	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
+	case list.FullCommand():
+		err = listApps(*boltdbPath, os.Stdout)
+	case get.FullCommand():
+		err = getApp(*boltdbPath, *getGuid, os.Stdout)
+	case del.FullCommand():
+		err = deleteApps(*boltdbPath, *delGuids)
+	case export.FullCommand():
+		err = exportApps(*boltdbPath, *exportPath)
+	case imp.FullCommand():
+		err = importApps(*boltdbPath, *importPath, *importReplace)
+	case compact.FullCommand():
+		err = compactCache(*boltdbPath, *compactPath)
+	case stale.FullCommand():
+		err = staleApps(*boltdbPath, *stalePath, os.Stdout)
+	case verify.FullCommand():
+		err = verifyApps(*boltdbPath, os.Stdout)
+	}
+	app.FatalIfError(err, "")
+}
+
+// open opens the cache file, which fails while a nozzle is using it
+func open(path string, readOnly bool) (*bolt.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %s", path, err)
+	}
+	return db, nil
+}
+
+func bucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	b := tx.Bucket([]byte(cache.APP_BUCKET))
+	if b == nil {
+		return nil, fmt.Errorf("no %s bucket", cache.APP_BUCKET)
+	}
+	return b, nil
+}
+
+// readApps returns the cached apps, failing on the first invalid record
+func readApps(path string) (map[string]*cache.App, error) {
+	db, err := open(path, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	apps := make(map[string]*cache.App)
+	err = db.View(func(tx *bolt.Tx) error {
+		b, err := bucket(tx)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(guid, v []byte) error {
+			var app cache.App
+			if err := easyjson.Unmarshal(v, &app); err != nil {
+				return fmt.Errorf("app %s: %s", guid, err)
+			}
+			apps[string(guid)] = &app
+			return nil
+		})
+	})
+	return apps, err
+}
+
+func sortedGuids(apps map[string]*cache.App) []string {
+	guids := make([]string, 0, len(apps))
+	for guid := range apps {
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+	return guids
+}
+
+func listApps(path string, out io.Writer) error {
+	apps, err := readApps(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, "GUID\tNAME\tSPACE\tORG")
+	for _, guid := range sortedGuids(apps) {
+		app := apps[guid]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", guid, app.Name, app.SpaceName, app.OrgName)
+	}
+	return w.Flush()
+}
+
+func getApp(path, guid string, out io.Writer) error {
+	apps, err := readApps(path)
+	if err != nil {
+		return err
+	}
+	app, ok := apps[guid]
+	if !ok {
+		return fmt.Errorf("app %s is not cached", guid)
+	}
+	return writeJSON(out, app)
+}
+
+func deleteApps(path string, guids []string) error {
+	db, err := open(path, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := bucket(tx)
+		if err != nil {
+			return err
+		}
+		for _, guid := range guids {
+			if b.Get([]byte(guid)) == nil {
+				return fmt.Errorf("app %s is not cached", guid)
+			}
+			if err := b.Delete([]byte(guid)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func exportApps(path, output string) error {
+	apps, err := readApps(path)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		return writeJSON(os.Stdout, apps)
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(f, apps); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func importApps(path, input string, replace bool) error {
+	apps, err := readExport(input)
+	if err != nil {
+		return err
+	}
+
+	// A nozzle creates the file and bucket on start, do the same to pre-seed
+	store := cache.NewBoltStore(path)
+	if err := store.Open(); err != nil {
+		return fmt.Errorf("open %s: %s", path, err)
+	}
+	defer store.Close()
+
+	if replace {
+		return store.Replace(apps)
+	}
+	return store.Put(apps)
+}
+
+func compactCache(path, output string) error {
+	src, err := open(path, true)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	target := output
+	if target == "" {
+		target = path + ".compact"
+	}
+	dst, err := bolt.Open(target, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("open %s: %s", target, err)
+	}
+	if err := bolt.Compact(dst, src, compactTxMaxSize); err != nil {
+		dst.Close()
+		os.Remove(target)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	before, _ := os.Stat(path)
+	after, _ := os.Stat(target)
+	fmt.Printf("Compacted %s from %d to %d bytes\n", path, before.Size(), after.Size())
+
+	if output == "" {
+		src.Close()
+		return os.Rename(target, path)
+	}
+	return nil
+}
+
+func staleApps(path, current string, out io.Writer) error {
+	apps, err := readApps(path)
+	if err != nil {
+		return err
+	}
+	currentApps, err := readExport(current)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(out)
+	stale := 0
+	for _, guid := range sortedGuids(apps) {
+		if _, ok := currentApps[guid]; !ok {
+			fmt.Fprintf(w, "%s\t%s\n", guid, apps[guid].Name)
+			stale++
+		}
+	}
+	fmt.Fprintf(w, "%d of %d cached apps are stale\n", stale, len(apps))
+	return w.Flush()
+}
+
+// verifyApps reports every record which is not a valid app stored under
+// its own GUID
+func verifyApps(path string, out io.Writer) error {
+	db, err := open(path, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var records, invalid int
+	err = db.View(func(tx *bolt.Tx) error {
+		b, err := bucket(tx)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(guid, v []byte) error {
+			records++
+			var app cache.App
+			if err := easyjson.Unmarshal(v, &app); err != nil {
+				fmt.Fprintf(out, "%s: %s\n", guid, err)
+				invalid++
+			} else if app.Guid != string(guid) {
+				fmt.Fprintf(out, "%s: stored under GUID %q\n", guid, app.Guid)
+				invalid++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%d of %d records are invalid\n", invalid, records)
+	if invalid > 0 {
+		return errors.New("cache file is corrupted")
+	}
+	return nil
+}
+
+func readExport(path string) (map[string]*cache.App, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var apps map[string]*cache.App
+	if err := json.Unmarshal(data, &apps); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for guid, app := range apps {
+		if app == nil {
+			return nil, fmt.Errorf("%s: app %s is null", path, guid)
+		}
+		if app.Guid == "" {
+			app.Guid = guid
+		}
+		if app.Guid != guid {
+			return nil, fmt.Errorf("%s: app %s has GUID %s", path, guid, app.Guid)
+		}
+	}
+	return apps, nil
+}
+
+func writeJSON(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket is a rate limiter which refills Rate tokens per second up to
+// Burst tokens. It starts full
+type TokenBucket struct {
+	rate  float64
+	burst float64
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a bucket refilling rate tokens per second. A burst
+// below 1 defaults to rate, and at least 1
+func NewTokenBucket(rate, burst float64) *TokenBucket {
+	if burst < 1 {
+		burst = rate
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// refill must be called with the lock held
+func (b *TokenBucket) refill() {
+	now := time.Now()
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// Allow takes a token if one is available
+func (b *TokenBucket) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Wait blocks until a token is available and takes it. The rate must be
+// positive
+func (b *TokenBucket) Wait() {
+	for {
+		b.lock.Lock()
+		b.refill()
+		if b.tokens >= 1 {
+			b.tokens--
+			b.lock.Unlock()
+			return
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.lock.Unlock()
+
+		time.Sleep(wait)
+	}
+}
+
+// Available returns the number of tokens which can be taken right now
+func (b *TokenBucket) Available() float64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill()
+	return b.tokens
+}
+
+// Full reports whether the bucket has refilled completely, i.e. it has not
+// limited anything recently
+func (b *TokenBucket) Full() bool {
+	return b.Available() >= b.burst
+}
@@ -0,0 +1,46 @@
+package utils_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-community/splunk-firehose-nozzle/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TokenBucket", func() {
+	It("allows bursts up to the bucket size", func() {
+		b := utils.NewTokenBucket(1, 3)
+		Expect(b.Full()).To(BeTrue())
+		Expect(b.Allow()).To(BeTrue())
+		Expect(b.Allow()).To(BeTrue())
+		Expect(b.Allow()).To(BeTrue())
+		Expect(b.Allow()).To(BeFalse())
+		Expect(b.Full()).To(BeFalse())
+	})
+
+	It("refills at the configured rate", func() {
+		b := utils.NewTokenBucket(100, 1)
+		Expect(b.Allow()).To(BeTrue())
+		Expect(b.Allow()).To(BeFalse())
+		time.Sleep(20 * time.Millisecond)
+		Expect(b.Allow()).To(BeTrue())
+	})
+
+	It("waits for a token", func() {
+		b := utils.NewTokenBucket(50, 1)
+		start := time.Now()
+		b.Wait()
+		b.Wait()
+		b.Wait()
+		Expect(time.Since(start)).To(BeNumerically(">=", 35*time.Millisecond))
+	})
+
+	It("defaults the burst to the rate", func() {
+		b := utils.NewTokenBucket(5, 0)
+		Expect(b.Available()).To(Equal(float64(5)))
+
+		b = utils.NewTokenBucket(0.5, 0)
+		Expect(b.Available()).To(Equal(float64(1)))
+	})
+})